@@ -0,0 +1,83 @@
+// Package main implements the tool.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/udhos/boilerplate/boilerplate"
+
+	"github.com/udhos/ecs-task-discovery/internal/shared"
+)
+
+func main() {
+	//
+	// command-line
+	//
+	var showVersion bool
+	var scenarioFile string
+	var listen string
+	flag.BoolVar(&showVersion, "version", showVersion, "show version")
+	flag.StringVar(&scenarioFile, "scenario", os.Getenv("SCENARIO"), "scenario file, defaults to env var SCENARIO")
+	flag.StringVar(&listen, "listen", "", "listen address, overrides scenario")
+	flag.Parse()
+
+	me := filepath.Base(os.Args[0])
+
+	//
+	// version
+	//
+	{
+		v := boilerplate.LongVersion(me + " version=" + shared.Version)
+		if showVersion {
+			fmt.Print(v)
+			fmt.Println()
+			return
+		}
+		slog.Info(v)
+	}
+
+	if scenarioFile == "" {
+		fatalf("missing scenario file: use -scenario or env var SCENARIO")
+	}
+
+	sc, errScenario := loadScenario(scenarioFile)
+	if errScenario != nil {
+		fatalf("load scenario: %s: %v", scenarioFile, errScenario)
+	}
+	if listen != "" {
+		sc.Listen = listen
+	}
+
+	sim := newSimulator(sc)
+
+	infof("scenario=%s cluster=%s services=%d timeline_events=%d",
+		scenarioFile, sc.Cluster, len(sc.Services), len(sc.Timeline))
+	infof("ECS API: export AWS_ENDPOINT_URL_ECS=http://localhost%s", sc.Listen)
+	for _, t := range sim.ecs.Tasks() {
+		infof("task %s: service=%s address=%s: export ECS_CONTAINER_METADATA_URI_V4=http://localhost%s/metadata/%s",
+			t.ARN, t.Service, t.Address, sc.Listen, filepath.Base(t.ARN))
+	}
+
+	go sim.run(context.Background())
+
+	server := &http.Server{Addr: sc.Listen, Handler: sim.handler()}
+	infof("listening on %s", sc.Listen)
+	if err := server.ListenAndServe(); err != nil {
+		fatalf("listen: %v", err)
+	}
+}
+
+func fatalf(format string, a ...any) {
+	slog.Error("FATAL: " + fmt.Sprintf(format, a...))
+	os.Exit(1)
+}
+
+func infof(format string, a ...any) {
+	slog.Info(fmt.Sprintf(format, a...))
+}
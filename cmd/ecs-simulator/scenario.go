@@ -0,0 +1,181 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/udhos/ecs-task-discovery/discovery/discoverytest"
+)
+
+// scenario describes the simulated ECS cluster and its timeline of changes.
+// It is read from a YAML file, or a JSON file since YAML is a superset of JSON.
+type scenario struct {
+	Cluster         string           `yaml:"cluster"` // defaults to "demo"
+	Listen          string           `yaml:"listen"`  // defaults to ":8000"
+	TaskDefinitions []taskDefinition `yaml:"task_definitions"`
+	Services        []service        `yaml:"services"`
+	Timeline        []event          `yaml:"timeline"`
+}
+
+type taskDefinition struct {
+	Family     string      `yaml:"family"`
+	Revision   int         `yaml:"revision"`
+	Containers []container `yaml:"containers"`
+}
+
+type container struct {
+	Name         string `yaml:"name"`
+	HealthCheck  bool   `yaml:"health_check"`
+	NonEssential bool   `yaml:"non_essential"`
+}
+
+type service struct {
+	Name           string `yaml:"name"`
+	TaskDefinition string `yaml:"task_definition"` // family:revision
+	Tasks          []task `yaml:"tasks"`
+}
+
+type task struct {
+	ID             string `yaml:"id"`
+	Address        string `yaml:"address"`
+	Health         string `yaml:"health"`          // defaults to HEALTHY
+	Zone           string `yaml:"zone"`            // availability zone
+	TaskDefinition string `yaml:"task_definition"` // defaults to the service task definition
+}
+
+// Timeline actions.
+const (
+	actionAddTask    = "add_task"    // start task in service
+	actionRemoveTask = "remove_task" // stop task
+	actionSetHealth  = "set_health"  // change task and container health status
+	actionDeploy     = "deploy"      // replace all service tasks with tasks from a new task definition
+)
+
+// event is a change applied to the cluster at a time offset from start.
+type event struct {
+	At             time.Duration `yaml:"at"`
+	Action         string        `yaml:"action"`
+	Service        string        `yaml:"service"`         // add_task, deploy
+	Task           task          `yaml:"task"`            // add_task, remove_task, set_health
+	TaskDefinition string        `yaml:"task_definition"` // deploy
+	Tasks          []task        `yaml:"tasks"`           // deploy
+}
+
+func loadScenario(path string) (scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return scenario{}, err
+	}
+	return parseScenario(data)
+}
+
+func parseScenario(data []byte) (scenario, error) {
+	var sc scenario
+	if err := yaml.Unmarshal(data, &sc); err != nil {
+		return sc, fmt.Errorf("scenario: %w", err)
+	}
+	if sc.Cluster == "" {
+		sc.Cluster = "demo"
+	}
+	if sc.Listen == "" {
+		sc.Listen = ":8000"
+	}
+	slices.SortStableFunc(sc.Timeline, func(a, b event) int { return cmp.Compare(a.At, b.At) })
+	return sc, sc.validate()
+}
+
+// validate checks references among task definitions, services and tasks,
+// so that a bad timeline fails at startup instead of in the middle of a run.
+func (sc scenario) validate() error {
+	taskDefs := map[string]bool{}
+	for _, td := range sc.TaskDefinitions {
+		if td.Family == "" || td.Revision < 1 {
+			return fmt.Errorf("scenario: task definition requires family and revision: %+v", td)
+		}
+		taskDefs[td.Family+":"+strconv.Itoa(td.Revision)] = true
+	}
+	checkTaskDef := func(ref string) error {
+		if ref != "" && !taskDefs[ref] {
+			return fmt.Errorf("unknown task definition: '%s'", ref)
+		}
+		return nil
+	}
+
+	services := map[string]bool{}
+	tasks := map[string]bool{}
+	checkTask := func(t task) error {
+		if t.ID == "" || t.Address == "" {
+			return fmt.Errorf("task requires id and address: %+v", t)
+		}
+		if tasks[t.ID] {
+			return fmt.Errorf("duplicate task id: '%s'", t.ID)
+		}
+		tasks[t.ID] = true
+		return checkTaskDef(t.TaskDefinition)
+	}
+
+	for _, svc := range sc.Services {
+		if svc.Name == "" {
+			return fmt.Errorf("scenario: service requires name")
+		}
+		services[svc.Name] = true
+		if err := checkTaskDef(svc.TaskDefinition); err != nil {
+			return fmt.Errorf("scenario: service %s: %w", svc.Name, err)
+		}
+		for _, t := range svc.Tasks {
+			if err := checkTask(t); err != nil {
+				return fmt.Errorf("scenario: service %s: %w", svc.Name, err)
+			}
+		}
+	}
+
+	for i, ev := range sc.Timeline {
+		where := fmt.Sprintf("scenario: timeline event %d (%s at %v)", i+1, ev.Action, ev.At)
+		switch ev.Action {
+		case actionAddTask:
+			if !services[ev.Service] {
+				return fmt.Errorf("%s: unknown service: '%s'", where, ev.Service)
+			}
+			if err := checkTask(ev.Task); err != nil {
+				return fmt.Errorf("%s: %w", where, err)
+			}
+		case actionRemoveTask, actionSetHealth:
+			if !tasks[ev.Task.ID] {
+				return fmt.Errorf("%s: unknown task: '%s'", where, ev.Task.ID)
+			}
+		case actionDeploy:
+			if !services[ev.Service] {
+				return fmt.Errorf("%s: unknown service: '%s'", where, ev.Service)
+			}
+			if err := checkTaskDef(ev.TaskDefinition); err != nil {
+				return fmt.Errorf("%s: %w", where, err)
+			}
+			for _, t := range ev.Tasks {
+				if err := checkTask(t); err != nil {
+					return fmt.Errorf("%s: %w", where, err)
+				}
+			}
+		default:
+			return fmt.Errorf("%s: unknown action", where)
+		}
+	}
+
+	return nil
+}
+
+// taskDefinitionARN converts a family:revision reference into an ARN.
+func taskDefinitionARN(ref string) string {
+	if ref == "" {
+		return ""
+	}
+	family, revision, _ := strings.Cut(ref, ":")
+	rev, _ := strconv.Atoi(revision)
+	return discoverytest.TaskDefinitionARN(family, rev)
+}
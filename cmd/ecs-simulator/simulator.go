@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/udhos/ecs-task-discovery/discovery/discoverytest"
+)
+
+// simulator holds the fake ECS model built from a scenario.
+type simulator struct {
+	scenario scenario
+	ecs      *discoverytest.ECSServer
+	services map[string]*service // current service state, by name
+}
+
+// newSimulator creates the fake ECS model with the initial scenario state.
+func newSimulator(sc scenario) *simulator {
+	s := &simulator{
+		scenario: sc,
+		ecs:      discoverytest.NewECSHandler(),
+		services: map[string]*service{},
+	}
+
+	for _, td := range sc.TaskDefinitions {
+		def := discoverytest.ECSTaskDefinition{ARN: discoverytest.TaskDefinitionARN(td.Family, td.Revision)}
+		for _, c := range td.Containers {
+			def.Containers = append(def.Containers, discoverytest.ECSContainerDefinition{
+				Name:         c.Name,
+				HealthCheck:  c.HealthCheck,
+				NonEssential: c.NonEssential,
+			})
+		}
+		s.ecs.PutTaskDefinition(def)
+	}
+
+	for _, svc := range sc.Services {
+		s.putService(svc)
+		for _, t := range svc.Tasks {
+			s.putTask(svc.Name, t)
+		}
+	}
+
+	return s
+}
+
+func (s *simulator) putService(svc service) {
+	s.services[svc.Name] = &svc
+	s.ecs.PutService(discoverytest.ECSService{
+		Cluster:           s.scenario.Cluster,
+		Name:              svc.Name,
+		TaskDefinitionARN: taskDefinitionARN(svc.TaskDefinition),
+	})
+}
+
+func (s *simulator) taskARN(id string) string {
+	return discoverytest.TaskARN(s.scenario.Cluster, id)
+}
+
+func (s *simulator) putTask(serviceName string, t task) {
+	taskDef := t.TaskDefinition
+	if taskDef == "" {
+		taskDef = s.services[serviceName].TaskDefinition
+	}
+	health := t.Health
+	if health == "" {
+		health = "HEALTHY"
+	}
+	var containers []discoverytest.ECSContainer
+	for _, td := range s.scenario.TaskDefinitions {
+		if fmt.Sprintf("%s:%d", td.Family, td.Revision) != taskDef {
+			continue
+		}
+		for _, c := range td.Containers {
+			containers = append(containers, discoverytest.ECSContainer{Name: c.Name, HealthStatus: health})
+		}
+	}
+	s.ecs.PutTask(discoverytest.ECSTask{
+		ARN:               s.taskARN(t.ID),
+		Cluster:           s.scenario.Cluster,
+		Service:           serviceName,
+		TaskDefinitionARN: taskDefinitionARN(taskDef),
+		Address:           t.Address,
+		HealthStatus:      health,
+		AvailabilityZone:  t.Zone,
+		StartedAt:         time.Now(),
+		Containers:        containers,
+	})
+}
+
+// apply applies a timeline event to the model.
+func (s *simulator) apply(ev event) {
+	switch ev.Action {
+	case actionAddTask:
+		s.putTask(ev.Service, ev.Task)
+		infof("timeline: %s: service=%s task=%s address=%s", ev.Action, ev.Service, ev.Task.ID, ev.Task.Address)
+	case actionRemoveTask:
+		s.ecs.RemoveTask(s.taskARN(ev.Task.ID))
+		infof("timeline: %s: task=%s", ev.Action, ev.Task.ID)
+	case actionSetHealth:
+		arn := s.taskARN(ev.Task.ID)
+		s.ecs.SetTaskHealth(arn, ev.Task.Health)
+		if t, found := s.ecs.Task(arn); found {
+			// containers share the task health, as in putTask
+			for _, c := range t.Containers {
+				s.ecs.SetContainerHealth(arn, c.Name, ev.Task.Health)
+			}
+		}
+		infof("timeline: %s: task=%s health=%s", ev.Action, ev.Task.ID, ev.Task.Health)
+	case actionDeploy:
+		for _, t := range s.ecs.Tasks() {
+			if t.Service == ev.Service {
+				s.ecs.RemoveTask(t.ARN)
+			}
+		}
+		svc := *s.services[ev.Service]
+		if ev.TaskDefinition != "" {
+			svc.TaskDefinition = ev.TaskDefinition
+		}
+		s.putService(svc)
+		for _, t := range ev.Tasks {
+			s.putTask(ev.Service, t)
+		}
+		infof("timeline: %s: service=%s task_definition=%s tasks=%d",
+			ev.Action, ev.Service, svc.TaskDefinition, len(ev.Tasks))
+	}
+}
+
+// run applies timeline events at their time offsets from now,
+// until the timeline ends or ctx is canceled.
+func (s *simulator) run(ctx context.Context) {
+	begin := time.Now()
+	for _, ev := range s.scenario.Timeline {
+		timer := time.NewTimer(time.Until(begin.Add(ev.At)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.apply(ev)
+	}
+	infof("timeline: finished: events=%d", len(s.scenario.Timeline))
+}
+
+// handler serves the ECS API at / and per-task metadata endpoints
+// at /metadata/{task-id}, for use as ECS_CONTAINER_METADATA_URI_V4.
+func (s *simulator) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("POST /", s.ecs)
+	metadata := func(w http.ResponseWriter, r *http.Request) {
+		arn := s.taskARN(r.PathValue("id"))
+		h := discoverytest.MetadataHandler(func() (discoverytest.Task, bool) {
+			return s.ecs.Metadata(arn)
+		})
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = "/" + strings.TrimPrefix(r.PathValue("path"), "/")
+		h.ServeHTTP(w, r2)
+	}
+	mux.HandleFunc("GET /metadata/{id}", metadata)
+	mux.HandleFunc("GET /metadata/{id}/{path...}", metadata)
+	return mux
+}
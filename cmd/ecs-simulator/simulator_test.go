@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+
+	"github.com/udhos/ecs-task-discovery/discovery"
+)
+
+const testScenario = `
+cluster: test
+task_definitions:
+  - family: app
+    revision: 1
+    containers:
+      - name: app
+        health_check: true
+  - family: app
+    revision: 2
+services:
+  - name: svc
+    task_definition: app:1
+    tasks:
+      - {id: t1, address: 127.0.0.1}
+      - {id: t2, address: 127.0.0.2}
+timeline:
+  - {at: 60ms, action: set_health, task: {id: t2, health: UNHEALTHY}}
+  - at: 20ms
+    action: add_task
+    service: svc
+    task: {id: t3, address: 127.0.0.3}
+  - {at: 100ms, action: deploy, service: svc, task_definition: app:2, tasks: [{id: t4, address: 127.0.0.4}]}
+`
+
+func TestSampleScenario(t *testing.T) {
+	sc, err := loadScenario("../../samples/scenario.yaml")
+	if err != nil {
+		t.Fatalf("load sample scenario: %v", err)
+	}
+	if len(sc.Services) != 1 || len(sc.Timeline) != 5 {
+		t.Errorf("unexpected sample scenario: %+v", sc)
+	}
+}
+
+func TestScenarioInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		scenario string
+	}{
+		{"bad yaml", "services: [broken"},
+		{"unknown task definition", "services: [{name: svc, task_definition: app:9}]"},
+		{"task without address", "services: [{name: svc, tasks: [{id: t1}]}]"},
+		{"duplicate task", "services: [{name: svc, tasks: [{id: t1, address: a}, {id: t1, address: b}]}]"},
+		{"unknown service", "timeline: [{at: 1s, action: add_task, service: svc, task: {id: t1, address: a}}]"},
+		{"unknown task", "services: [{name: svc}]\ntimeline: [{at: 1s, action: remove_task, task: {id: t1}}]"},
+		{"unknown action", "timeline: [{at: 1s, action: explode}]"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := parseScenario([]byte(tc.scenario)); err == nil {
+				t.Errorf("expected error for scenario: %s", tc.scenario)
+			}
+		})
+	}
+}
+
+func TestSimulatorTimeline(t *testing.T) {
+	sc, err := parseScenario([]byte(testScenario))
+	if err != nil {
+		t.Fatalf("parse scenario: %v", err)
+	}
+	if sc.Timeline[0].Action != actionAddTask {
+		t.Fatalf("expected timeline sorted by offset: %+v", sc.Timeline)
+	}
+
+	sim := newSimulator(sc)
+
+	sim.run(context.Background())
+
+	tasks := sim.ecs.Tasks()
+	if len(tasks) != 1 || !strings.HasSuffix(tasks[0].ARN, "/t4") ||
+		!strings.HasSuffix(tasks[0].TaskDefinitionARN, "/app:2") {
+		t.Fatalf("expected only deployed task t4, got %+v", tasks)
+	}
+}
+
+func TestSimulatorDiscovery(t *testing.T) {
+	sc, err := parseScenario([]byte(testScenario))
+	if err != nil {
+		t.Fatalf("parse scenario: %v", err)
+	}
+
+	sim := newSimulator(sc)
+
+	server := httptest.NewServer(sim.handler())
+	defer server.Close()
+
+	// per-task metadata endpoint
+	resp, err := http.Get(server.URL + "/metadata/t1/task")
+	if err != nil {
+		t.Fatalf("get metadata: %v", err)
+	}
+	var metadata discovery.TaskMetadata
+	json.NewDecoder(resp.Body).Decode(&metadata)
+	resp.Body.Close()
+	if !strings.HasSuffix(metadata.TaskARN, "/t1") || !strings.HasSuffix(metadata.Cluster, "/test") {
+		t.Fatalf("unexpected metadata: %+v", metadata)
+	}
+	if resp, _ := http.Get(server.URL + "/metadata/missing/task"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected not found for missing task, got %d", resp.StatusCode)
+	}
+
+	// discovery running as task t1
+	t.Setenv("ECS_CONTAINER_METADATA_URI_V4", server.URL+"/metadata/t1")
+
+	ch := make(chan []discovery.Task, 10)
+
+	d, err := discovery.New(discovery.Options{
+		ServiceName: "svc",
+		Client: ecs.NewFromConfig(aws.Config{
+			Region:       "us-east-1",
+			BaseEndpoint: aws.String(server.URL),
+			Credentials:  aws.AnonymousCredentials{},
+		}),
+		DisableAgentQuery: true,
+		Interval:          10 * time.Millisecond,
+		Callback:          func(tasks []discovery.Task) { ch <- tasks },
+	})
+	if err != nil {
+		t.Fatalf("discovery.New: %v", err)
+	}
+	defer d.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sim.run(ctx)
+
+	// follow membership until deployment replaces every task
+	deadline := time.After(3 * time.Second)
+	var sizes []int
+	for {
+		select {
+		case tasks := <-ch:
+			sizes = append(sizes, len(tasks))
+			if len(tasks) == 1 && tasks[0].Address == "127.0.0.4" {
+				if sizes[0] != 2 {
+					t.Errorf("expected initial membership of 2, got sizes %v", sizes)
+				}
+				return
+			}
+		case <-deadline:
+			t.Fatalf("timed out waiting for deployment, membership sizes: %v", sizes)
+		}
+	}
+}
+
+func TestSimulatorSetHealthContainer(t *testing.T) {
+	sc, err := parseScenario([]byte(`
+cluster: test
+task_definitions:
+  - family: app
+    revision: 1
+    containers:
+      - name: app
+        health_check: true
+      - name: sidecar
+services:
+  - name: svc
+    task_definition: app:1
+    tasks:
+      - {id: t1, address: 127.0.0.1}
+      - {id: t2, address: 127.0.0.2}
+timeline:
+  - {at: 30ms, action: set_health, task: {id: t2, health: UNHEALTHY}}
+`))
+	if err != nil {
+		t.Fatalf("parse scenario: %v", err)
+	}
+
+	sim := newSimulator(sc)
+
+	server := httptest.NewServer(sim.handler())
+	defer server.Close()
+
+	t.Setenv("ECS_CONTAINER_METADATA_URI_V4", server.URL+"/metadata/t1")
+
+	ch := make(chan []discovery.Task, 10)
+
+	d, err := discovery.New(discovery.Options{
+		ServiceName: "svc",
+		Client: ecs.NewFromConfig(aws.Config{
+			Region:       "us-east-1",
+			BaseEndpoint: aws.String(server.URL),
+			Credentials:  aws.AnonymousCredentials{},
+		}),
+		DisableAgentQuery: true,
+		HealthContainer:   "app",
+		Interval:          10 * time.Millisecond,
+		Callback:          func(tasks []discovery.Task) { ch <- tasks },
+	})
+	if err != nil {
+		t.Fatalf("discovery.New: %v", err)
+	}
+	defer d.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sim.run(ctx)
+
+	deadline := time.After(3 * time.Second)
+	var sizes []int
+	for {
+		select {
+		case tasks := <-ch:
+			sizes = append(sizes, len(tasks))
+			if len(tasks) == 1 && tasks[0].Address == "127.0.0.1" {
+				status, _ := tasks[0].Containers.Status("app")
+				if status != "HEALTHY" {
+					t.Errorf("expected healthy app container, got %q", status)
+				}
+				return
+			}
+		case <-deadline:
+			t.Fatalf("timed out waiting for unhealthy container exclusion, membership sizes: %v", sizes)
+		}
+	}
+}
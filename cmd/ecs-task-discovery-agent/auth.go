@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/udhos/ecs-task-discovery/discovery"
+)
+
+// errAuthReplay reports an HMAC nonce already seen within the skew window.
+var errAuthReplay = errors.New("replayed request")
+
+// authenticator optionally requires credentials from agent clients.
+// When both bearer token and HMAC secret are configured, either one is accepted.
+// Secret files are read on every request, so rotated secrets are picked up.
+type authenticator struct {
+	token          string
+	tokenFile      string
+	hmacSecret     string
+	hmacSecretFile string
+	maxSkew        time.Duration
+	rejections     *prometheus.CounterVec // nil if metrics are disabled
+	nonces         replayCache
+}
+
+// replayCache remembers HMAC nonces until their timestamp leaves the skew window.
+type replayCache struct {
+	mu        sync.Mutex
+	expire    map[string]time.Time
+	lastPurge time.Time
+}
+
+// add records nonce, valid until expire. It reports false if nonce was already recorded.
+func (c *replayCache) add(nonce string, expire, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.expire == nil {
+		c.expire = map[string]time.Time{}
+	}
+	if now.Sub(c.lastPurge) >= time.Minute {
+		for n, e := range c.expire {
+			if now.After(e) {
+				delete(c.expire, n)
+			}
+		}
+		c.lastPurge = now
+	}
+	if e, found := c.expire[nonce]; found && !now.After(e) {
+		return false
+	}
+	c.expire[nonce] = expire
+	return true
+}
+
+// enabled reports whether any authentication method is configured.
+func (a *authenticator) enabled() bool {
+	return a.token != "" || a.tokenFile != "" || a.hmacSecret != "" || a.hmacSecretFile != ""
+}
+
+// verify checks request credentials.
+func (a *authenticator) verify(r *http.Request) error {
+	token, errToken := discovery.LoadSecret(a.token, a.tokenFile)
+	if errToken != nil {
+		return errToken
+	}
+	secret, errSecret := discovery.LoadSecret(a.hmacSecret, a.hmacSecretFile)
+	if errSecret != nil {
+		return errSecret
+	}
+
+	err := discovery.ErrAuthMissing
+
+	if token != "" {
+		err = discovery.VerifyBearer(r, token)
+		if err == nil {
+			return nil
+		}
+	}
+
+	if secret != "" {
+		now := time.Now()
+		errHMAC := discovery.VerifyHMAC(r, []byte(secret), now, a.maxSkew)
+		if errHMAC == nil {
+			// a valid signature is accepted once while its timestamp is within skew
+			timestamp, _ := strconv.ParseInt(r.Header.Get(discovery.HeaderTimestamp), 10, 64)
+			expire := time.Unix(timestamp, 0).Add(a.maxSkew)
+			if a.nonces.add(r.Header.Get(discovery.HeaderNonce), expire, now) {
+				return nil
+			}
+			errHMAC = errAuthReplay
+		}
+		if errors.Is(err, discovery.ErrAuthMissing) {
+			err = errHMAC // no bearer token was sent: report HMAC failure
+		}
+	}
+
+	return err
+}
+
+// wrap returns handler h protected by authentication.
+// Unauthenticated requests are rejected with 401 and counted by reason.
+func (a *authenticator) wrap(h http.Handler) http.Handler {
+	if !a.enabled() {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := a.verify(r); err != nil {
+			reason := authRejectionReason(err)
+			errorf("auth: rejected: remote=%s path=%s reason=%s: %v",
+				r.RemoteAddr, r.URL.Path, reason, err)
+			if a.rejections != nil {
+				a.rejections.WithLabelValues(reason).Inc()
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ecs-task-discovery-agent"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// transport returns a RoundTripper that adds the agent's own credentials
+// to outgoing requests, so agents authenticate to each other as groupcache peers.
+// It prefers HMAC over bearer token when both are configured.
+func (a *authenticator) transport(base http.RoundTripper) http.RoundTripper {
+	if !a.enabled() {
+		return base
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		secret, errSecret := discovery.LoadSecret(a.hmacSecret, a.hmacSecretFile)
+		if errSecret != nil {
+			return nil, errSecret
+		}
+		token, errToken := discovery.LoadSecret(a.token, a.tokenFile)
+		if errToken != nil {
+			return nil, errToken
+		}
+		req = req.Clone(req.Context()) // RoundTrip must not modify the request
+		switch {
+		case secret != "":
+			discovery.SignHMAC(req, []byte(secret), time.Now())
+		case token != "":
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return base.RoundTrip(req)
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func authRejectionReason(err error) string {
+	switch {
+	case errors.Is(err, discovery.ErrAuthMissing):
+		return "missing"
+	case errors.Is(err, discovery.ErrAuthInvalid):
+		return "invalid"
+	case errors.Is(err, discovery.ErrAuthTimestamp):
+		return "timestamp"
+	case errors.Is(err, errAuthReplay):
+		return "replay"
+	default:
+		return "error"
+	}
+}
+
+func newAuthRejections(registerer prometheus.Registerer) *prometheus.CounterVec {
+	c := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_rejections_total",
+			Help: "Number of agent requests rejected by authentication, by reason.",
+		},
+		[]string{"reason"},
+	)
+	registerer.MustRegister(c)
+	return c
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/udhos/ecs-task-discovery/discovery"
+)
+
+func newAuthTestHandler(a *authenticator) http.Handler {
+	return a.wrap(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+}
+
+func TestAuthDisabledPassesThrough(t *testing.T) {
+	h := newAuthTestHandler(&authenticator{})
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("GET", "/tasks/svc", nil))
+
+	if res.Code != 200 {
+		t.Fatalf("expected status 200, got %d", res.Code)
+	}
+}
+
+func TestAuthBearerToken(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	a := &authenticator{token: "secret-token", rejections: newAuthRejections(registry)}
+	h := newAuthTestHandler(a)
+
+	tests := []struct {
+		name          string
+		authorization string
+		expected      int
+	}{
+		{"valid token", "Bearer secret-token", 200},
+		{"wrong token", "Bearer other", 401},
+		{"missing token", "", 401},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/tasks/svc", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			res := httptest.NewRecorder()
+			h.ServeHTTP(res, req)
+			if res.Code != tt.expected {
+				t.Fatalf("expected status %d, got %d", tt.expected, res.Code)
+			}
+		})
+	}
+
+	if got := testutil.ToFloat64(a.rejections.WithLabelValues("invalid")); got != 1 {
+		t.Fatalf("expected 1 invalid rejection, got %v", got)
+	}
+	if got := testutil.ToFloat64(a.rejections.WithLabelValues("missing")); got != 1 {
+		t.Fatalf("expected 1 missing rejection, got %v", got)
+	}
+}
+
+func TestAuthBearerTokenFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(file, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	h := newAuthTestHandler(&authenticator{tokenFile: file})
+
+	req := httptest.NewRequest("GET", "/tasks/svc", nil)
+	req.Header.Set("Authorization", "Bearer from-file")
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	if res.Code != 200 {
+		t.Fatalf("expected status 200, got %d", res.Code)
+	}
+}
+
+func TestAuthHMAC(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	a := &authenticator{hmacSecret: "hmac-secret", maxSkew: time.Minute, rejections: newAuthRejections(registry)}
+	h := newAuthTestHandler(a)
+
+	t.Run("valid signature", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/tasks/svc", nil)
+		discovery.SignHMAC(req, []byte("hmac-secret"), time.Now())
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		if res.Code != 200 {
+			t.Fatalf("expected status 200, got %d", res.Code)
+		}
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/tasks/svc", nil)
+		discovery.SignHMAC(req, []byte("other"), time.Now())
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		if res.Code != 401 {
+			t.Fatalf("expected status 401, got %d", res.Code)
+		}
+	})
+
+	t.Run("stale timestamp", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/tasks/svc", nil)
+		discovery.SignHMAC(req, []byte("hmac-secret"), time.Now().Add(-time.Hour))
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		if res.Code != 401 {
+			t.Fatalf("expected status 401, got %d", res.Code)
+		}
+	})
+
+	t.Run("replayed request", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/tasks/svc", nil)
+		discovery.SignHMAC(req, []byte("hmac-secret"), time.Now())
+		replay := httptest.NewRequest("GET", "/tasks/svc", nil)
+		replay.Header = req.Header.Clone()
+
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		if res.Code != 200 {
+			t.Fatalf("expected status 200, got %d", res.Code)
+		}
+
+		res = httptest.NewRecorder()
+		h.ServeHTTP(res, replay)
+		if res.Code != 401 {
+			t.Fatalf("expected replay rejected with 401, got %d", res.Code)
+		}
+	})
+
+	t.Run("signature bound to path", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/tasks/svc", nil)
+		discovery.SignHMAC(req, []byte("hmac-secret"), time.Now())
+		other := httptest.NewRequest("GET", "/tasks/other", nil)
+		other.Header = req.Header
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, other)
+		if res.Code != 401 {
+			t.Fatalf("expected status 401, got %d", res.Code)
+		}
+	})
+
+	if got := testutil.ToFloat64(a.rejections.WithLabelValues("timestamp")); got != 1 {
+		t.Fatalf("expected 1 timestamp rejection, got %v", got)
+	}
+	if got := testutil.ToFloat64(a.rejections.WithLabelValues("invalid")); got != 2 {
+		t.Fatalf("expected 2 invalid rejections, got %v", got)
+	}
+	if got := testutil.ToFloat64(a.rejections.WithLabelValues("replay")); got != 1 {
+		t.Fatalf("expected 1 replay rejection, got %v", got)
+	}
+}
+
+func TestReplayCacheExpires(t *testing.T) {
+	var c replayCache
+	now := time.Unix(1_700_000_000, 0)
+
+	if !c.add("n1", now.Add(time.Minute), now) {
+		t.Fatal("expected first nonce accepted")
+	}
+	if c.add("n1", now.Add(time.Minute), now.Add(30*time.Second)) {
+		t.Fatal("expected nonce rejected within skew window")
+	}
+	if !c.add("n1", now.Add(3*time.Minute), now.Add(2*time.Minute)) {
+		t.Fatal("expected expired nonce forgotten")
+	}
+	if len(c.expire) != 1 {
+		t.Fatalf("expected expired nonces purged, got %d", len(c.expire))
+	}
+}
+
+func TestAuthPeerTransport(t *testing.T) {
+	for _, a := range []*authenticator{
+		{hmacSecret: "sec", maxSkew: time.Minute},
+		{token: "tok"},
+	} {
+		server := httptest.NewServer(newAuthTestHandler(a))
+		client := &http.Client{Transport: a.transport(http.DefaultTransport)}
+
+		for range 2 { // fresh nonce per request
+			res, err := client.Get(server.URL + "/_groupcache/tasks/svc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != 200 {
+				t.Fatalf("expected peer request accepted, got %d", res.StatusCode)
+			}
+		}
+
+		res, err := http.Get(server.URL + "/_groupcache/tasks/svc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != 401 {
+			t.Fatalf("expected unauthenticated peer request rejected, got %d", res.StatusCode)
+		}
+
+		server.Close()
+	}
+}
+
+func TestAuthEitherMethodAccepted(t *testing.T) {
+	h := newAuthTestHandler(&authenticator{token: "tok", hmacSecret: "sec", maxSkew: time.Minute})
+
+	req := httptest.NewRequest("GET", "/tasks/svc", nil)
+	discovery.SignHMAC(req, []byte("sec"), time.Now())
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	if res.Code != 200 {
+		t.Fatalf("expected HMAC to be accepted alongside token auth, got %d", res.Code)
+	}
+}
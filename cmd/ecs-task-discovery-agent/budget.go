@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/udhos/ecs-task-discovery/discovery"
+	"github.com/udhos/ecs-task-discovery/groupcachediscovery"
+)
+
+// budgetPool splits a cluster-wide ECS API budget among agent peers.
+// Whenever peers are updated, the local rate limiter is set to
+// budget divided by the number of peers.
+type budgetPool struct {
+	pool    groupcachediscovery.PeerGroup
+	limiter *discovery.RateLimiter
+	budget  float64 // cluster-wide calls per second, zero means unlimited
+}
+
+func newBudgetPool(pool groupcachediscovery.PeerGroup, limiter *discovery.RateLimiter,
+	budget float64) *budgetPool {
+	return &budgetPool{pool: pool, limiter: limiter, budget: budget}
+}
+
+// Set implements groupcachediscovery.PeerGroup.
+func (p *budgetPool) Set(peers ...string) {
+	p.pool.Set(peers...)
+	if p.budget <= 0 {
+		return
+	}
+	rate := p.budget / float64(max(len(peers), 1))
+	p.limiter.SetRate(rate)
+	infof("ecs api budget=%v peers=%d local rate=%v", p.budget, len(peers), rate)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/udhos/ecs-task-discovery/discovery"
+)
+
+type recordPool struct {
+	peers []string
+}
+
+func (p *recordPool) Set(peers ...string) {
+	p.peers = peers
+}
+
+func TestBudgetPoolSplitsBudget(t *testing.T) {
+	limiter := discovery.NewRateLimiter(20, 1)
+	pool := &recordPool{}
+	budget := newBudgetPool(pool, limiter, 20)
+
+	budget.Set("a", "b", "c", "d")
+
+	if len(pool.peers) != 4 {
+		t.Fatalf("expected peers delivered to pool, got %v", pool.peers)
+	}
+	if got := limiter.Rate(); got != 5 {
+		t.Fatalf("expected local rate 5, got %v", got)
+	}
+
+	budget.Set()
+
+	if got := limiter.Rate(); got != 20 {
+		t.Fatalf("expected full budget without peers, got %v", got)
+	}
+}
+
+func TestBudgetPoolUnlimited(t *testing.T) {
+	limiter := discovery.NewRateLimiter(0, 1)
+	budget := newBudgetPool(&recordPool{}, limiter, 0)
+
+	budget.Set("a", "b")
+
+	if got := limiter.Rate(); got != 0 {
+		t.Fatalf("expected unlimited rate, got %v", got)
+	}
+}
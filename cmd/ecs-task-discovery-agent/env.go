@@ -22,6 +22,15 @@ func envString(name string, defaultValue string) string {
 	return defaultValue
 }
 
+// envSecret extracts secret string from env var.
+// It returns empty string if the env var is empty.
+// Only the presence of the value is recorded in logs, never the value itself.
+func envSecret(name string) string {
+	str := os.Getenv(name)
+	infof("%s=[redacted] defined=%t", name, str != "")
+	return str
+}
+
 // envBool extracts duration from env var.
 // It returns the provided defaultValue if the env var is empty.
 // The value returned is also recorded in logs.
@@ -73,6 +82,23 @@ func envInt64(name string, defaultValue int64) int64 {
 	return defaultValue
 }
 
+// envFloat64 extracts float64 value from env var.
+// It returns the provided defaultValue if the env var is empty.
+// The value returned is also recorded in logs.
+func envFloat64(name string, defaultValue float64) float64 {
+	str := os.Getenv(name)
+	if str != "" {
+		value, errConv := strconv.ParseFloat(str, 64)
+		if errConv == nil {
+			infof("%s=[%s] using %s=%v default=%v", name, str, name, value, defaultValue)
+			return value
+		}
+		errorf("bad %s=[%s]: error: %v", name, str, errConv)
+	}
+	infof("%s=[%s] using %s=%v default=%v", name, str, name, defaultValue, defaultValue)
+	return defaultValue
+}
+
 // envHealthCheckMode extracts task definition health check mode from env var.
 // It returns the provided defaultValue if the env var is empty or invalid.
 // The value returned is also recorded in logs.
@@ -84,17 +110,19 @@ func envHealthCheckMode(name string, defaultValue discovery.HealthCheckMode) dis
 	case discovery.HealthCheckModeDetect,
 		discovery.HealthCheckModeDetectAndHandleErrorAsFalse,
 		discovery.HealthCheckModeTrue,
-		discovery.HealthCheckModeFalse:
+		discovery.HealthCheckModeFalse,
+		discovery.HealthCheckModeRevision:
 		infof("%s=[%s] using %s=%s default=%s", name, str, name, mode, defaultValue)
 		return mode
 	default:
-		errorf("bad %s=[%s]: allowed values: %s|%s|%s|%s",
+		errorf("bad %s=[%s]: allowed values: %s|%s|%s|%s|%s",
 			name,
 			str,
 			discovery.HealthCheckModeDetect,
 			discovery.HealthCheckModeDetectAndHandleErrorAsFalse,
 			discovery.HealthCheckModeTrue,
 			discovery.HealthCheckModeFalse,
+			discovery.HealthCheckModeRevision,
 		)
 		infof("%s=[%s] using %s=%s default=%s", name, str, name, defaultValue, defaultValue)
 		return defaultValue
@@ -91,6 +91,28 @@ func TestEnvInt64UsesDefaultOnInvalidValue(t *testing.T) {
 	}
 }
 
+func TestEnvFloat64ParsesValue(t *testing.T) {
+	t.Setenv("TEST_FLOAT64_VALUE", "2.5")
+
+	got := envFloat64("TEST_FLOAT64_VALUE", 7)
+
+	const want = 2.5
+	if got != want {
+		t.Fatalf("envFloat64()=%v want=%v", got, want)
+	}
+}
+
+func TestEnvFloat64UsesDefaultOnInvalidValue(t *testing.T) {
+	t.Setenv("TEST_FLOAT64_INVALID", "bad-number")
+
+	const want = 1.5
+	got := envFloat64("TEST_FLOAT64_INVALID", want)
+
+	if got != want {
+		t.Fatalf("envFloat64()=%v want default=%v", got, want)
+	}
+}
+
 func TestEnvHealthCheckModeValidValue(t *testing.T) {
 	t.Setenv("TEST_HEALTH_MODE_VALID", "TRUE")
 
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+
+	"github.com/udhos/ecs-task-discovery/discovery"
+)
+
+// finders keeps one discovery.TaskFinder per cluster and service,
+// so that incremental describe state survives across requests.
+type finders struct {
+	options discovery.TaskFinderOptions // template for new finders
+
+	mu     sync.Mutex
+	finder map[string]*discovery.TaskFinder
+}
+
+func newFinders(options discovery.TaskFinderOptions) *finders {
+	return &finders{
+		options: options,
+		finder:  map[string]*discovery.TaskFinder{},
+	}
+}
+
+// tasks has the same signature as discovery.Tasks.
+func (f *finders) tasks(ctx context.Context, clientEcs *ecs.Client, clusterName, serviceName string) ([]discovery.Task, error) {
+	key := clusterName + "/" + serviceName
+
+	f.mu.Lock()
+	finder, found := f.finder[key]
+	if !found {
+		options := f.options
+		options.Client = clientEcs
+		options.Cluster = clusterName
+		options.ServiceName = serviceName
+		finder = discovery.NewTaskFinder(options)
+		f.finder[key] = finder
+	}
+	f.mu.Unlock()
+
+	return finder.Tasks(ctx)
+}
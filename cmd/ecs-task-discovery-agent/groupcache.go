@@ -28,13 +28,18 @@ func startGroupcache(app *application) func() {
 	}
 	infof("groupcache my URL: %s", myURL)
 
-	pool := groupcache.NewHTTPPoolOpts(workspace, myURL, &groupcache.HTTPPoolOptions{})
+	pool := groupcache.NewHTTPPoolOpts(workspace, myURL, &groupcache.HTTPPoolOptions{
+		// peers authenticate to each other with the agent credentials
+		Transport: func(context.Context) http.RoundTripper {
+			return app.auth.transport(http.DefaultTransport)
+		},
+	})
 
 	//
 	// start groupcache server
 	//
 
-	app.groupcacheServer = &http.Server{Addr: app.groupcachePort, Handler: pool}
+	app.groupcacheServer = &http.Server{Addr: app.groupcachePort, Handler: app.auth.wrap(pool)}
 
 	go func() {
 		infof("groupcache server: listening on %s", app.groupcachePort)
@@ -52,7 +57,7 @@ func startGroupcache(app *application) func() {
 	)
 
 	discOptions := groupcachediscovery.Options{
-		Pool:                         pool,
+		Pool:                         newBudgetPool(pool, app.rateLimiter, app.ecsAPIBudget),
 		Client:                       app.clientEcs,
 		GroupCachePort:               app.groupcachePort,
 		ServiceName:                  app.ecsTaskDiscoveryAgentService, // self
@@ -63,6 +68,7 @@ func startGroupcache(app *application) func() {
 		EmfEnable:         app.emfEnable,
 		EmfSend:           app.emfSendLogs,
 		AwsConfig:         &app.awsConfig,
+		RateLimiter:       app.rateLimiter,
 	}
 
 	if app.prometheusEnable {
@@ -100,12 +106,15 @@ func startGroupcache(app *application) func() {
 
 	getter := groupcache.GetterFunc(
 		func(c context.Context, key string, dest groupcache.Sink, _ *groupcache.Info) error {
-			data, err := findTasks(c, app.clientEcs, app.clusterName, key)
+			data, err := app.tracedFindTasks(c, key)
 			if err != nil {
 				return err
 			}
-			expire := time.Now().Add(app.cacheTTL)
-			return dest.SetBytes(data, expire)
+			ttl := app.cacheTTL
+			if isPartialPayload(data) {
+				ttl = min(ttl, app.partialCacheTTL) // describe failed tasks again soon
+			}
+			return dest.SetBytes(data, time.Now().Add(ttl))
 		},
 	)
 
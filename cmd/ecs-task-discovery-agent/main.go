@@ -3,14 +3,19 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -20,6 +25,9 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/udhos/boilerplate/awsconfig"
 	"github.com/udhos/boilerplate/boilerplate"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/udhos/ecs-task-discovery/discovery"
 	"github.com/udhos/ecs-task-discovery/internal/shared"
@@ -34,6 +42,7 @@ type application struct {
 	groupcacheSizeBytes                   int64
 	groupcacheEnable                      bool
 	cacheTTL                              time.Duration
+	partialCacheTTL                       time.Duration
 	ecsTaskDiscoveryAgentService          string
 	forceSingleTask                       bool
 	taskDefinitionHealthCheckMode         discovery.HealthCheckMode
@@ -46,16 +55,30 @@ type application struct {
 	prometheusEnable                      bool
 	emfEnable                             bool
 	emfSendLogs                           bool
+	watchInterval                         time.Duration
+	watchTimeout                          time.Duration
+	auth                                  authenticator
+	tlsCertFile                           string
+	tlsKeyFile                            string
+	tlsClientCAFile                       string
+	describeConcurrency                   int
+	incrementalDescribe                   bool
+	fullRefreshInterval                   time.Duration
+	ecsAPIBudget                          float64
+	ecsAPIBurst                           int
 
 	awsConfig        aws.Config
 	clientEcs        *ecs.Client
 	groupcacheServer *http.Server
 	cache            *groupcache.Group
 	registry         *prometheus.Registry
+	watch            *watchHub
+	rateLimiter      *discovery.RateLimiter
+	tracerProvider   trace.TracerProvider // nil means global provider
 
 	// Test seams for deterministic handler testing without ECS/groupcache runtime wiring.
 	findTasksFunc func(ctx context.Context, serviceName string) ([]byte, error)
-	cacheGetFunc  func(ctx context.Context, serviceName string) ([]byte, error)
+	cacheGetFunc  func(ctx context.Context, serviceName string) ([]byte, time.Time, error)
 }
 
 func main() {
@@ -94,6 +117,7 @@ func main() {
 		groupcacheSizeBytes:                   envInt64("GROUPCACHE_SIZE_BYTES", 1_000_000),
 		groupcacheEnable:                      envBool("GROUPCACHE_ENABLE", true),
 		cacheTTL:                              envDuration("CACHE_TTL", 20*time.Second),
+		partialCacheTTL:                       envDuration("PARTIAL_CACHE_TTL", 2*time.Second),
 		ecsTaskDiscoveryAgentService:          envString("ECS_TASK_DISCOVERY_AGENT_SERVICE", "ecs-task-discovery-agent"),
 		forceSingleTask:                       envBool("FORCE_SINGLE_TASK", false),
 		taskDefinitionHealthCheckMode:         envHealthCheckMode("TASK_DEFINITION_HEALTH_CHECK_MODE", discovery.HealthCheckModeDetect),
@@ -106,16 +130,62 @@ func main() {
 		prometheusEnable:                      envBool("PROMETHEUS_ENABLE", true),
 		emfEnable:                             envBool("EMF_ENABLE", false),
 		emfSendLogs:                           envBool("EMF_SEND_LOGS", false),
+		watchInterval:                         envDuration("WATCH_INTERVAL", 2*time.Second),
+		watchTimeout:                          envDuration("WATCH_TIMEOUT", 10*time.Second),
+		describeConcurrency:                   int(envInt64("DESCRIBE_CONCURRENCY", 4)),
+		incrementalDescribe:                   envBool("INCREMENTAL_DESCRIBE", false),
+		fullRefreshInterval:                   envDuration("FULL_REFRESH_INTERVAL", time.Minute),
+		ecsAPIBudget:                          envFloat64("ECS_API_BUDGET", 0),
+		ecsAPIBurst:                           int(envInt64("ECS_API_BURST", 10)),
+		auth: authenticator{
+			token:          envSecret("AUTH_TOKEN"),
+			tokenFile:      envString("AUTH_TOKEN_FILE", ""),
+			hmacSecret:     envSecret("AUTH_HMAC_SECRET"),
+			hmacSecretFile: envString("AUTH_HMAC_SECRET_FILE", ""),
+			maxSkew:        envDuration("AUTH_MAX_SKEW", 5*time.Minute),
+		},
+		tlsCertFile:     envString("TLS_CERT_FILE", ""),
+		tlsKeyFile:      envString("TLS_KEY_FILE", ""),
+		tlsClientCAFile: envString("TLS_CLIENT_CA_FILE", ""),
 
 		awsConfig: mustAwsConfig(),
 	}
 
+	app.watchTimeout = clampWatchTimeout(app.watchTimeout)
+
 	if app.prometheusEnable {
 		app.registry = prometheus.NewRegistry()
+		app.auth.rejections = newAuthRejections(app.registry)
+	}
+
+	tracerProvider, errTracer := newTracerProvider(context.Background(), me)
+	if errTracer != nil {
+		fatalf("tracer provider error: %v", errTracer)
+	}
+	if tracerProvider != nil {
+		infof("tracing: exporting spans with OTLP")
+		defer tracerProvider.Shutdown(context.Background())
+		otel.SetTracerProvider(tracerProvider) // also for groupcache peer discovery
+		app.tracerProvider = tracerProvider
 	}
 
 	app.clientEcs = ecs.NewFromConfig(app.awsConfig)
 
+	// the budget is cluster-wide, each agent gets its share as peers are discovered
+	app.rateLimiter = discovery.NewRateLimiter(app.ecsAPIBudget, app.ecsAPIBurst)
+
+	findersOptions := discovery.TaskFinderOptions{
+		DescribeConcurrency: app.describeConcurrency,
+		Incremental:         app.incrementalDescribe,
+		FullRefreshInterval: app.fullRefreshInterval,
+		RateLimiter:         app.rateLimiter,
+		TracerProvider:      app.tracerProvider,
+	}
+	if app.prometheusEnable {
+		findersOptions.MetricsRegisterer = app.registry
+	}
+	discoveryTasksFunc = newFinders(findersOptions).tasks
+
 	slog.Info(fmt.Sprintf("clusterName: %s", app.clusterName))
 
 	//
@@ -147,11 +217,51 @@ func main() {
 
 	const route = "/tasks/{service}"
 	slog.Info(fmt.Sprintf("registering route: %s", route))
-	http.Handle(route, app)
+	http.Handle(route, app.auth.wrap(app))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	app.watch = newWatchHub(ctx, app.watchFetch, app.watchInterval)
+	const routeWatch = "/watch/{service}"
+	slog.Info(fmt.Sprintf("registering route: %s", routeWatch))
+	http.Handle(routeWatch, app.auth.wrap(http.HandlerFunc(app.handlerWatch)))
+
+	infof("authentication enabled: %t", app.auth.enabled())
+
+	tlsConfig, errTLS := serverTLSConfig(app.tlsCertFile, app.tlsKeyFile, app.tlsClientCAFile)
+	if errTLS != nil {
+		fatalf("tls config error: %v", errTLS)
+	}
+
+	server := &http.Server{Addr: app.listenAddr, TLSConfig: tlsConfig}
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		infof("shutting down")
+		// let pending long polls end by their watch timeout
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), app.watchTimeout+5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			errorf("shutdown: %v", err)
+		}
+	}()
 
-	slog.Info(fmt.Sprintf("listening on HTTP %s", app.listenAddr))
-	err := http.ListenAndServe(app.listenAddr, nil)
-	fatalf("listen error: %v", err)
+	var err error
+	if tlsConfig == nil {
+		slog.Info(fmt.Sprintf("listening on HTTP %s", app.listenAddr))
+		err = server.ListenAndServe()
+	} else {
+		slog.Info(fmt.Sprintf("listening on HTTPS %s mutualTLS=%t", app.listenAddr, app.tlsClientCAFile != ""))
+		err = server.ListenAndServeTLS("", "")
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		fatalf("listen error: %v", err)
+	}
+	<-shutdownDone
+	infof("server stopped")
 }
 
 func handlerHealth(w http.ResponseWriter, _ *http.Request) {
@@ -172,29 +282,27 @@ func (app *application) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	serviceName := r.PathValue("service")
 
-	var data []byte
-	var err error
+	ctx, span := app.tracing().Start(extractTraceContext(r), "agent.ServeHTTP",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("discovery.cluster", app.clusterName),
+			attribute.String("discovery.service", serviceName),
+		))
 
 	begin := time.Now()
 
-	if app.groupcacheEnable {
-		if app.cacheGetFunc != nil {
-			data, err = app.cacheGetFunc(context.TODO(), serviceName)
-		} else {
-			err = app.cache.Get(context.TODO(), serviceName,
-				groupcache.AllocatingByteSliceSink(&data), nil)
-		}
-	} else {
-		if app.findTasksFunc != nil {
-			data, err = app.findTasksFunc(context.TODO(), serviceName)
-		} else {
-			data, err = findTasks(context.TODO(), app.clientEcs, app.clusterName,
-				serviceName)
-		}
+	data, expire, err := app.getTasksExpire(ctx, serviceName)
+
+	var body []byte
+	var failures string
+	if err == nil {
+		body, failures, err = decodeTasks(data)
 	}
 
 	elapsed := time.Since(begin)
 
+	endSpan(span, err)
+
 	infof("%s: cluster=%s service=%s elapsed=%v",
 		me, app.clusterName, serviceName, elapsed)
 
@@ -206,11 +314,87 @@ func (app *application) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tag := etag(data)
+
 	h := w.Header()
-	h.Set("Content-Length", strconv.Itoa(len(data)))
+	h.Set("ETag", tag)
+	h.Set("Cache-Control", "max-age="+strconv.Itoa(maxAge(expire)))
+	if failures != "" {
+		h.Set(discovery.HeaderTaskFailures, failures)
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatch(ifNoneMatch, tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	h.Set("Content-Length", strconv.Itoa(len(body)))
 	h.Set("Content-Type", "application/json; charset=utf-8")
 	h.Set("X-Content-Type-Options", "nosniff")
-	w.Write(data)
+	w.Write(body)
+}
+
+// getTasks retrieves the JSON task list for a service, either from groupcache
+// or directly from ECS API.
+func (app *application) getTasks(ctx context.Context, serviceName string) ([]byte, error) {
+	data, _, err := app.getTasksExpire(ctx, serviceName)
+	return data, err
+}
+
+// getTasksExpire retrieves the JSON task list for a service, together with
+// its cache expiration. Expiration is zero when groupcache is disabled.
+func (app *application) getTasksExpire(ctx context.Context, serviceName string) ([]byte, time.Time, error) {
+	var data []byte
+	var expire time.Time
+	var err error
+
+	if app.groupcacheEnable {
+		if app.cacheGetFunc != nil {
+			data, expire, err = app.cacheGetFunc(ctx, serviceName)
+		} else {
+			getCtx, span := app.tracing().Start(ctx, "groupcache.Get",
+				trace.WithAttributes(attribute.String("groupcache.key", serviceName)))
+			var view groupcache.ByteView
+			err = app.cache.Get(getCtx, serviceName,
+				groupcache.ByteViewSink(&view), nil)
+			data = view.ByteSlice()
+			expire = view.Expire()
+			endSpan(span, err)
+		}
+	} else {
+		data, err = app.tracedFindTasks(ctx, serviceName)
+	}
+
+	return data, expire, err
+}
+
+// etag returns a strong entity tag for data.
+func etag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatch reports whether header If-None-Match matches tag.
+func etagMatch(ifNoneMatch, tag string) bool {
+	for candidate := range strings.SplitSeq(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == tag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// maxAge returns the remaining cache lifetime in whole seconds.
+func maxAge(expire time.Time) int {
+	if expire.IsZero() {
+		return 0
+	}
+	remain := time.Until(expire)
+	if remain <= 0 {
+		return 0
+	}
+	return int(remain.Seconds())
 }
 
 // discoveryTasksFunc is a test seam for findTasks().
@@ -225,12 +409,22 @@ func findTasks(ctx context.Context, clientEcs *ecs.Client, clusterName, serviceN
 
 	elapsed := time.Since(begin)
 
+	var failures []discovery.TaskFailure
+	var partial *discovery.PartialResultError
+	if errors.As(err, &partial) {
+		// serve the tasks that were described, reporting failures to clients
+		errorf("%s: cluster=%s service=%s elapsed=%v serving partial result: %v",
+			me, clusterName, serviceName, elapsed, err)
+		failures = partial.Failures
+		err = nil
+	}
+
 	if err != nil {
 		return nil, fmt.Errorf("%s: cluster=%s service=%s elapsed=%v error:%v",
 			me, clusterName, serviceName, elapsed, err)
 	}
 
-	data, errJSON := json.Marshal(tasks)
+	data, errJSON := encodeTasks(tasks, failures)
 	if errJSON != nil {
 		return nil, fmt.Errorf("%s: cluster=%s service=%s elapsed=%v error:%v",
 			me, clusterName, serviceName, elapsed, errJSON)
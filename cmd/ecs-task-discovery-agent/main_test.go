@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
 	"github.com/prometheus/client_golang/prometheus"
@@ -81,12 +83,12 @@ func TestServeHTTPWithGroupcacheUsesCacheGetter(t *testing.T) {
 	app := &application{
 		clusterName:      "demo",
 		groupcacheEnable: true,
-		cacheGetFunc: func(_ context.Context, serviceName string) ([]byte, error) {
+		cacheGetFunc: func(_ context.Context, serviceName string) ([]byte, time.Time, error) {
 			cacheCalled = true
 			if serviceName != "svc-cache" {
 				t.Fatalf("expected cache serviceName %q, got %q", "svc-cache", serviceName)
 			}
-			return []byte(`[{"arn":"cached"}]`), nil
+			return []byte(`[{"arn":"cached"}]`), time.Time{}, nil
 		},
 		findTasksFunc: func(_ context.Context, _ string) ([]byte, error) {
 			findCalled = true
@@ -170,6 +172,69 @@ func TestFindTasksSuccess(t *testing.T) {
 	}
 }
 
+func TestFindTasksServesPartialResult(t *testing.T) {
+	oldDiscoveryTasksFunc := discoveryTasksFunc
+	discoveryTasksFunc = func(_ context.Context, _ *ecs.Client, _, _ string) ([]discovery.Task, error) {
+		return []discovery.Task{{ARN: "a", Address: "10.0.0.1"}},
+			&discovery.PartialResultError{Failures: []discovery.TaskFailure{{ARN: "b", Reason: "MISSING"}}}
+	}
+	t.Cleanup(func() { discoveryTasksFunc = oldDiscoveryTasksFunc })
+
+	data, err := findTasks(context.Background(), nil, "demo", "svc")
+	if err != nil {
+		t.Fatalf("findTasks() unexpected error: %v", err)
+	}
+
+	if !isPartialPayload(data) {
+		t.Fatalf("expected partial payload, got %q", data)
+	}
+	body, failures, errDecode := decodeTasks(data)
+	if errDecode != nil {
+		t.Fatalf("decodeTasks() error: %v", errDecode)
+	}
+	if !strings.Contains(string(body), `"arn":"a"`) || strings.Contains(string(body), "failures") {
+		t.Fatalf("expected plain task list body, got %q", body)
+	}
+	if !strings.Contains(failures, `"arn":"b"`) {
+		t.Fatalf("expected failed ARN in failures header, got %q", failures)
+	}
+}
+
+func TestServeHTTPPartialResult(t *testing.T) {
+	data, errEncode := encodeTasks([]discovery.Task{{ARN: "a", Address: "10.0.0.1"}},
+		[]discovery.TaskFailure{{ARN: "b", Reason: "MISSING"}})
+	if errEncode != nil {
+		t.Fatalf("encodeTasks() error: %v", errEncode)
+	}
+
+	app := &application{
+		clusterName:      "demo",
+		groupcacheEnable: true,
+		cacheGetFunc: func(_ context.Context, _ string) ([]byte, time.Time, error) {
+			return data, time.Now().Add(2 * time.Second), nil
+		},
+	}
+
+	req := httptest.NewRequest("GET", "/tasks/svc", nil)
+	req.SetPathValue("service", "svc")
+	res := httptest.NewRecorder()
+
+	app.ServeHTTP(res, req)
+
+	if res.Code != 200 {
+		t.Fatalf("expected status 200, got %d", res.Code)
+	}
+	var tasks []discovery.Task
+	if err := json.Unmarshal(res.Body.Bytes(), &tasks); err != nil || len(tasks) != 1 {
+		t.Fatalf("expected plain task list, got %q: %v", res.Body.String(), err)
+	}
+	var failures []discovery.TaskFailure
+	if err := json.Unmarshal([]byte(res.Header().Get(discovery.HeaderTaskFailures)), &failures); err != nil ||
+		len(failures) != 1 || failures[0].ARN != "b" {
+		t.Fatalf("expected failures header, got %q: %v", res.Header().Get(discovery.HeaderTaskFailures), err)
+	}
+}
+
 func TestFindTasksError(t *testing.T) {
 	oldDiscoveryTasksFunc := discoveryTasksFunc
 	discoveryTasksFunc = func(_ context.Context, _ *ecs.Client, _, _ string) ([]discovery.Task, error) {
@@ -187,3 +252,103 @@ func TestFindTasksError(t *testing.T) {
 		t.Fatalf("unexpected findTasks error: %q", errStr)
 	}
 }
+
+func TestServeHTTPETagAndCacheControl(t *testing.T) {
+	app := &application{
+		clusterName:      "demo",
+		groupcacheEnable: true,
+		cacheGetFunc: func(_ context.Context, _ string) ([]byte, time.Time, error) {
+			return []byte(`[{"arn":"cached"}]`), time.Now().Add(15*time.Second + 500*time.Millisecond), nil
+		},
+	}
+
+	req := httptest.NewRequest("GET", "/tasks/svc", nil)
+	req.SetPathValue("service", "svc")
+	res := httptest.NewRecorder()
+
+	app.ServeHTTP(res, req)
+
+	if res.Code != 200 {
+		t.Fatalf("expected status 200, got %d", res.Code)
+	}
+
+	tag := res.Header().Get("ETag")
+	if tag == "" {
+		t.Fatal("missing ETag header")
+	}
+
+	if got := res.Header().Get("Cache-Control"); got != "max-age=15" {
+		t.Fatalf("expected Cache-Control=%q, got %q", "max-age=15", got)
+	}
+
+	// conditional request with matching tag
+
+	req = httptest.NewRequest("GET", "/tasks/svc", nil)
+	req.SetPathValue("service", "svc")
+	req.Header.Set("If-None-Match", tag)
+	res = httptest.NewRecorder()
+
+	app.ServeHTTP(res, req)
+
+	if res.Code != 304 {
+		t.Fatalf("expected status 304, got %d", res.Code)
+	}
+	if res.Body.Len() != 0 {
+		t.Fatalf("expected empty body for 304, got %q", res.Body.String())
+	}
+	if got := res.Header().Get("ETag"); got != tag {
+		t.Fatalf("expected ETag=%q, got %q", tag, got)
+	}
+
+	// conditional request with stale tag
+
+	req = httptest.NewRequest("GET", "/tasks/svc", nil)
+	req.SetPathValue("service", "svc")
+	req.Header.Set("If-None-Match", `"stale"`)
+	res = httptest.NewRecorder()
+
+	app.ServeHTTP(res, req)
+
+	if res.Code != 200 {
+		t.Fatalf("expected status 200 for stale tag, got %d", res.Code)
+	}
+}
+
+func TestServeHTTPWithoutGroupcacheMaxAgeZero(t *testing.T) {
+	app := &application{
+		clusterName:      "demo",
+		groupcacheEnable: false,
+		findTasksFunc: func(_ context.Context, _ string) ([]byte, error) {
+			return []byte(`[]`), nil
+		},
+	}
+
+	req := httptest.NewRequest("GET", "/tasks/svc", nil)
+	req.SetPathValue("service", "svc")
+	res := httptest.NewRecorder()
+
+	app.ServeHTTP(res, req)
+
+	if got := res.Header().Get("Cache-Control"); got != "max-age=0" {
+		t.Fatalf("expected Cache-Control=%q, got %q", "max-age=0", got)
+	}
+}
+
+func TestETagMatch(t *testing.T) {
+	const tag = `"abc"`
+	tests := []struct {
+		ifNoneMatch string
+		expected    bool
+	}{
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"x", "abc"`, true},
+		{`*`, true},
+		{`"x"`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatch(tt.ifNoneMatch, tag); got != tt.expected {
+			t.Errorf("etagMatch(%q): expected=%t got=%t", tt.ifNoneMatch, tt.expected, got)
+		}
+	}
+}
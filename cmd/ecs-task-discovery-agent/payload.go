@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/udhos/ecs-task-discovery/discovery"
+)
+
+// partialPayload is the cached value of a partial task list.
+// A complete task list is cached as a plain JSON list, served as is.
+type partialPayload struct {
+	Tasks    json.RawMessage         `json:"tasks"`
+	Failures []discovery.TaskFailure `json:"failures"`
+}
+
+// encodeTasks encodes a task list as a cached value, along with
+// describe failures, if the list is partial.
+func encodeTasks(tasks []discovery.Task, failures []discovery.TaskFailure) ([]byte, error) {
+	data, err := json.Marshal(tasks)
+	if err != nil || len(failures) == 0 {
+		return data, err
+	}
+	return json.Marshal(partialPayload{Tasks: data, Failures: failures})
+}
+
+// isPartialPayload reports whether a cached value holds a partial task list.
+func isPartialPayload(data []byte) bool {
+	return len(data) > 0 && data[0] == '{'
+}
+
+// decodeTasks splits a cached value into the JSON task list served to
+// clients and the header discovery.HeaderTaskFailures, empty for a
+// complete task list.
+func decodeTasks(data []byte) ([]byte, string, error) {
+	if !isPartialPayload(data) {
+		return data, "", nil
+	}
+	var p partialPayload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, "", err
+	}
+	failures, err := json.Marshal(p.Failures)
+	if err != nil {
+		return nil, "", err
+	}
+	return p.Tasks, string(failures), nil
+}
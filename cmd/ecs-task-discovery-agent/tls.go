@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+
+	"github.com/udhos/ecs-task-discovery/internal/tlsreload"
+)
+
+// serverTLSConfig returns TLS settings for the agent server, or nil if TLS
+// is disabled. Certificate, key and client CA bundle are reloaded on change.
+// If clientCAFile is defined, clients must present a certificate signed by it.
+func serverTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		if clientCAFile != "" {
+			return nil, errors.New("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("TLS requires both TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+
+	cert, errCert := tlsreload.NewCertificate(certFile, keyFile)
+	if errCert != nil {
+		return nil, errCert
+	}
+
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cert.GetCertificate,
+	}
+
+	if clientCAFile == "" {
+		return config, nil
+	}
+
+	pool, errPool := tlsreload.NewCertPool(clientCAFile)
+	if errPool != nil {
+		return nil, errPool
+	}
+
+	// GetConfigForClient picks up reloaded client CA bundle on every handshake.
+	config.GetConfigForClient = func(_ *tls.ClientHelloInfo) (*tls.Config, error) {
+		return &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: cert.GetCertificate,
+			ClientAuth:     tls.RequireAndVerifyClientCert,
+			ClientCAs:      pool.Get(),
+		}, nil
+	}
+
+	return config, nil
+}
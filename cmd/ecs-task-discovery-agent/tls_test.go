@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/udhos/ecs-task-discovery/internal/testcert"
+	"github.com/udhos/ecs-task-discovery/internal/tlsreload"
+)
+
+func TestServerTLSConfigDisabled(t *testing.T) {
+	config, err := serverTLSConfig("", "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config != nil {
+		t.Fatal("expected nil TLS config when TLS is disabled")
+	}
+}
+
+func TestServerTLSConfigInvalid(t *testing.T) {
+	if _, err := serverTLSConfig("cert.pem", "", ""); err == nil {
+		t.Fatal("expected error for missing key file")
+	}
+	if _, err := serverTLSConfig("", "", "ca.pem"); err == nil {
+		t.Fatal("expected error for client CA without server certificate")
+	}
+}
+
+func TestServerMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := testcert.NewCA(t, dir, "ca")
+	serverCert, serverKey := ca.Issue(t, dir, "server")
+	clientCert, clientKey := ca.Issue(t, dir, "client")
+
+	config, err := serverTLSConfig(serverCert, serverKey, ca.File)
+	if err != nil {
+		t.Fatalf("serverTLSConfig() error: %v", err)
+	}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	ts.TLS = config
+	ts.StartTLS()
+	defer ts.Close()
+
+	pool, err := tlsreload.LoadCertPool(ca.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: certs},
+		}}
+	}
+
+	t.Run("client with certificate", func(t *testing.T) {
+		cert, errLoad := tls.LoadX509KeyPair(clientCert, clientKey)
+		if errLoad != nil {
+			t.Fatal(errLoad)
+		}
+		resp, errGet := newClient(cert).Get(ts.URL)
+		if errGet != nil {
+			t.Fatalf("unexpected error: %v", errGet)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Fatalf("expected status 200, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("client without certificate", func(t *testing.T) {
+		resp, errGet := newClient().Get(ts.URL)
+		if errGet == nil {
+			resp.Body.Close()
+			t.Fatal("expected handshake error for client without certificate")
+		}
+	})
+}
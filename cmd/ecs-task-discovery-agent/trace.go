@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/udhos/ecs-task-discovery/cmd/ecs-task-discovery-agent"
+
+// newTracerProvider creates a tracer provider exporting spans with OTLP over
+// HTTP, when env var OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT
+// is defined. The exporter, sampler and resource are further configured by the
+// standard OTEL_* env vars. It returns nil when tracing is not configured.
+func newTracerProvider(ctx context.Context, serviceName string) (*sdktrace.TracerProvider, error) {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") ||
+		(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "") {
+		return nil, nil
+	}
+
+	exporter, errExporter := otlptracehttp.New(ctx)
+	if errExporter != nil {
+		return nil, errExporter
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the default service name
+	res, errResource := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", serviceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if errResource != nil {
+		return nil, errResource
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	), nil
+}
+
+// tracing returns the agent tracer.
+// Without tracerProvider, it uses the global provider.
+func (app *application) tracing() trace.Tracer {
+	provider := app.tracerProvider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return provider.Tracer(tracerName)
+}
+
+// extractTraceContext returns the request context carrying W3C trace
+// context sent by the discovery client. Cancellation is dropped, since
+// a groupcache load may be shared with other requests.
+func extractTraceContext(r *http.Request) context.Context {
+	ctx := context.WithoutCancel(r.Context())
+	return propagation.TraceContext{}.Extract(ctx, propagation.HeaderCarrier(r.Header))
+}
+
+// endSpan records err, if any, and ends span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracedFindTasks finds tasks directly from ECS API within a span.
+func (app *application) tracedFindTasks(ctx context.Context, serviceName string) ([]byte, error) {
+	ctx, span := app.tracing().Start(ctx, "agent.findTasks",
+		trace.WithAttributes(
+			attribute.String("discovery.cluster", app.clusterName),
+			attribute.String("discovery.service", serviceName),
+		))
+
+	var data []byte
+	var err error
+	if app.findTasksFunc != nil {
+		data, err = app.findTasksFunc(ctx, serviceName)
+	} else {
+		data, err = findTasks(ctx, app.clientEcs, app.clusterName, serviceName)
+	}
+
+	endSpan(span, err)
+	return data, err
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestServeHTTPTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	var findSpan trace.SpanContext
+	app := &application{
+		clusterName:    "demo",
+		tracerProvider: provider,
+		findTasksFunc: func(ctx context.Context, _ string) ([]byte, error) {
+			findSpan = trace.SpanContextFromContext(ctx)
+			return []byte(`[]`), nil
+		},
+	}
+
+	const (
+		traceID      = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentSpanID = "00f067aa0ba902b7"
+	)
+
+	req := httptest.NewRequest("GET", "/tasks/svc", nil)
+	req.SetPathValue("service", "svc")
+	req.Header.Set("traceparent", "00-"+traceID+"-"+parentSpanID+"-01")
+	res := httptest.NewRecorder()
+
+	app.ServeHTTP(res, req)
+
+	if res.Code != 200 {
+		t.Fatalf("expected status 200, got %d", res.Code)
+	}
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, s := range exporter.GetSpans() {
+		spans[s.Name] = s
+	}
+
+	server, found := spans["agent.ServeHTTP"]
+	if !found {
+		t.Fatalf("missing agent.ServeHTTP span, got %d spans", len(spans))
+	}
+	if got := server.SpanContext.TraceID().String(); got != traceID {
+		t.Errorf("expected trace id %s from client, got %s", traceID, got)
+	}
+	if got := server.Parent.SpanID().String(); got != parentSpanID {
+		t.Errorf("expected remote parent span %s, got %s", parentSpanID, got)
+	}
+	if server.SpanKind != trace.SpanKindServer {
+		t.Errorf("expected server span kind, got %v", server.SpanKind)
+	}
+
+	find, found := spans["agent.findTasks"]
+	if !found {
+		t.Fatal("missing agent.findTasks span")
+	}
+	if find.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Errorf("expected agent.findTasks to be child of agent.ServeHTTP")
+	}
+	if findSpan.SpanID() != find.SpanContext.SpanID() {
+		t.Errorf("expected findTasks to run within agent.findTasks span")
+	}
+}
+
+func TestNewTracerProviderDisabled(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+
+	provider, err := newTracerProvider(context.Background(), "agent")
+	if err != nil || provider != nil {
+		t.Fatalf("expected no provider without OTLP endpoint, got %v %v", provider, err)
+	}
+}
+
+func TestNewTracerProviderExportsSpans(t *testing.T) {
+	paths := make(chan string, 10)
+	collector := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+	}))
+	defer collector.Close()
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", collector.URL)
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+
+	provider, err := newTracerProvider(context.Background(), "agent")
+	if err != nil || provider == nil {
+		t.Fatalf("expected provider with OTLP endpoint, got %v %v", provider, err)
+	}
+	defer provider.Shutdown(context.Background())
+
+	app := &application{
+		clusterName:    "demo",
+		tracerProvider: provider,
+		findTasksFunc: func(_ context.Context, _ string) ([]byte, error) {
+			return []byte(`[]`), nil
+		},
+	}
+	req := httptest.NewRequest("GET", "/tasks/svc", nil)
+	req.SetPathValue("service", "svc")
+	app.ServeHTTP(httptest.NewRecorder(), req)
+
+	if err := provider.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("ForceFlush() error: %v", err)
+	}
+
+	select {
+	case path := <-paths:
+		if path != "/v1/traces" {
+			t.Errorf("unexpected collector path: %s", path)
+		}
+	default:
+		t.Fatal("expected spans exported to collector")
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/udhos/ecs-task-discovery/discovery"
+)
+
+// maxWatchTimeout caps WATCH_TIMEOUT, so that a long poll always ends
+// before the discovery client gives up on the watch request.
+const maxWatchTimeout = time.Minute
+
+// clampWatchTimeout limits the long poll duration to maxWatchTimeout.
+func clampWatchTimeout(timeout time.Duration) time.Duration {
+	if timeout > maxWatchTimeout {
+		errorf("WATCH_TIMEOUT=%v is above maximum, using %v", timeout, maxWatchTimeout)
+		return maxWatchTimeout
+	}
+	return timeout
+}
+
+// watchFetchTimeout bounds each task list fetch by the watch hub.
+const watchFetchTimeout = 30 * time.Second
+
+// watchFetch retrieves the task list for the watch hub from groupcache,
+// like /tasks, so that watchers add no ECS API load. Watchers see a change
+// once the cached entry expires, within CACHE_TTL.
+func (app *application) watchFetch(ctx context.Context, serviceName string) ([]byte, error) {
+	return app.getTasks(ctx, serviceName)
+}
+
+// watchHub polls the task list of every watched service and notifies
+// watchers whenever the list changes. A service is polled only while
+// it has at least one watcher.
+type watchHub struct {
+	ctx          context.Context // cancelled on shutdown
+	fetch        func(ctx context.Context, serviceName string) ([]byte, error)
+	interval     time.Duration
+	fetchTimeout time.Duration
+
+	mu             sync.Mutex
+	services       map[string]*watchState
+	lastGeneration uint64 // shared by all services, so generations are never reused
+}
+
+// watchState holds the latest snapshot of a watched service.
+type watchState struct {
+	generation uint64        // zero means not fetched yet
+	data       []byte        // JSON task list
+	err        error         // last fetch error
+	changed    chan struct{} // closed when generation changes
+	watchers   int
+}
+
+// notify wakes up all watchers waiting on changed.
+func (st *watchState) notify() {
+	close(st.changed)
+	st.changed = make(chan struct{})
+}
+
+// newWatchHub creates a watch hub. Polling stops when ctx is cancelled.
+func newWatchHub(ctx context.Context, fetch func(ctx context.Context, serviceName string) ([]byte, error),
+	interval time.Duration) *watchHub {
+	return &watchHub{
+		ctx:          ctx,
+		fetch:        fetch,
+		interval:     interval,
+		fetchTimeout: watchFetchTimeout,
+		services:     map[string]*watchState{},
+		// seed from clock, so generations are not reused across agent restarts
+		lastGeneration: uint64(time.Now().UnixNano()),
+	}
+}
+
+// subscribe registers a watcher for service.
+// The caller must call the returned function to unsubscribe.
+func (h *watchHub) subscribe(serviceName string) func() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	st, found := h.services[serviceName]
+	if !found {
+		st = &watchState{changed: make(chan struct{})}
+		h.services[serviceName] = st
+		go h.poll(serviceName, st)
+	}
+	st.watchers++
+
+	return func() {
+		h.mu.Lock()
+		st.watchers--
+		h.mu.Unlock()
+	}
+}
+
+// snapshot returns the current generation, data and last fetch error for
+// a subscribed service, and a channel that is closed on next change.
+func (h *watchHub) snapshot(serviceName string) (uint64, []byte, <-chan struct{}, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st := h.services[serviceName]
+	return st.generation, st.data, st.changed, st.err
+}
+
+// poll refreshes a service snapshot until it has no more watchers,
+// or the hub context is cancelled.
+func (h *watchHub) poll(serviceName string, st *watchState) {
+	const me = "watchHub.poll"
+
+	for {
+		ctx, cancel := context.WithTimeout(h.ctx, h.fetchTimeout)
+		data, err := h.fetch(ctx, serviceName)
+		cancel()
+		if err != nil {
+			errorf("%s: service=%s: %v", me, serviceName, err)
+		}
+
+		h.mu.Lock()
+		switch {
+		case err != nil:
+			if st.err == nil {
+				st.err = err
+				st.notify() // wake up watchers waiting for first snapshot
+			}
+		case st.generation == 0 || !bytes.Equal(data, st.data):
+			h.lastGeneration++
+			st.generation = h.lastGeneration
+			st.data = data
+			st.err = nil
+			st.notify()
+			infof("%s: service=%s generation=%d watchers=%d",
+				me, serviceName, st.generation, st.watchers)
+		default:
+			st.err = nil
+		}
+		if st.watchers < 1 || h.ctx.Err() != nil {
+			delete(h.services, serviceName)
+			h.mu.Unlock()
+			return
+		}
+		h.mu.Unlock()
+
+		select {
+		case <-time.After(h.interval):
+		case <-h.ctx.Done():
+		}
+	}
+}
+
+// handlerWatch streams task list changes for a service.
+//
+// Clients sending "Accept: text/event-stream" receive Server-Sent Events,
+// one "tasks" event per change, with the generation as event id.
+//
+// Other clients perform long-polling with "?since=<generation>": the
+// response is sent as soon as the generation differs from since, or
+// 304 Not Modified is returned after watchTimeout. In both cases the
+// current generation is returned in the header X-Generation. Before the
+// first snapshot exists, the request waits past watchTimeout, until the
+// first fetch either succeeds or fails.
+func (app *application) handlerWatch(w http.ResponseWriter, r *http.Request) {
+	serviceName := r.PathValue("service")
+
+	unsubscribe := app.watch.subscribe(serviceName)
+	defer unsubscribe()
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		app.watchEvents(w, r, serviceName)
+		return
+	}
+
+	app.watchLongPoll(w, r, serviceName)
+}
+
+func (app *application) watchLongPoll(w http.ResponseWriter, r *http.Request, serviceName string) {
+	var since uint64
+	if str := r.URL.Query().Get("since"); str != "" {
+		var errConv error
+		since, errConv = strconv.ParseUint(str, 10, 64)
+		if errConv != nil {
+			http.Error(w, fmt.Sprintf("bad since=%s: %v", str, errConv), 400)
+			return
+		}
+	}
+
+	timer := time.NewTimer(app.watchTimeout)
+	defer timer.Stop()
+	timeout := timer.C
+
+	for {
+		generation, data, changed, err := app.watch.snapshot(serviceName)
+
+		if generation == 0 && err != nil {
+			msg := fmt.Sprintf("watch: service=%s error: %v", serviceName, err)
+			errorf("%s", msg)
+			http.Error(w, msg, 500)
+			return
+		}
+
+		if generation != 0 && generation != since {
+			body, failures, errDecode := decodeTasks(data)
+			if errDecode != nil {
+				msg := fmt.Sprintf("watch: service=%s error: %v", serviceName, errDecode)
+				errorf("%s", msg)
+				http.Error(w, msg, 500)
+				return
+			}
+			h := w.Header()
+			h.Set("X-Generation", strconv.FormatUint(generation, 10))
+			if failures != "" {
+				h.Set(discovery.HeaderTaskFailures, failures)
+			}
+			h.Set("Content-Length", strconv.Itoa(len(body)))
+			h.Set("Content-Type", "application/json; charset=utf-8")
+			h.Set("X-Content-Type-Options", "nosniff")
+			w.Write(body)
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		case <-timeout:
+			if generation == 0 {
+				timeout = nil // no snapshot yet: wait for first fetch
+				continue
+			}
+			w.Header().Set("X-Generation", strconv.FormatUint(generation, 10))
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+}
+
+func (app *application) watchEvents(w http.ResponseWriter, r *http.Request, serviceName string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", 500)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(200)
+	flusher.Flush()
+
+	const heartbeat = 15 * time.Second
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	var sent uint64
+
+	for {
+		generation, data, changed, _ := app.watch.snapshot(serviceName)
+
+		if generation != 0 && generation != sent {
+			body, failures, errDecode := decodeTasks(data)
+			if errDecode != nil {
+				errorf("watch: service=%s error: %v", serviceName, errDecode)
+				return
+			}
+			if failures != "" {
+				// partial list: failures precede the tasks, with the same id
+				fmt.Fprintf(w, "id: %d\nevent: failures\ndata: %s\n\n", generation, failures)
+			}
+			fmt.Fprintf(w, "id: %d\nevent: tasks\ndata: %s\n\n", generation, body)
+			flusher.Flush()
+			sent = generation
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": heartbeat\n\n") // keep idle connections open
+			flusher.Flush()
+		}
+	}
+}
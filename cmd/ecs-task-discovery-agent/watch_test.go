@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/udhos/ecs-task-discovery/discovery"
+)
+
+// fakeTasks is a mutable task list for watch tests.
+type fakeTasks struct {
+	mu   sync.Mutex
+	data string
+	err  error
+}
+
+func (f *fakeTasks) set(data string) {
+	f.mu.Lock()
+	f.data = data
+	f.mu.Unlock()
+}
+
+func (f *fakeTasks) fetch(_ context.Context, _ string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return []byte(f.data), f.err
+}
+
+func newWatchApp(f *fakeTasks, timeout time.Duration) *application {
+	app := &application{watchTimeout: timeout}
+	app.watch = newWatchHub(context.Background(), f.fetch, 10*time.Millisecond)
+	return app
+}
+
+func longPoll(app *application, since string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/watch/svc?since="+since, nil)
+	req.SetPathValue("service", "svc")
+	res := httptest.NewRecorder()
+	app.handlerWatch(res, req)
+	return res
+}
+
+func TestWatchLongPollReturnsCurrentSnapshot(t *testing.T) {
+	f := &fakeTasks{data: `[{"arn":"a"}]`}
+	app := newWatchApp(f, time.Second)
+
+	res := longPoll(app, "0")
+
+	if res.Code != 200 {
+		t.Fatalf("expected status 200, got %d", res.Code)
+	}
+	if got := res.Body.String(); got != `[{"arn":"a"}]` {
+		t.Fatalf("unexpected body: %q", got)
+	}
+	if res.Header().Get("X-Generation") == "" {
+		t.Fatal("missing X-Generation header")
+	}
+}
+
+func TestWatchLongPollPartialResult(t *testing.T) {
+	f := &fakeTasks{data: `{"tasks":[{"arn":"a"}],"failures":[{"arn":"b","reason":"MISSING"}]}`}
+	app := newWatchApp(f, time.Second)
+
+	res := longPoll(app, "0")
+
+	if res.Code != 200 {
+		t.Fatalf("expected status 200, got %d", res.Code)
+	}
+	if got := res.Body.String(); got != `[{"arn":"a"}]` {
+		t.Fatalf("unexpected body: %q", got)
+	}
+	if got := res.Header().Get(discovery.HeaderTaskFailures); got != `[{"arn":"b","reason":"MISSING"}]` {
+		t.Fatalf("unexpected failures header: %q", got)
+	}
+}
+
+func TestWatchLongPollNotModifiedOnTimeout(t *testing.T) {
+	f := &fakeTasks{data: `[{"arn":"a"}]`}
+	app := newWatchApp(f, 50*time.Millisecond)
+
+	first := longPoll(app, "0")
+	generation := first.Header().Get("X-Generation")
+
+	res := longPoll(app, generation)
+
+	if res.Code != http.StatusNotModified {
+		t.Fatalf("expected status 304, got %d", res.Code)
+	}
+	if got := res.Header().Get("X-Generation"); got != generation {
+		t.Fatalf("expected generation %s, got %s", generation, got)
+	}
+}
+
+func TestWatchLongPollWakesUpOnChange(t *testing.T) {
+	f := &fakeTasks{data: `[{"arn":"a"}]`}
+	app := newWatchApp(f, 5*time.Second)
+
+	first := longPoll(app, "0")
+	generation := first.Header().Get("X-Generation")
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		f.set(`[{"arn":"a"},{"arn":"b"}]`)
+	}()
+
+	begin := time.Now()
+	res := longPoll(app, generation)
+	elapsed := time.Since(begin)
+
+	if res.Code != 200 {
+		t.Fatalf("expected status 200, got %d", res.Code)
+	}
+	if got := res.Body.String(); got != `[{"arn":"a"},{"arn":"b"}]` {
+		t.Fatalf("unexpected body: %q", got)
+	}
+	if res.Header().Get("X-Generation") == generation {
+		t.Fatal("expected new generation")
+	}
+	if elapsed > 2*time.Second {
+		t.Fatalf("change delivered too late: %v", elapsed)
+	}
+}
+
+func TestWatchLongPollError(t *testing.T) {
+	f := &fakeTasks{err: errors.New("boom")}
+	app := newWatchApp(f, time.Second)
+
+	res := longPoll(app, "0")
+
+	if res.Code != 500 {
+		t.Fatalf("expected status 500, got %d", res.Code)
+	}
+	if !strings.Contains(res.Body.String(), "boom") {
+		t.Fatalf("expected error body to contain %q, got %q", "boom", res.Body.String())
+	}
+}
+
+func TestWatchLongPollBadSince(t *testing.T) {
+	f := &fakeTasks{data: `[]`}
+	app := newWatchApp(f, time.Second)
+
+	res := longPoll(app, "x")
+
+	if res.Code != 400 {
+		t.Fatalf("expected status 400, got %d", res.Code)
+	}
+}
+
+func TestWatchServerSentEvents(t *testing.T) {
+	f := &fakeTasks{data: `[{"arn":"a"}]`}
+	app := newWatchApp(f, time.Second)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/watch/{service}", app.handlerWatch)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	req, err := http.NewRequest("GET", ts.URL+"/watch/svc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("unexpected Content-Type: %q", got)
+	}
+
+	events := make(chan string, 2)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if data, found := strings.CutPrefix(line, "data: "); found {
+				events <- data
+			}
+		}
+	}()
+
+	expect := func(expected string) {
+		t.Helper()
+		select {
+		case got := <-events:
+			if got != expected {
+				t.Fatalf("event data mismatch: expected=%q got=%q", expected, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for event %q", expected)
+		}
+	}
+
+	expect(`[{"arn":"a"}]`)
+
+	f.set(`[{"arn":"b"}]`)
+
+	expect(`[{"arn":"b"}]`)
+}
+
+func TestWatchHubStopsPollingWithoutWatchers(t *testing.T) {
+	f := &fakeTasks{data: `[]`}
+	hub := newWatchHub(context.Background(), f.fetch, 5*time.Millisecond)
+
+	unsubscribe := hub.subscribe("svc")
+	unsubscribe()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		hub.mu.Lock()
+		size := len(hub.services)
+		hub.mu.Unlock()
+		if size == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected no watched services, got %d", size)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWatchGenerationsAreNotReused(t *testing.T) {
+	f := &fakeTasks{data: `[]`}
+	app := newWatchApp(f, time.Second)
+
+	first, errFirst := strconv.ParseUint(longPoll(app, "0").Header().Get("X-Generation"), 10, 64)
+	if errFirst != nil {
+		t.Fatal(errFirst)
+	}
+
+	f.set(`[{"arn":"a"}]`)
+
+	second, errSecond := strconv.ParseUint(longPoll(app, strconv.FormatUint(first, 10)).Header().Get("X-Generation"), 10, 64)
+	if errSecond != nil {
+		t.Fatal(errSecond)
+	}
+
+	if second <= first {
+		t.Fatalf("expected increasing generation: first=%d second=%d", first, second)
+	}
+}
+
+func TestClampWatchTimeout(t *testing.T) {
+	if got := clampWatchTimeout(10 * time.Second); got != 10*time.Second {
+		t.Errorf("expected timeout kept, got %v", got)
+	}
+	if got := clampWatchTimeout(5 * time.Minute); got != maxWatchTimeout {
+		t.Errorf("expected timeout clamped to %v, got %v", maxWatchTimeout, got)
+	}
+}
+
+func TestWatchFetchUsesCache(t *testing.T) {
+	var ecsCalls int
+	app := &application{
+		groupcacheEnable: true,
+		cacheGetFunc: func(_ context.Context, _ string) ([]byte, time.Time, error) {
+			return []byte(`[{"arn":"cached"}]`), time.Now().Add(time.Minute), nil
+		},
+		findTasksFunc: func(_ context.Context, _ string) ([]byte, error) {
+			ecsCalls++
+			return []byte(`[]`), nil
+		},
+		watchTimeout: time.Second,
+	}
+	app.watch = newWatchHub(context.Background(), app.watchFetch, 10*time.Millisecond)
+
+	res := longPoll(app, "0")
+	if res.Code != 200 {
+		t.Fatalf("expected status 200, got %d", res.Code)
+	}
+	if body := res.Body.String(); body != `[{"arn":"cached"}]` {
+		t.Fatalf("expected cached task list, got %s", body)
+	}
+	if ecsCalls != 0 {
+		t.Fatalf("expected watch served from cache, got %d ECS calls", ecsCalls)
+	}
+}
+
+func TestWatchLongPollWaitsForSlowFirstFetch(t *testing.T) {
+	release := make(chan struct{})
+	fetch := func(_ context.Context, _ string) ([]byte, error) {
+		<-release
+		return []byte(`[{"arn":"a"}]`), nil
+	}
+	app := &application{watchTimeout: 20 * time.Millisecond}
+	app.watch = newWatchHub(context.Background(), fetch, 10*time.Millisecond)
+
+	time.AfterFunc(100*time.Millisecond, func() { close(release) }) // slower than watchTimeout
+
+	res := longPoll(app, "0")
+	if res.Code != 200 {
+		t.Fatalf("expected status 200 after first fetch, got %d", res.Code)
+	}
+	if gen := res.Header().Get("X-Generation"); gen == "" || gen == "0" {
+		t.Fatalf("expected first generation, got %q", gen)
+	}
+}
+
+func TestWatchHubFetchTimeoutAndShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	deadlines := make(chan bool, 10)
+	fetch := func(ctx context.Context, _ string) ([]byte, error) {
+		_, hasDeadline := ctx.Deadline()
+		deadlines <- hasDeadline
+		<-ctx.Done() // hang until cancelled
+		return nil, ctx.Err()
+	}
+	hub := newWatchHub(ctx, fetch, time.Hour)
+
+	unsubscribe := hub.subscribe("svc")
+	defer unsubscribe()
+
+	if !<-deadlines {
+		t.Error("expected fetch bounded by a deadline")
+	}
+
+	cancel() // shutdown
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		hub.mu.Lock()
+		size := len(hub.services)
+		hub.mu.Unlock()
+		if size == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected polling stopped on shutdown")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -70,10 +70,26 @@ func main() {
 		fatalf("invalid task definition health check mode: %s", mode)
 	}
 
+	tasksFile := os.Getenv("TASKS_FILE")
+
 	slog.Info(fmt.Sprintf("SERVICE=%s", service))
 	slog.Info(fmt.Sprintf("TASK_DEFINITION_HEALTH_CHECK_MODE=%s", healthCheckMode))
+	slog.Info(fmt.Sprintf("TASKS_FILE=%s", tasksFile))
 
-	awsConfig := mustAwsConfig()
+	//
+	// tasks come either from a local file or from ECS
+	//
+	var source discovery.TaskSource
+	var client *ecs.Client
+	if tasksFile != "" {
+		fileSource, errFile := discovery.NewFileSource(discovery.FileSourceOptions{Path: tasksFile})
+		if errFile != nil {
+			fatalf("file source: %v", errFile)
+		}
+		source = fileSource
+	} else {
+		client = ecs.NewFromConfig(mustAwsConfig())
+	}
 
 	var count int
 
@@ -82,7 +98,8 @@ func main() {
 			ServiceName:                  service,
 			Callback:                     callback,
 			Interval:                     10 * time.Second,
-			Client:                       ecs.NewFromConfig(awsConfig),
+			Client:                       client,
+			TaskSource:                   source,
 			TaskDefinitionHasHealthCheck: healthCheckMode,
 		})
 		if err != nil {
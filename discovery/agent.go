@@ -0,0 +1,417 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultAgentURL = "http://ecs-task-discovery-agent.%s:8080/tasks"
+	envAgentURL     = "ECS_TASK_DISCOVERY_AGENT_URL"
+	envAgentWatch   = "ECS_TASK_DISCOVERY_AGENT_WATCH"
+
+	defaultAgentCircuitFailures = 3
+	defaultAgentCircuitCooldown = time.Minute
+)
+
+var errAgentCircuitOpen = errors.New("agent circuit breaker is open for all endpoints")
+
+// AgentEndpointStatus reports the health of an agent endpoint.
+type AgentEndpointStatus struct {
+	URL                 string    `json:"url"`
+	CircuitOpen         bool      `json:"circuit_open"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	OpenUntil           time.Time `json:"open_until,omitzero"`
+	LastError           string    `json:"last_error,omitempty"`
+	LastSuccess         time.Time `json:"last_success,omitzero"`
+}
+
+// agentEndpoints tracks circuit breaker state for agent endpoints.
+type agentEndpoints struct {
+	mu        sync.Mutex
+	endpoints map[string]*AgentEndpointStatus
+	order     []string        // endpoints in order of first use
+	open      map[string]bool // circuit state last reported to metrics
+}
+
+// get returns state for url, creating it if needed. Caller must hold mu.
+func (a *agentEndpoints) get(endpoint string) *AgentEndpointStatus {
+	if a.endpoints == nil {
+		a.endpoints = map[string]*AgentEndpointStatus{}
+	}
+	e, found := a.endpoints[endpoint]
+	if !found {
+		e = &AgentEndpointStatus{URL: endpoint}
+		a.endpoints[endpoint] = e
+		a.order = append(a.order, endpoint)
+	}
+	return e
+}
+
+// setAgentCircuit reports a circuit state change of endpoint to metrics.
+// Caller must hold d.agents.mu.
+func (d *Discovery) setAgentCircuit(endpoint string, open bool) {
+	if d.agents.open == nil {
+		d.agents.open = map[string]bool{}
+	}
+	if d.agents.open[endpoint] == open {
+		return
+	}
+	d.agents.open[endpoint] = open
+	d.metrics.agentCircuit(d.options.ServiceName, endpoint, open)
+}
+
+// AgentStatus reports the status of every agent endpoint queried so far.
+func (d *Discovery) AgentStatus() []AgentEndpointStatus {
+	d.agents.mu.Lock()
+	defer d.agents.mu.Unlock()
+	list := make([]AgentEndpointStatus, 0, len(d.agents.order))
+	for _, u := range d.agents.order {
+		e := *d.agents.endpoints[u]
+		e.CircuitOpen = time.Now().Before(e.OpenUntil)
+		list = append(list, e)
+	}
+	return list
+}
+
+// agentCandidates returns urls ordered for querying: the endpoint that
+// last succeeded first, then the others in configured order. Endpoints whose
+// circuit is open are left out. Once cooldown expires, an endpoint is
+// tried again (half-open); a single failure then reopens its circuit.
+func (d *Discovery) agentCandidates(urls []string, now time.Time) []string {
+	d.agents.mu.Lock()
+	defer d.agents.mu.Unlock()
+
+	var candidates []string
+	for _, u := range urls {
+		e := d.agents.get(u)
+		if now.Before(e.OpenUntil) {
+			continue // circuit open
+		}
+		d.setAgentCircuit(u, false) // cooldown expired
+		if u == d.agentCurrentURL {
+			candidates = append([]string{u}, candidates...)
+			continue
+		}
+		candidates = append(candidates, u)
+	}
+	return candidates
+}
+
+// recordAgentResult updates circuit breaker state for an agent endpoint.
+func (d *Discovery) recordAgentResult(endpoint string, err error, now time.Time) {
+	threshold := d.options.AgentCircuitFailures
+	if threshold < 1 {
+		threshold = defaultAgentCircuitFailures
+	}
+	cooldown := d.options.AgentCircuitCooldown
+	if cooldown <= 0 {
+		cooldown = defaultAgentCircuitCooldown
+	}
+
+	d.agents.mu.Lock()
+	defer d.agents.mu.Unlock()
+
+	e := d.agents.get(endpoint)
+
+	if err == nil {
+		if !e.OpenUntil.IsZero() {
+			d.log().Info("agent circuit closed", "agent", endpoint)
+		}
+		e.ConsecutiveFailures = 0
+		e.OpenUntil = time.Time{}
+		e.LastSuccess = now
+		d.metrics.agentResult(d.options.ServiceName, endpoint, true)
+		d.setAgentCircuit(endpoint, false)
+		return
+	}
+
+	e.ConsecutiveFailures++
+	e.LastError = err.Error()
+
+	halfOpen := !e.OpenUntil.IsZero()
+	if halfOpen || e.ConsecutiveFailures >= threshold {
+		until := now.Add(cooldown)
+		e.OpenUntil = until
+		d.log().Error("agent circuit open", "agent", endpoint, "cooldown", cooldown,
+			"consecutive_failures", e.ConsecutiveFailures)
+		d.setAgentCircuit(endpoint, true)
+		time.AfterFunc(cooldown, func() {
+			d.agents.mu.Lock()
+			defer d.agents.mu.Unlock()
+			if e.OpenUntil.Equal(until) {
+				d.setAgentCircuit(endpoint, false) // cooldown expired: half-open
+			}
+		})
+	}
+
+	d.metrics.agentResult(d.options.ServiceName, endpoint, false)
+}
+
+// agentWatch reports whether the agent should be queried in watch mode.
+func (d *Discovery) agentWatch() bool {
+	if d.options.AgentWatch {
+		return true
+	}
+	watch, _ := strconv.ParseBool(os.Getenv(envAgentWatch))
+	return watch
+}
+
+// watchURL derives the agent watch URL from the agent tasks URL.
+// Example: http://agent:8080/tasks => http://agent:8080/watch/{service}?since={generation}
+func watchURL(agentURL, serviceName string, generation uint64) (string, error) {
+	u, errParse := url.Parse(agentURL)
+	if errParse != nil {
+		return "", errParse
+	}
+	u.Path = path.Join(path.Dir(u.Path), "watch", serviceName)
+	u.RawQuery = url.Values{"since": {strconv.FormatUint(generation, 10)}}.Encode()
+	return u.String(), nil
+}
+
+// agentURLs resolves the list of agent URLs.
+func (d *Discovery) agentURLs() []string {
+	defaultURL := fmt.Sprintf(defaultAgentURL, d.clusterName)
+
+	agentURL := d.options.AgentURL
+	if agentURL == "" {
+		agentURL = os.Getenv(envAgentURL)
+		if agentURL == "" {
+			agentURL = defaultURL
+		}
+	}
+
+	d.log().Debug("agent URL resolution", "option", d.options.AgentURL,
+		"env", os.Getenv(envAgentURL), "default", defaultURL, "agent_url", agentURL)
+
+	var urls []string
+	for u := range strings.SplitSeq(agentURL, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// queryAgent queries agent endpoints, starting from the one that last
+// succeeded, and failing over to the next ones. Endpoints with open
+// circuit breaker are skipped.
+func (d *Discovery) queryAgent(ctx context.Context) ([]Task, error) {
+	candidates := d.agentCandidates(d.agentURLs(), time.Now())
+	if len(candidates) == 0 {
+		d.metrics.agentSkipped(d.options.ServiceName)
+		return nil, errAgentCircuitOpen
+	}
+
+	var errs []error
+
+	for _, agentURL := range candidates {
+		tasks, err := d.queryAgentURL(ctx, agentURL)
+		if isPartial(err) {
+			// the agent answered: failures are from ECS API
+			d.recordAgentResult(agentURL, nil, time.Now())
+			return tasks, err
+		}
+		d.recordAgentResult(agentURL, err, time.Now())
+		if err == nil {
+			return tasks, nil
+		}
+		errs = append(errs, err)
+		if d.stopped() {
+			break
+		}
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// queryAgentURL queries a single agent endpoint.
+func (d *Discovery) queryAgentURL(ctx context.Context, agentURL string) (tasks []Task, err error) {
+	const me = "Discovery.queryAgent"
+
+	ctx, span := d.tracing().Start(ctx, "discovery.queryAgent",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("discovery.agent_url", agentURL)))
+	defer func() {
+		span.SetAttributes(attribute.Int("discovery.tasks", len(tasks)))
+		endSpan(span, err)
+	}()
+
+	if agentURL != d.agentCurrentURL {
+		// failover: generation and entity tag belong to previous endpoint
+		d.resetAgentState()
+		d.agentCurrentURL = agentURL
+	}
+
+	watch := d.agentWatch()
+
+	var u string
+	var errURL error
+	if watch {
+		u, errURL = watchURL(agentURL, d.options.ServiceName, d.watchGeneration)
+	} else {
+		u, errURL = url.JoinPath(agentURL, d.options.ServiceName)
+	}
+	if errURL != nil {
+		return nil, errURL
+	}
+
+	ctx, cancel := d.context(ctx)
+	defer cancel()
+
+	client := d.httpClient
+	if watch && d.watchClient != nil {
+		client = d.watchClient
+		var cancelWatch context.CancelFunc
+		ctx, cancelWatch = context.WithTimeout(ctx, agentWatchDeadline)
+		defer cancelWatch()
+	}
+
+	req, errReq := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if errReq != nil {
+		return nil, errReq
+	}
+
+	if errAuth := d.authorizeAgentRequest(req); errAuth != nil {
+		return nil, errAuth
+	}
+
+	injectTraceContext(ctx, req)
+
+	if !watch && d.agentETag != "" {
+		req.Header.Set("If-None-Match", d.agentETag)
+	}
+
+	resp, errGet := client.Do(req)
+	if errGet != nil {
+		recordCall(ctx, RecordedCall{Operation: "agent", URL: u, Error: errGet.Error()})
+		d.resetAgentState()
+		return nil, errGet
+	}
+
+	defer resp.Body.Close()
+
+	body, errBody := io.ReadAll(resp.Body)
+
+	call := RecordedCall{Operation: "agent", URL: u, Status: resp.StatusCode, Response: rawJSON(body)}
+	if errBody != nil {
+		call.Error = errBody.Error()
+	}
+	recordCall(ctx, call)
+
+	if errBody != nil {
+		d.resetAgentState()
+		return nil, fmt.Errorf("%s: status=%d url=%s body_error:%v",
+			me, resp.StatusCode, u, errBody)
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		switch {
+		case watch:
+			// watch timed out without changes, or before the agent
+			// had a first snapshot (generation 0): keep waiting
+			d.agentWatching = true
+			return d.agentTasks, d.agentPartial
+		case !watch && d.agentETag != "":
+			// conditional request: task list unchanged
+			d.agentMaxAge = parseMaxAge(resp.Header.Get("Cache-Control"))
+			return d.agentTasks, d.agentPartial
+		}
+	}
+
+	if resp.StatusCode != 200 {
+		d.resetAgentState()
+		return nil, fmt.Errorf("%s: bad_status=%d url=%s error:%s",
+			me, resp.StatusCode, u, string(body))
+	}
+
+	if errJSON := json.Unmarshal(body, &tasks); errJSON != nil {
+		d.resetAgentState()
+		return nil, fmt.Errorf("%s: status=%d url=%s json_error:%v",
+			me, resp.StatusCode, u, errJSON)
+	}
+
+	partial, errPartial := parseTaskFailures(resp.Header.Get(HeaderTaskFailures))
+	if errPartial != nil {
+		d.resetAgentState()
+		return nil, fmt.Errorf("%s: status=%d url=%s bad %s header: %v",
+			me, resp.StatusCode, u, HeaderTaskFailures, errPartial)
+	}
+
+	if watch {
+		generation, errGen := strconv.ParseUint(resp.Header.Get("X-Generation"), 10, 64)
+		if errGen != nil {
+			d.resetAgentState()
+			return nil, fmt.Errorf("%s: status=%d url=%s bad watch generation: %v",
+				me, resp.StatusCode, u, errGen)
+		}
+		d.watchGeneration = generation
+		d.agentWatching = true
+	} else {
+		d.agentETag = resp.Header.Get("ETag")
+		d.agentMaxAge = parseMaxAge(resp.Header.Get("Cache-Control"))
+	}
+
+	d.agentTasks = tasks
+	d.agentPartial = nil
+
+	if partial != nil {
+		d.agentPartial = partial
+		return tasks, partial
+	}
+
+	return tasks, nil
+}
+
+// parseTaskFailures decodes the describe failures the agent reports
+// for a partial task list. It returns nil for a complete task list.
+func parseTaskFailures(header string) (*PartialResultError, error) {
+	if header == "" {
+		return nil, nil
+	}
+	var failures []TaskFailure
+	if err := json.Unmarshal([]byte(header), &failures); err != nil {
+		return nil, err
+	}
+	if len(failures) == 0 {
+		return nil, nil
+	}
+	return &PartialResultError{Failures: failures}, nil
+}
+
+// resetAgentState forgets agent state, forcing next query to fetch full task list.
+func (d *Discovery) resetAgentState() {
+	d.watchGeneration = 0
+	d.agentETag = ""
+}
+
+// parseMaxAge extracts max-age from a Cache-Control header.
+// It returns zero if max-age is missing or invalid.
+func parseMaxAge(cacheControl string) time.Duration {
+	for directive := range strings.SplitSeq(cacheControl, ",") {
+		value, found := strings.CutPrefix(strings.TrimSpace(directive), "max-age=")
+		if !found {
+			continue
+		}
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	return 0
+}
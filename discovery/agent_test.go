@@ -0,0 +1,527 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestWatchURL(t *testing.T) {
+	got, err := watchURL("http://agent.demo:8080/tasks", "svc", 7)
+	if err != nil {
+		t.Fatalf("watchURL() error: %v", err)
+	}
+	const expected = "http://agent.demo:8080/watch/svc?since=7"
+	if got != expected {
+		t.Fatalf("watchURL() mismatch: expected=%q got=%q", expected, got)
+	}
+}
+
+// fakeWatchAgent emulates the agent long-poll watch endpoint.
+type fakeWatchAgent struct {
+	mu         sync.Mutex
+	generation uint64
+	data       string
+	requests   []string
+}
+
+func (a *fakeWatchAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	a.requests = append(a.requests, r.URL.String())
+	generation := a.generation
+	data := a.data
+	a.mu.Unlock()
+
+	w.Header().Set("X-Generation", strconv.FormatUint(generation, 10))
+
+	if r.URL.Query().Get("since") == strconv.FormatUint(generation, 10) {
+		time.Sleep(20 * time.Millisecond) // emulate long-poll timeout
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Write([]byte(data))
+}
+
+func TestQueryAgentWatch(t *testing.T) {
+	agent := &fakeWatchAgent{
+		generation: 10,
+		data:       `[{"arn":"a","address":"10.0.0.1"}]`,
+	}
+	ts := httptest.NewServer(agent)
+	defer ts.Close()
+
+	d := &Discovery{
+		options: Options{
+			ServiceName: "svc",
+			AgentURL:    ts.URL + "/tasks",
+			AgentWatch:  true,
+		},
+		clusterName: "demo",
+		httpClient:  http.DefaultClient,
+	}
+
+	tasks, err := d.queryAgent(context.Background())
+	if err != nil {
+		t.Fatalf("queryAgent() error: %v", err)
+	}
+	if len(tasks) != 1 || tasks[0].ARN != "a" {
+		t.Fatalf("unexpected tasks: %+v", tasks)
+	}
+	if d.watchGeneration != 10 {
+		t.Fatalf("expected generation 10, got %d", d.watchGeneration)
+	}
+	if !d.agentWatching || d.nextInterval() != 0 {
+		t.Fatal("expected immediate next poll while watching")
+	}
+
+	// not modified: previous tasks are kept
+	tasks, err = d.queryAgent(context.Background())
+	if err != nil {
+		t.Fatalf("queryAgent() error: %v", err)
+	}
+	if len(tasks) != 1 || tasks[0].ARN != "a" {
+		t.Fatalf("unexpected tasks after not modified: %+v", tasks)
+	}
+
+	agent.mu.Lock()
+	requests := append([]string(nil), agent.requests...)
+	agent.mu.Unlock()
+
+	expected := []string{"/watch/svc?since=0", "/watch/svc?since=10"}
+	if len(requests) != len(expected) {
+		t.Fatalf("expected requests %v, got %v", expected, requests)
+	}
+	for i := range expected {
+		if requests[i] != expected[i] {
+			t.Fatalf("request %d: expected=%q got=%q", i, expected[i], requests[i])
+		}
+	}
+}
+
+func TestQueryAgentWatchOutlivesClientTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(100 * time.Millisecond) // long-poll longer than client timeout
+		w.Header().Set("X-Generation", "3")
+		w.Write([]byte(`[{"arn":"a","address":"10.0.0.1"}]`))
+	}))
+	defer ts.Close()
+
+	client := &http.Client{Transport: newTransport(), Timeout: 20 * time.Millisecond}
+
+	d := &Discovery{
+		options: Options{
+			ServiceName: "svc",
+			AgentURL:    ts.URL + "/tasks",
+			AgentWatch:  true,
+		},
+		clusterName: "demo",
+		httpClient:  client,
+		watchClient: newWatchHTTPClient(client),
+	}
+
+	tasks, err := d.queryAgent(context.Background())
+	if err != nil {
+		t.Fatalf("queryAgent() error: %v", err)
+	}
+	if len(tasks) != 1 || d.watchGeneration != 3 {
+		t.Fatalf("unexpected watch result: tasks=%+v generation=%d", tasks, d.watchGeneration)
+	}
+}
+
+func TestQueryAgentWatchBeforeFirstSnapshot(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+		if requests == 1 {
+			// agent first fetch slower than its watch timeout
+			w.Header().Set("X-Generation", "0")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("X-Generation", "5")
+		w.Write([]byte(`[{"arn":"a","address":"10.0.0.1"}]`))
+	}))
+	defer ts.Close()
+
+	d := &Discovery{
+		options: Options{
+			ServiceName: "svc",
+			AgentURL:    ts.URL + "/tasks",
+			AgentWatch:  true,
+		},
+		clusterName: "demo",
+		httpClient:  http.DefaultClient,
+	}
+
+	tasks, err := d.queryAgent(context.Background())
+	if err != nil {
+		t.Fatalf("queryAgent() error before first snapshot: %v", err)
+	}
+	if len(tasks) != 0 || !d.agentWatching {
+		t.Fatalf("expected to keep watching without tasks, got tasks=%+v watching=%t", tasks, d.agentWatching)
+	}
+	if status := d.AgentStatus(); len(status) != 1 || status[0].ConsecutiveFailures != 0 || status[0].LastSuccess.IsZero() {
+		t.Fatalf("expected no circuit breaker failure, got %+v", status)
+	}
+
+	tasks, err = d.queryAgent(context.Background())
+	if err != nil || len(tasks) != 1 || d.watchGeneration != 5 {
+		t.Fatalf("expected first snapshot, got tasks=%+v err=%v generation=%d", tasks, err, d.watchGeneration)
+	}
+}
+
+func TestQueryAgentWatchEnv(t *testing.T) {
+	t.Setenv(envAgentWatch, "true")
+
+	transport := &captureTransport{}
+	d := &Discovery{
+		options:     Options{ServiceName: "svc", AgentURL: "http://agent.example/tasks"},
+		clusterName: "demo",
+		httpClient:  &http.Client{Transport: transport},
+	}
+
+	// captureTransport does not send X-Generation, so the query must fail
+	if _, err := d.queryAgent(context.Background()); err == nil {
+		t.Fatal("expected error for missing watch generation, got nil")
+	}
+
+	const expected = "http://agent.example/watch/svc?since=0"
+	if transport.requestedURL != expected {
+		t.Fatalf("queryAgent() URL mismatch: expected=%q got=%q", expected, transport.requestedURL)
+	}
+}
+
+func TestDiscoveryStopAbortsAgentWatch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done() // hang like a long-poll
+	}))
+	defer ts.Close()
+
+	d := &Discovery{
+		options: Options{
+			ServiceName: "svc",
+			AgentURL:    ts.URL + "/tasks",
+			AgentWatch:  true,
+			Interval:    time.Minute,
+			Callback:    func(_ []Task) {},
+		},
+		clusterName: "demo",
+		httpClient:  http.DefaultClient,
+		done:        make(chan struct{}),
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		d.run()
+		close(exited)
+	}()
+
+	time.Sleep(50 * time.Millisecond) // let the watch request start
+
+	d.Stop()
+
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatal("Discovery.run did not stop during agent watch")
+	}
+}
+
+func TestQueryAgentConditionalRequest(t *testing.T) {
+	var ifNoneMatch []string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifNoneMatch = append(ifNoneMatch, r.Header.Get("If-None-Match"))
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age=30")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(`[{"arn":"a","address":"10.0.0.1"}]`))
+	}))
+	defer ts.Close()
+
+	d := &Discovery{
+		options: Options{
+			ServiceName: "svc",
+			AgentURL:    ts.URL + "/tasks",
+			Interval:    20 * time.Second,
+		},
+		clusterName: "demo",
+		httpClient:  http.DefaultClient,
+	}
+
+	for i := range 2 {
+		tasks, _ := d.listTasks(context.Background())
+		if len(tasks) != 1 || tasks[0].ARN != "a" {
+			t.Fatalf("poll %d: unexpected tasks: %+v", i, tasks)
+		}
+		if got := d.nextInterval(); got != 30*time.Second {
+			t.Fatalf("poll %d: expected next interval from max-age=30s, got %v", i, got)
+		}
+	}
+
+	expected := []string{"", `"v1"`}
+	if len(ifNoneMatch) != len(expected) || ifNoneMatch[0] != expected[0] || ifNoneMatch[1] != expected[1] {
+		t.Fatalf("If-None-Match mismatch: expected=%q got=%q", expected, ifNoneMatch)
+	}
+}
+
+func TestParseMaxAge(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		expected     time.Duration
+	}{
+		{"", 0},
+		{"max-age=15", 15 * time.Second},
+		{"public, max-age=7", 7 * time.Second},
+		{"max-age=bad", 0},
+		{"no-cache", 0},
+	}
+	for _, tt := range tests {
+		if got := parseMaxAge(tt.cacheControl); got != tt.expected {
+			t.Errorf("parseMaxAge(%q): expected=%v got=%v", tt.cacheControl, tt.expected, got)
+		}
+	}
+}
+
+func TestNextIntervalNeverBelowInterval(t *testing.T) {
+	d := &Discovery{
+		options:     Options{Interval: 20 * time.Second},
+		agentMaxAge: 5 * time.Second,
+	}
+	if got := d.nextInterval(); got != 20*time.Second {
+		t.Fatalf("expected interval 20s, got %v", got)
+	}
+}
+
+func TestQueryAgentFailover(t *testing.T) {
+	var downCalls int
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		downCalls++
+		http.Error(w, "down", 500)
+	}))
+	defer down.Close()
+
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`[{"arn":"a","address":"10.0.0.1"}]`))
+	}))
+	defer up.Close()
+
+	d := &Discovery{
+		options: Options{
+			ServiceName: "svc",
+			AgentURL:    down.URL + "/tasks, " + up.URL + "/tasks",
+		},
+		clusterName: "demo",
+		httpClient:  http.DefaultClient,
+	}
+
+	for i := range 3 {
+		tasks, err := d.queryAgent(context.Background())
+		if err != nil {
+			t.Fatalf("query %d: unexpected error: %v", i, err)
+		}
+		if len(tasks) != 1 {
+			t.Fatalf("query %d: expected 1 task, got %d", i, len(tasks))
+		}
+	}
+
+	// after first failover, the healthy endpoint is queried first
+	if downCalls != 1 {
+		t.Fatalf("expected failed endpoint to be queried once, got %d", downCalls)
+	}
+
+	status := d.AgentStatus()
+	if len(status) != 2 {
+		t.Fatalf("expected 2 endpoint statuses, got %d", len(status))
+	}
+	if status[0].ConsecutiveFailures != 1 || status[0].LastError == "" {
+		t.Fatalf("unexpected status for failed endpoint: %+v", status[0])
+	}
+	if status[1].ConsecutiveFailures != 0 || status[1].LastSuccess.IsZero() {
+		t.Fatalf("unexpected status for healthy endpoint: %+v", status[1])
+	}
+}
+
+func TestQueryAgentCircuitBreaker(t *testing.T) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		http.Error(w, "down", 500)
+	}))
+	defer ts.Close()
+
+	registry := prometheus.NewRegistry()
+
+	d := &Discovery{
+		options: Options{
+			ServiceName:          "svc",
+			AgentURL:             ts.URL + "/tasks",
+			AgentCircuitFailures: 2,
+			AgentCircuitCooldown: time.Hour,
+		},
+		clusterName: "demo",
+		httpClient:  http.DefaultClient,
+		metrics:     newMetrics(nil, "test", registry),
+	}
+
+	for range 2 {
+		if _, err := d.queryAgent(context.Background()); err == nil {
+			t.Fatal("expected agent error, got nil")
+		}
+	}
+
+	status := d.AgentStatus()
+	if len(status) != 1 || !status[0].CircuitOpen {
+		t.Fatalf("expected open circuit, got %+v", status)
+	}
+
+	_, err := d.queryAgent(context.Background())
+	if !errors.Is(err, errAgentCircuitOpen) {
+		t.Fatalf("expected circuit open error, got %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected endpoint to be skipped while circuit is open, got %d calls", calls)
+	}
+
+	endpoint := ts.URL + "/tasks"
+
+	if got := testutil.ToFloat64(prometheusSink(t, d.metrics).agentCircuitOpen.WithLabelValues("svc", endpoint)); got != 1 {
+		t.Fatalf("expected agent_circuit_open=1, got %v", got)
+	}
+	if got := testutil.ToFloat64(prometheusSink(t, d.metrics).agentRequests.WithLabelValues("svc", endpoint, "failure")); got != 2 {
+		t.Fatalf("expected 2 failures, got %v", got)
+	}
+	if got := testutil.ToFloat64(prometheusSink(t, d.metrics).agentRequests.WithLabelValues("svc", "", "skipped")); got != 1 {
+		t.Fatalf("expected 1 skipped, got %v", got)
+	}
+}
+
+func TestAgentCircuitHalfOpen(t *testing.T) {
+	d := &Discovery{
+		options: Options{
+			ServiceName:          "svc",
+			AgentCircuitFailures: 1,
+			AgentCircuitCooldown: time.Minute,
+		},
+	}
+
+	const endpoint = "http://agent/tasks"
+	now := time.Now()
+
+	d.recordAgentResult(endpoint, errors.New("fail"), now)
+
+	if got := d.agentCandidates([]string{endpoint}, now.Add(30*time.Second)); len(got) != 0 {
+		t.Fatalf("expected no candidates during cooldown, got %v", got)
+	}
+
+	later := now.Add(2 * time.Minute)
+	if got := d.agentCandidates([]string{endpoint}, later); len(got) != 1 {
+		t.Fatalf("expected half-open endpoint after cooldown, got %v", got)
+	}
+
+	d.recordAgentResult(endpoint, nil, later)
+
+	if status := d.AgentStatus(); status[0].CircuitOpen || status[0].ConsecutiveFailures != 0 {
+		t.Fatalf("expected closed circuit after success, got %+v", status[0])
+	}
+}
+
+func TestAgentCircuitGaugeClosesOnCooldown(t *testing.T) {
+	d := &Discovery{
+		options: Options{
+			ServiceName:          "svc",
+			AgentCircuitFailures: 1,
+			AgentCircuitCooldown: 20 * time.Millisecond,
+		},
+		metrics: newMetrics(nil, "", prometheus.NewRegistry()),
+	}
+
+	const endpoint = "http://agent/tasks"
+	gauge := prometheusSink(t, d.metrics).agentCircuitOpen.WithLabelValues("svc", endpoint)
+
+	d.recordAgentResult(endpoint, errors.New("fail"), time.Now())
+
+	if got := testutil.ToFloat64(gauge); got != 1 {
+		t.Fatalf("expected agent_circuit_open=1, got %v", got)
+	}
+
+	// no request after cooldown: gauge must follow the breaker anyway
+	deadline := time.Now().Add(2 * time.Second)
+	for testutil.ToFloat64(gauge) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected agent_circuit_open=0 after cooldown")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if status := d.AgentStatus(); status[0].CircuitOpen {
+		t.Fatalf("expected status closed after cooldown, got %+v", status[0])
+	}
+}
+
+func TestAgentURLsFromEnv(t *testing.T) {
+	t.Setenv(envAgentURL, "http://a/tasks, http://b/tasks,,")
+
+	d := &Discovery{clusterName: "demo"}
+
+	got := d.agentURLs()
+	expected := []string{"http://a/tasks", "http://b/tasks"}
+	if len(got) != len(expected) || got[0] != expected[0] || got[1] != expected[1] {
+		t.Fatalf("agentURLs() mismatch: expected=%v got=%v", expected, got)
+	}
+}
+
+func TestQueryAgentPartialResult(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set(HeaderTaskFailures, `[{"arn":"b","reason":"MISSING"}]`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(`[{"arn":"a","address":"10.0.0.1"}]`))
+	}))
+	defer ts.Close()
+
+	registry := prometheus.NewRegistry()
+
+	d := &Discovery{
+		options: Options{
+			ServiceName: "svc",
+			AgentURL:    ts.URL + "/tasks",
+		},
+		clusterName: "demo",
+		httpClient:  http.DefaultClient,
+		metrics:     newMetrics(nil, "", registry),
+	}
+
+	// second poll is answered from the conditional request
+	for i := range 2 {
+		tasks, err := d.listTasks(context.Background())
+		var partial *PartialResultError
+		if !errors.As(err, &partial) || len(partial.Failures) != 1 || partial.Failures[0].ARN != "b" {
+			t.Fatalf("poll %d: expected partial result from agent, got %v", i, err)
+		}
+		if len(tasks) != 1 || tasks[0].ARN != "a" {
+			t.Fatalf("poll %d: unexpected tasks: %+v", i, tasks)
+		}
+	}
+
+	if failures := testutil.ToFloat64(prometheusSink(t, d.metrics).describeFailure.WithLabelValues("svc", "MISSING")); failures != 2 {
+		t.Errorf("expected describe failures counted, got %v", failures)
+	}
+	if status := d.AgentStatus(); len(status) != 1 || status[0].ConsecutiveFailures != 0 || status[0].LastSuccess.IsZero() {
+		t.Errorf("expected agent healthy despite partial result: %+v", status)
+	}
+}
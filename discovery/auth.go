@@ -0,0 +1,144 @@
+package discovery
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// HeaderTimestamp carries the unix time (seconds) of an HMAC-signed request.
+	HeaderTimestamp = "X-Ecs-Task-Discovery-Timestamp"
+
+	// HeaderNonce carries a random value unique to an HMAC-signed request.
+	HeaderNonce = "X-Ecs-Task-Discovery-Nonce"
+
+	// HeaderSignature carries the hex-encoded HMAC-SHA256 signature of a request.
+	HeaderSignature = "X-Ecs-Task-Discovery-Signature"
+
+	envAgentToken          = "ECS_TASK_DISCOVERY_AGENT_TOKEN"
+	envAgentTokenFile      = "ECS_TASK_DISCOVERY_AGENT_TOKEN_FILE"
+	envAgentHMACSecret     = "ECS_TASK_DISCOVERY_AGENT_HMAC_SECRET"
+	envAgentHMACSecretFile = "ECS_TASK_DISCOVERY_AGENT_HMAC_SECRET_FILE"
+)
+
+// Errors returned by VerifyBearer and VerifyHMAC.
+var (
+	ErrAuthMissing   = errors.New("missing credentials")
+	ErrAuthInvalid   = errors.New("invalid credentials")
+	ErrAuthTimestamp = errors.New("timestamp outside allowed skew")
+)
+
+// signature computes the HMAC-SHA256 of method, request URI, timestamp and nonce.
+func signature(secret []byte, method, requestURI, timestamp, nonce string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + requestURI + "\n" + timestamp + "\n" + nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignHMAC signs req with secret, setting headers HeaderTimestamp, HeaderNonce and HeaderSignature.
+// The signature covers method, request URI (path and query), timestamp and a random nonce.
+func SignHMAC(req *http.Request, secret []byte, now time.Time) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	nonce := rand.Text()
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderSignature, signature(secret, req.Method, req.URL.RequestURI(), timestamp, nonce))
+}
+
+// VerifyHMAC checks the HMAC signature of req against secret.
+// Requests whose timestamp differs from now by more than maxSkew are rejected.
+// VerifyHMAC does not detect replays: the caller should reject a nonce
+// (header HeaderNonce) seen before within the skew window.
+func VerifyHMAC(req *http.Request, secret []byte, now time.Time, maxSkew time.Duration) error {
+	timestamp := req.Header.Get(HeaderTimestamp)
+	nonce := req.Header.Get(HeaderNonce)
+	sig := req.Header.Get(HeaderSignature)
+	if timestamp == "" || nonce == "" || sig == "" {
+		return ErrAuthMissing
+	}
+	sec, errConv := strconv.ParseInt(timestamp, 10, 64)
+	if errConv != nil {
+		return ErrAuthInvalid
+	}
+	if skew := now.Sub(time.Unix(sec, 0)).Abs(); skew > maxSkew {
+		return ErrAuthTimestamp
+	}
+	expected := signature(secret, req.Method, req.URL.RequestURI(), timestamp, nonce)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return ErrAuthInvalid
+	}
+	return nil
+}
+
+// VerifyBearer checks the header "Authorization: Bearer <token>" of req against token.
+func VerifyBearer(req *http.Request, token string) error {
+	got, found := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !found || got == "" {
+		return ErrAuthMissing
+	}
+	if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		return ErrAuthInvalid
+	}
+	return nil
+}
+
+// LoadSecret returns value, or the trimmed contents of file if value is empty.
+// It returns empty string if both are empty.
+func LoadSecret(value, file string) (string, error) {
+	if value != "" || file == "" {
+		return value, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("load secret: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// firstNonEmpty returns the first non-empty string.
+func firstNonEmpty(list ...string) string {
+	for _, s := range list {
+		if s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// authorizeAgentRequest adds credentials to an agent request.
+// Files are read on every request, so rotated secrets are picked up.
+func (d *Discovery) authorizeAgentRequest(req *http.Request) error {
+	token, errToken := LoadSecret(
+		firstNonEmpty(d.options.AgentToken, os.Getenv(envAgentToken)),
+		firstNonEmpty(d.options.AgentTokenFile, os.Getenv(envAgentTokenFile)),
+	)
+	if errToken != nil {
+		return errToken
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	secret, errSecret := LoadSecret(
+		firstNonEmpty(d.options.AgentHMACSecret, os.Getenv(envAgentHMACSecret)),
+		firstNonEmpty(d.options.AgentHMACSecretFile, os.Getenv(envAgentHMACSecretFile)),
+	)
+	if errSecret != nil {
+		return errSecret
+	}
+	if secret != "" {
+		SignHMAC(req, []byte(secret), time.Now())
+	}
+
+	return nil
+}
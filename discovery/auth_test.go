@@ -0,0 +1,105 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerifyHMAC(t *testing.T) {
+	secret := []byte("s3cret")
+	now := time.Unix(1_700_000_000, 0)
+
+	req := httptest.NewRequest("GET", "/tasks/svc?x=1", nil)
+	SignHMAC(req, secret, now)
+
+	if err := VerifyHMAC(req, secret, now.Add(30*time.Second), time.Minute); err != nil {
+		t.Fatalf("VerifyHMAC() unexpected error: %v", err)
+	}
+
+	if err := VerifyHMAC(req, secret, now.Add(2*time.Minute), time.Minute); !errors.Is(err, ErrAuthTimestamp) {
+		t.Fatalf("expected ErrAuthTimestamp, got %v", err)
+	}
+
+	if err := VerifyHMAC(req, []byte("other"), now, time.Minute); !errors.Is(err, ErrAuthInvalid) {
+		t.Fatalf("expected ErrAuthInvalid, got %v", err)
+	}
+
+	if err := VerifyHMAC(httptest.NewRequest("GET", "/tasks/svc", nil), secret, now, time.Minute); !errors.Is(err, ErrAuthMissing) {
+		t.Fatalf("expected ErrAuthMissing, got %v", err)
+	}
+
+	nonce := req.Header.Get(HeaderNonce)
+	req.Header.Set(HeaderNonce, "other")
+	if err := VerifyHMAC(req, secret, now, time.Minute); !errors.Is(err, ErrAuthInvalid) {
+		t.Fatalf("expected ErrAuthInvalid for altered nonce, got %v", err)
+	}
+
+	again := httptest.NewRequest("GET", "/tasks/svc?x=1", nil)
+	SignHMAC(again, secret, now)
+	if again.Header.Get(HeaderNonce) == nonce {
+		t.Fatalf("expected distinct nonce per request: %s", nonce)
+	}
+}
+
+func TestQueryAgentSendsCredentials(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "hmac")
+	if err := os.WriteFile(secretFile, []byte("hmac-secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var errBearer, errHMAC error
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		errBearer = VerifyBearer(r, "tok")
+		errHMAC = VerifyHMAC(r, []byte("hmac-secret"), time.Now(), time.Minute)
+		w.Write([]byte(`[]`))
+	}))
+	defer ts.Close()
+
+	t.Setenv(envAgentToken, "tok")
+
+	d := &Discovery{
+		options: Options{
+			ServiceName:         "svc",
+			AgentURL:            ts.URL + "/tasks",
+			AgentHMACSecretFile: secretFile,
+		},
+		clusterName: "demo",
+		httpClient:  http.DefaultClient,
+	}
+
+	if _, err := d.queryAgent(context.Background()); err != nil {
+		t.Fatalf("queryAgent() unexpected error: %v", err)
+	}
+
+	if errBearer != nil {
+		t.Fatalf("bearer token verification failed: %v", errBearer)
+	}
+	if errHMAC != nil {
+		t.Fatalf("HMAC verification failed: %v", errHMAC)
+	}
+}
+
+func TestLoadSecret(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(file, []byte("  from-file \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := LoadSecret("value", file); got != "value" {
+		t.Fatalf("expected value to take precedence, got %q", got)
+	}
+	if got, _ := LoadSecret("", file); got != "from-file" {
+		t.Fatalf("expected trimmed file contents, got %q", got)
+	}
+	if _, err := LoadSecret("", filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("expected error for missing file, got nil")
+	}
+}
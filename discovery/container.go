@@ -0,0 +1,109 @@
+package discovery
+
+import (
+	"encoding/json"
+	"iter"
+	"slices"
+	"strings"
+)
+
+// Container represents a container of a task.
+type Container struct {
+	Name         string `json:"name"`
+	HealthStatus string `json:"health_status"`
+}
+
+// ContainerHealth holds the health status of the containers of a task.
+// Unlike a slice, it is comparable, so that Task remains comparable with ==
+// and usable as a map key. It is serialized as a JSON list of Container.
+// The zero value holds no containers.
+type ContainerHealth struct {
+	// name and status per container, sorted by name, for instance
+	// "app\x1fHEALTHY\x1esidecar\x1fUNKNOWN". Empty for no containers.
+	encoded string
+}
+
+// Separators of ContainerHealth records and fields. ECS container names
+// and health statuses never contain these control characters.
+const (
+	containerRecordSep = "\x1e"
+	containerFieldSep  = "\x1f"
+)
+
+// NewContainerHealth creates a ContainerHealth from a list of containers.
+func NewContainerHealth(containers ...Container) ContainerHealth {
+	if len(containers) == 0 {
+		return ContainerHealth{}
+	}
+	list := slices.Clone(containers)
+	slices.SortStableFunc(list, func(a, b Container) int { return strings.Compare(a.Name, b.Name) })
+	var sb strings.Builder
+	for i, c := range list {
+		if i > 0 {
+			sb.WriteString(containerRecordSep)
+		}
+		sb.WriteString(c.Name)
+		sb.WriteString(containerFieldSep)
+		sb.WriteString(c.HealthStatus)
+	}
+	return ContainerHealth{encoded: sb.String()}
+}
+
+// List returns the containers, sorted by name.
+func (h ContainerHealth) List() []Container {
+	var list []Container
+	for c := range h.all() {
+		list = append(list, c)
+	}
+	return list
+}
+
+// all iterates over the containers, sorted by name.
+func (h ContainerHealth) all() iter.Seq[Container] {
+	return func(yield func(Container) bool) {
+		if h.encoded == "" {
+			return
+		}
+		for record := range strings.SplitSeq(h.encoded, containerRecordSep) {
+			name, status, _ := strings.Cut(record, containerFieldSep)
+			if !yield(Container{Name: name, HealthStatus: status}) {
+				return
+			}
+		}
+	}
+}
+
+// Status returns the health status of the named container.
+// It returns false if the task has no such container.
+func (h ContainerHealth) Status(name string) (string, bool) {
+	for c := range h.all() {
+		if c.Name == name {
+			return c.HealthStatus, true
+		}
+	}
+	return "", false
+}
+
+// IsZero reports whether h holds no containers.
+func (h ContainerHealth) IsZero() bool {
+	return h.encoded == ""
+}
+
+// MarshalJSON implements json.Marshaler.
+func (h ContainerHealth) MarshalJSON() ([]byte, error) {
+	list := h.List()
+	if list == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(list)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (h *ContainerHealth) UnmarshalJSON(data []byte) error {
+	var list []Container
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*h = NewContainerHealth(list...)
+	return nil
+}
@@ -0,0 +1,70 @@
+package discovery
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestContainerHealthJSON(t *testing.T) {
+	task := Task{ARN: "a", Address: "10.0.0.1", Containers: NewContainerHealth(
+		Container{Name: "app", HealthStatus: "HEALTHY"},
+		Container{Name: "sidecar", HealthStatus: "UNHEALTHY"},
+	)}
+
+	data, err := json.Marshal(task)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	const expected = `{"arn":"a","address":"10.0.0.1","health_status":"","last_status":"",` +
+		`"containers":[{"name":"app","health_status":"HEALTHY"},{"name":"sidecar","health_status":"UNHEALTHY"}]}`
+	if string(data) != expected {
+		t.Fatalf("expected %s, got %s", expected, data)
+	}
+
+	var decoded Task
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if decoded != task {
+		t.Fatalf("expected %+v, got %+v", task, decoded)
+	}
+
+	// no containers: field omitted
+	data, _ = json.Marshal(Task{ARN: "b"})
+	if string(data) != `{"arn":"b","address":"","health_status":"","last_status":""}` {
+		t.Fatalf("expected containers omitted, got %s", data)
+	}
+}
+
+func TestContainerHealthComparable(t *testing.T) {
+	a := Task{ARN: "a", Containers: NewContainerHealth(Container{Name: "app", HealthStatus: "HEALTHY"})}
+	b := Task{ARN: "a", Containers: NewContainerHealth(Container{Name: "app", HealthStatus: "HEALTHY"})}
+	c := Task{ARN: "a", Containers: NewContainerHealth(Container{Name: "app", HealthStatus: "UNHEALTHY"})}
+
+	seen := map[Task]bool{a: true}
+	if !seen[b] || seen[c] || a != b || a == c {
+		t.Fatal("expected tasks compared by container health")
+	}
+
+	if status, found := c.Containers.Status("app"); !found || status != "UNHEALTHY" {
+		t.Errorf("unexpected app status: %q %t", status, found)
+	}
+	if _, found := c.Containers.Status("missing"); found {
+		t.Error("expected missing container not found")
+	}
+	if len(c.Containers.List()) != 1 || !(ContainerHealth{}).IsZero() {
+		t.Error("unexpected container list")
+	}
+}
+
+func TestContainerHealthOrder(t *testing.T) {
+	a := NewContainerHealth(Container{Name: "sidecar", HealthStatus: "UNKNOWN"}, Container{Name: "app", HealthStatus: "HEALTHY"})
+	b := NewContainerHealth(Container{Name: "app", HealthStatus: "HEALTHY"}, Container{Name: "sidecar", HealthStatus: "UNKNOWN"})
+	if a != b {
+		t.Fatal("expected container health independent of container order")
+	}
+	list := a.List()
+	if len(list) != 2 || list[0].Name != "app" || list[1].HealthStatus != "UNKNOWN" {
+		t.Fatalf("unexpected container list: %+v", list)
+	}
+}
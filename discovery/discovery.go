@@ -2,16 +2,16 @@
 package discovery
 
 import (
+	"cmp"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
+	"maps"
 	"net/http"
-	"net/url"
 	"os"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -19,6 +19,9 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
 	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Discovery is used for performing task discovery.
@@ -29,6 +32,36 @@ type Discovery struct {
 	stopOnce           sync.Once
 	healthCheckEnabled bool
 	httpClient         *http.Client
+	watchClient        *http.Client // httpClient without timeouts, for agent watch
+	logger             *slog.Logger
+
+	// per revision health check state, only accessed by run goroutine
+	healthPerRevision bool
+	taskDefClient     ecsClient       // nil when Client is undefined
+	revisionHealth    map[string]bool // task definition ARN => has health check
+
+	// agent state, only accessed by run goroutine
+	agentCurrentURL string        // agent endpoint queried last
+	agentTasks      []Task        // last task list received from agent
+	agentPartial    error         // *PartialResultError for agentTasks, if partial
+	agentWatching   bool          // last agent query was a successful watch
+	watchGeneration uint64        // last generation received from agent watch
+	agentETag       string        // entity tag of agentTasks
+	agentMaxAge     time.Duration // remaining agent cache lifetime for agentTasks
+
+	agents  agentEndpoints // circuit breaker state, shared with AgentStatus
+	metrics *metrics
+	tracer  trace.Tracer
+
+	finder *TaskFinder // created on first ECS API query, only accessed by run goroutine
+
+	recorder *recorder // nil unless recording, only accessed by run goroutine
+
+	selfIdentity SelfIdentity // from task metadata, immutable after New
+	selfMissing  bool         // own task missing from last poll, only accessed by run goroutine
+	selfMu       sync.Mutex
+	self         Task // own task found in last poll, shared with Self
+	selfFound    bool
 }
 
 // HealthCheckMode defines the mode for checking if health checks are enabled.
@@ -46,6 +79,15 @@ const (
 
 	// HealthCheckModeFalse forces health check enablement to false.
 	HealthCheckModeFalse HealthCheckMode = "false"
+
+	// HealthCheckModeRevision judges each task by the health check config of
+	// its own task definition revision, so that redeploying a service with a
+	// task definition that adds or removes health checks is picked up.
+	// Revisions are looked up once and cached, since task definitions are immutable.
+	// Tasks without a task definition ARN, or whose revision lookup fails,
+	// fall back to the service-level detection performed at startup,
+	// like HealthCheckModeDetectAndHandleErrorAsFalse.
+	HealthCheckModeRevision HealthCheckMode = "revision"
 )
 
 // Options define settings for creating a Discovery.
@@ -60,6 +102,7 @@ type Options struct {
 	Callback func(tasks []Task)
 
 	// Client is required ECS client.
+	// It may be left undefined when TaskSource is provided.
 	Client *ecs.Client
 
 	// ForceSingleTask forces our local IP address.
@@ -76,17 +119,173 @@ type Options struct {
 	// AgentURL forces agent URL.
 	// If undefined, retrieves value from env var ECS_TASK_DISCOVERY_AGENT_URL.
 	// If ECS_TASK_DISCOVERY_AGENT_URL is undefined, defaults to http://ecs-task-discovery-agent.{Cluster}:8080/tasks.
+	// Multiple agent URLs may be provided as a comma-separated list, in order of preference.
+	// Discovery sticks to the endpoint that last succeeded and fails over to the next ones.
 	AgentURL string
 
+	// AgentCircuitFailures is the number of consecutive failures that opens
+	// the circuit breaker for an agent endpoint, defaults to 3 if undefined.
+	// While the circuit is open, the endpoint is skipped. When all endpoints
+	// are skipped, discovery goes straight to ECS API.
+	AgentCircuitFailures int
+
+	// AgentCircuitCooldown is how long an agent endpoint circuit stays open,
+	// defaults to 1m if undefined. After cooldown, the endpoint is tried again.
+	AgentCircuitCooldown time.Duration
+
+	// AgentToken optionally sends "Authorization: Bearer <token>" to the agent.
+	// If undefined, retrieves value from env var ECS_TASK_DISCOVERY_AGENT_TOKEN.
+	AgentToken string
+
+	// AgentTokenFile optionally loads AgentToken from a file, read on every request.
+	// If undefined, retrieves value from env var ECS_TASK_DISCOVERY_AGENT_TOKEN_FILE.
+	AgentTokenFile string
+
+	// AgentHMACSecret optionally signs agent requests with HMAC-SHA256.
+	// See SignHMAC.
+	// If undefined, retrieves value from env var ECS_TASK_DISCOVERY_AGENT_HMAC_SECRET.
+	AgentHMACSecret string
+
+	// AgentHMACSecretFile optionally loads AgentHMACSecret from a file, read on every request.
+	// If undefined, retrieves value from env var ECS_TASK_DISCOVERY_AGENT_HMAC_SECRET_FILE.
+	AgentHMACSecretFile string
+
+	// AgentCAFile optionally provides a PEM CA bundle for verifying the agent TLS certificate.
+	// If undefined, retrieves value from env var ECS_TASK_DISCOVERY_AGENT_CA_FILE.
+	// If both are undefined, system roots are used for https agent URLs.
+	AgentCAFile string
+
+	// AgentCertFile optionally provides a PEM client certificate for mutual TLS with the agent.
+	// It requires AgentKeyFile. The certificate is reloaded when files change.
+	// If undefined, retrieves value from env var ECS_TASK_DISCOVERY_AGENT_CERT_FILE.
+	AgentCertFile string
+
+	// AgentKeyFile optionally provides the PEM private key for AgentCertFile.
+	// If undefined, retrieves value from env var ECS_TASK_DISCOVERY_AGENT_KEY_FILE.
+	AgentKeyFile string
+
+	// MetricsRegisterer optionally sends metrics to Prometheus.
+	MetricsRegisterer prometheus.Registerer
+
+	// MetricsNamespace provides optional namespace for prometheus metrics.
+	// Defaults to empty.
+	MetricsNamespace string
+
+	// MetricsSink optionally receives discovery metrics.
+	// If undefined and MetricsRegisterer is defined, defaults to a PrometheusSink.
+	MetricsSink MetricsSink
+
+	// TracerProvider optionally provides OpenTelemetry tracing.
+	// Each poll creates a span, with child spans for agent requests and ECS API calls.
+	// W3C trace context is propagated to the agent.
+	// Defaults to the global provider, which is a no-op unless the application installs one.
+	TracerProvider trace.TracerProvider
+
+	// AgentWatch enables long-polling the agent watch endpoint, so that
+	// membership changes are delivered as soon as the agent sees them,
+	// instead of polling every Interval.
+	// The watch URL is derived from agent URL by replacing the last
+	// path element "tasks" with "watch".
+	// If undefined, retrieves value from env var ECS_TASK_DISCOVERY_AGENT_WATCH.
+	AgentWatch bool
+
 	// TaskDefinitionHasHealthCheck determines how to check if task definition has health checks.
-	// Values: "Detect" (default), "DetectAndHandleErrorAsFalse", "True", "False".
+	// Values: "Detect" (default), "DetectAndHandleErrorAsFalse", "True", "False", "Revision".
+	// See HealthCheckModeRevision for per-revision detection.
 	TaskDefinitionHasHealthCheck HealthCheckMode
+
+	// Logger optionally provides structured logger.
+	// Defaults to slog.Default(). Messages are enriched with cluster and
+	// service attributes. Per-poll messages are logged at DEBUG level.
+	Logger *slog.Logger
+
+	// TaskSource optionally replaces both the agent query and the ECS API
+	// as the origin of tasks. For instance, see DNSSource.
+	// If Client is undefined, health check detection is skipped and
+	// resolves to false.
+	TaskSource TaskSource
+
+	// HealthContainer optionally gates membership on the health of one named
+	// container, for instance the container serving groupcache, instead of the
+	// task health status, which aggregates every essential container.
+	// Health check detection then checks that container's definition,
+	// whether or not it is essential.
+	HealthContainer string
+
+	// UnknownHealthGrace includes tasks whose health status is still UNKNOWN,
+	// while their first health check is pending, if they started less than
+	// UnknownHealthGrace ago. It should cover the health check start period.
+	// Zero (default) excludes UNKNOWN tasks when health checks are enabled.
+	UnknownHealthGrace time.Duration
+
+	// SelfAddress is our own task address. If defined, our own task is always
+	// included in delivered task lists, whatever its health status, so a task
+	// never sees a membership that excludes itself.
+	// It may carry a port, as in "10.0.0.1:5000", to tell our own task apart
+	// from tasks sharing our address, like SRV records of tasks on one host.
+	SelfAddress string
+
+	// DescribeConcurrency limits concurrent DescribeTasks calls against ECS API.
+	// Defaults to 1 (sequential) if undefined. See TaskFinderOptions.
+	DescribeConcurrency int
+
+	// IncrementalDescribe only describes tasks not seen in previous polls.
+	// See TaskFinderOptions.
+	IncrementalDescribe bool
+
+	// FullRefreshInterval forces describing all tasks periodically when
+	// IncrementalDescribe is enabled. Defaults to 1m if undefined.
+	FullRefreshInterval time.Duration
+
+	// RejectPartialResults refuses to deliver a task list when DescribeTasks
+	// reports failures for some tasks (see PartialResultError), keeping the
+	// previously delivered list instead.
+	// By default, the partial list is delivered and failures are only logged.
+	RejectPartialResults bool
+
+	// RateLimiter optionally limits ECS API calls and enables throttling-aware
+	// adaptive retry. Share one RateLimiter among all Discovery instances in a
+	// process to enforce a process-wide API budget. See NewRateLimiter.
+	// Throttled calls are counted in metrics regardless of RateLimiter.
+	RateLimiter *RateLimiter
+
+	// MaxShrinkFraction limits the fraction (0..1) of tasks that may be removed
+	// from the delivered task list in a single poll. For instance, 0.5 blocks
+	// a poll that removes more than half of the tasks.
+	// Bigger drops are delivered only after being seen in ShrinkConfirmPolls
+	// consecutive polls. Zero means no limit.
+	MaxShrinkFraction float64
+
+	// MaxShrinkCount limits the number of tasks that may be removed from the
+	// delivered task list in a single poll, like MaxShrinkFraction.
+	// Zero means no limit.
+	MaxShrinkCount int
+
+	// ShrinkConfirmPolls is the number of consecutive polls that must see a
+	// shrink exceeding MaxShrinkFraction or MaxShrinkCount before it is delivered.
+	// Defaults to 3 if undefined.
+	ShrinkConfirmPolls int
+
+	// RecordFile optionally records every poll as a JSON line: raw ECS API and
+	// agent responses, tasks listed from the source, and the resulting snapshot.
+	// Recordings can be fed back with ReplaySource.
+	// If undefined, retrieves value from env var ECS_TASK_DISCOVERY_RECORD_FILE.
+	RecordFile string
+
+	// RecordMaxBytes rotates the record file when it would exceed this size.
+	// Defaults to 10 MiB if undefined.
+	RecordMaxBytes int64
+
+	// RecordMaxFiles is the number of rotated record files kept: RecordFile.1,
+	// RecordFile.2, and so on. Defaults to 3 if undefined.
+	RecordMaxFiles int
 }
 
-const (
-	defaultAgentURL = "http://ecs-task-discovery-agent.%s:8080/tasks"
-	envAgentURL     = "ECS_TASK_DISCOVERY_AGENT_URL"
-)
+// TaskSource is a pluggable origin for lists of tasks.
+type TaskSource interface {
+	// Tasks returns the current tasks for a service.
+	Tasks(ctx context.Context, serviceName string) ([]Task, error)
+}
 
 // Task represents a task.
 type Task struct {
@@ -94,6 +293,50 @@ type Task struct {
 	Address      string `json:"address"`
 	HealthStatus string `json:"health_status"`
 	LastStatus   string `json:"last_status"`
+	Port         int    `json:"port,omitempty"`
+
+	TaskDefinitionARN string          `json:"task_definition_arn,omitempty"`
+	Containers        ContainerHealth `json:"containers,omitzero"`
+	StartedAt         time.Time       `json:"started_at,omitzero"`
+	AvailabilityZone  string          `json:"availability_zone,omitempty"`
+
+	// IsSelf reports whether this is our own task.
+	// It is set by Discovery and not serialized, since it depends on the observer.
+	IsSelf bool `json:"-"`
+}
+
+// endpoint returns the address of a task, and its port, if any.
+// Several tasks may share an address, for instance SRV records of tasks
+// on the same host, differing only by port.
+func endpoint(t Task) string {
+	if t.Port == 0 {
+		return t.Address
+	}
+	return t.Address + ":" + strconv.Itoa(t.Port)
+}
+
+// compareTasks orders tasks by address, then port, then ARN, so that
+// tasks sharing an address are sorted deterministically.
+func compareTasks(a, b Task) int {
+	return cmp.Or(
+		strings.Compare(a.Address, b.Address),
+		cmp.Compare(a.Port, b.Port),
+		strings.Compare(a.ARN, b.ARN),
+	)
+}
+
+// Equal reports whether t and other are the same task with the same attributes.
+func (t Task) Equal(other Task) bool {
+	return t.ARN == other.ARN &&
+		t.Address == other.Address &&
+		t.HealthStatus == other.HealthStatus &&
+		t.LastStatus == other.LastStatus &&
+		t.Port == other.Port &&
+		t.TaskDefinitionARN == other.TaskDefinitionARN &&
+		t.Containers == other.Containers &&
+		t.StartedAt.Equal(other.StartedAt) &&
+		t.AvailabilityZone == other.AvailabilityZone &&
+		t.IsSelf == other.IsSelf
 }
 
 // New creates a Discovery.
@@ -111,15 +354,39 @@ func New(options Options) (*Discovery, error) {
 		return nil, errors.New("option Callback is required")
 	}
 
-	if options.Client == nil {
+	if options.Client == nil && options.TaskSource == nil {
 		return nil, errors.New("option Client is required")
 	}
 
+	httpClient, errClient := newAgentHTTPClient(options)
+	if errClient != nil {
+		return nil, fmt.Errorf("agent http client: %w", errClient)
+	}
+
 	d := &Discovery{
 		options:     options,
-		httpClient:  newHTTPClient(),
-		clusterName: MustClusterName(),
+		httpClient:  httpClient,
+		watchClient: newWatchHTTPClient(httpClient),
 		done:        make(chan struct{}),
+		metrics:     newMetrics(options.MetricsSink, options.MetricsNamespace, options.MetricsRegisterer),
+		tracer:      newTracer(options.TracerProvider),
+	}
+
+	// a task source does not need the cluster: metadata is optional
+	metadata, errMetadata := findTaskMetadata()
+	switch {
+	case errMetadata == nil:
+		d.clusterName = shortClusterName(metadata.Cluster)
+		d.selfIdentity = metadata.identity()
+	case options.TaskSource == nil:
+		fatalf("find cluster error: %v", errMetadata)
+	}
+
+	d.logger = newLogger(options.Logger, d.clusterName, options.ServiceName)
+
+	if errMetadata == nil {
+		d.log().Info("self identity", "task", d.selfIdentity.TaskARN,
+			"addresses", d.selfIdentity.Addresses)
 	}
 
 	var healthCheckEnabled bool
@@ -133,15 +400,28 @@ func New(options Options) (*Discovery, error) {
 	case HealthCheckModeFalse:
 		healthCheckEnabled = false
 		resolution = "forced/false"
-	case HealthCheckModeDetect, HealthCheckModeDetectAndHandleErrorAsFalse, "":
+	case HealthCheckModeDetect, HealthCheckModeDetectAndHandleErrorAsFalse, HealthCheckModeRevision, "":
+		if options.Client == nil {
+			// task source without ECS client: nothing to detect with
+			healthCheckEnabled = false
+			resolution = "skipped/false"
+			break
+		}
 		var errHealth error
-		healthCheckEnabled, errHealth = IsHealthCheckEnabled(context.TODO(), options.Client, d.clusterName, options.ServiceName)
-		if errHealth != nil && mode != HealthCheckModeDetectAndHandleErrorAsFalse {
-			errorf("New: cluster=%s service=%s: detect task definition health check: errored/false: %v", d.clusterName, options.ServiceName, errHealth)
+		optFns := ecsOptions(options.RateLimiter, d.metrics, d.tracer, options.ServiceName)
+		if options.HealthContainer == "" {
+			healthCheckEnabled, errHealth = IsHealthCheckEnabled(context.TODO(), options.Client, d.clusterName,
+				options.ServiceName, optFns...)
+		} else {
+			healthCheckEnabled, errHealth = IsContainerHealthCheckEnabled(context.TODO(), options.Client, d.clusterName,
+				options.ServiceName, options.HealthContainer, optFns...)
+		}
+		if errHealth != nil && mode == HealthCheckModeDetect {
+			d.logger.Error("detect task definition health check: errored/false", "error", errHealth)
 			return nil, fmt.Errorf("detect task definition health check: %w", errHealth)
 		}
 		if errHealth != nil {
-			errorf("New: cluster=%s service=%s: detect task definition health check failed, falling back: errored/false: %v", d.clusterName, options.ServiceName, errHealth)
+			d.logger.Error("detect task definition health check failed, falling back: errored/false", "error", errHealth)
 			healthCheckEnabled = false
 			resolution = "errored/false"
 		} else if healthCheckEnabled {
@@ -153,11 +433,29 @@ func New(options Options) (*Discovery, error) {
 		return nil, fmt.Errorf("invalid TaskDefinitionHasHealthCheck mode: %s", options.TaskDefinitionHasHealthCheck)
 	}
 
-	infof("New: cluster=%s service=%s: task definition health check option=%s resolved to %s",
-		d.clusterName, options.ServiceName, options.TaskDefinitionHasHealthCheck, resolution)
+	d.logger.Info("task definition health check resolved",
+		"option", options.TaskDefinitionHasHealthCheck, "resolution", resolution)
 
 	d.healthCheckEnabled = healthCheckEnabled
 
+	if mode == HealthCheckModeRevision {
+		d.healthPerRevision = true
+		d.revisionHealth = map[string]bool{}
+		if options.Client != nil {
+			d.taskDefClient = options.Client
+		}
+	}
+
+	recordFile := firstNonEmpty(options.RecordFile, os.Getenv(envRecordFile))
+	rec, errRecord := newRecorder(recordFile, options.RecordMaxBytes, options.RecordMaxFiles)
+	if errRecord != nil {
+		return nil, errRecord
+	}
+	d.recorder = rec
+	if rec != nil {
+		d.logger.Info("recording polls", "record_file", recordFile)
+	}
+
 	go d.run()
 
 	return d, nil
@@ -174,13 +472,15 @@ func (d *Discovery) Stop() {
 
 // run runs a Discovery.
 func (d *Discovery) run() {
-	const me = "Discovery.run"
-
 	var savedTasks []Task
 
+	guard := newShrinkGuard(d.options)
+
 	timer := time.NewTimer(0) // run immediately on startup
 	defer timer.Stop()
 
+	defer d.recorder.close()
+
 LOOP:
 	for {
 		select {
@@ -189,49 +489,220 @@ LOOP:
 		case <-timer.C:
 			begin := time.Now()
 
-			tasks := d.listTasks()
+			ctx, span := d.tracing().Start(context.Background(), "discovery.poll",
+				trace.WithAttributes(
+					attribute.String("discovery.cluster", d.clusterName),
+					attribute.String("discovery.service", d.options.ServiceName),
+				))
 
-			var changed bool
+			var record *pollRecord
+			if d.recorder != nil {
+				record = &pollRecord{}
+				ctx = withPollRecord(ctx, record)
+			}
+
+			tasks, errList := d.listTasks(ctx)
+
+			var changed, rejected, guarded bool
+
+			var partial *PartialResultError
+			if errors.As(errList, &partial) {
+				rejected = d.options.RejectPartialResults
+			}
 
-			if len(tasks) > 0 {
+			if len(tasks) > 0 && !rejected {
 				//
 				// found at least 1 task, task discovery succeeded
 				//
-				slices.SortFunc(tasks, func(a, b Task) int { return strings.Compare(a.Address, b.Address) })
-				changed = !slices.Equal(tasks, savedTasks)
+				slices.SortFunc(tasks, compareTasks)
+				changed = !slices.EqualFunc(tasks, savedTasks, Task.Equal)
+				if changed {
+					guarded = true
+					allowed, dropped, polls := guard.allow(savedTasks, tasks)
+					if !allowed {
+						d.log().Error("blocked shrink", "removed", dropped, "count", len(savedTasks),
+							"confirmed_polls", polls, "required_polls", guard.confirmPolls)
+						d.metrics.shrinkBlocked(d.options.ServiceName)
+						changed = false
+					} else if polls > 0 {
+						d.log().Info("shrink confirmed", "removed", dropped, "count", len(savedTasks))
+					}
+				}
 				if changed {
 					// task list has changed
+					d.log().Info("task list changed", "count", len(tasks), "previous", len(savedTasks))
 					savedTasks = tasks
+					d.metrics.snapshotChanged(d.options.ServiceName, len(tasks))
 					d.options.Callback(tasks) // deliver result
 				}
 			}
 
+			if !guarded {
+				guard.reset() // a shrink must be confirmed by consecutive polls
+			}
+
 			elapsed := time.Since(begin)
 
-			infof("%s: cluster=%s service=%s forceSingleTask=[%s] disableAgentQuery=%t tasksFound=%d changed=%t elapsed=%v sleeping:%v",
-				me, d.clusterName, d.options.ServiceName, d.options.ForceSingleTask, d.options.DisableAgentQuery, len(tasks), changed, elapsed, d.options.Interval)
+			d.metrics.pollDone(d.options.ServiceName, elapsed, len(tasks) > 0 && errList == nil)
 
-			timer.Reset(d.options.Interval)
+			span.SetAttributes(
+				attribute.Int("discovery.tasks", len(tasks)),
+				attribute.Bool("discovery.changed", changed),
+			)
+			endSpan(span, errList)
+
+			if record != nil {
+				d.writeRecord(record, begin, tasks, errList, changed, savedTasks)
+			}
+
+			sleep := d.nextInterval()
+
+			d.log().Debug("poll", "count", len(tasks), "changed", changed,
+				"rejected_partial", rejected, "elapsed", elapsed, "sleep", sleep)
+
+			timer.Reset(sleep)
 		}
 	}
 
 }
 
-func (d *Discovery) listTasks() []Task {
-	const me = "Discovery.listTasks"
+// writeRecord completes the poll record with the poll result and writes it.
+// Write errors are logged, recording never interferes with discovery.
+func (d *Discovery) writeRecord(p *pollRecord, begin time.Time, tasks []Task, errList error,
+	changed bool, snapshot []Task) {
+
+	p.mu.Lock()
+	record := p.record
+	p.mu.Unlock()
+
+	record.Time = begin
+	record.Cluster = d.clusterName
+	record.Service = d.options.ServiceName
+	record.Health = d.recordedHealth()
+	record.Tasks = tasks
+	record.Changed = changed
+	record.Snapshot = snapshot
+	if errList != nil {
+		record.Error = errList.Error()
+		var partial *PartialResultError
+		if errors.As(errList, &partial) {
+			record.Failures = partial.Failures
+		}
+	}
+
+	if err := d.recorder.write(record); err != nil {
+		d.log().Error("record poll", "error", err)
+	}
+}
+
+// recordedHealth returns the current health check resolution for recording.
+func (d *Discovery) recordedHealth() *RecordedHealth {
+	h := &RecordedHealth{
+		Enabled:     d.healthCheckEnabled,
+		Container:   d.options.HealthContainer,
+		PerRevision: d.healthPerRevision,
+	}
+	if d.healthPerRevision {
+		h.Revisions = maps.Clone(d.revisionHealth)
+	}
+	return h
+}
+
+// recordedHealthSource is a TaskSource replaying the recorded health check
+// resolution along with tasks, like ReplaySource.
+type recordedHealthSource interface {
+	Health(serviceName string) *RecordedHealth
+}
+
+// applyHealth replaces the health check resolution with a recorded one.
+// A nil resolution, from recordings lacking it, keeps the current one.
+func (d *Discovery) applyHealth(h *RecordedHealth) {
+	if h == nil {
+		return
+	}
+	d.healthCheckEnabled = h.Enabled
+	d.options.HealthContainer = h.Container // options are owned by d
+	d.healthPerRevision = h.PerRevision
+	d.revisionHealth = maps.Clone(h.Revisions)
+	if d.revisionHealth == nil {
+		d.revisionHealth = map[string]bool{}
+	}
+}
+
+// nextInterval returns how long to wait before next poll.
+func (d *Discovery) nextInterval() time.Duration {
+	if d.agentWatching {
+		return 0 // the agent watch itself waits for changes
+	}
+	// do not poll before agent cached task list expires
+	return max(d.options.Interval, d.agentMaxAge)
+}
+
+// stopped reports whether discovery has been stopped.
+func (d *Discovery) stopped() bool {
+	select {
+	case <-d.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// context returns a child of parent that is canceled when discovery is stopped.
+func (d *Discovery) context(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-d.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
 
+// listTasks finds tasks from the task source, the agent or ECS API.
+// Errors are logged. A *PartialResultError is returned along with tasks.
+func (d *Discovery) listTasks(ctx context.Context) ([]Task, error) {
 	var tasks []Task
+	var err error
+
+	d.agentWatching = false
+	d.agentMaxAge = 0
+
+	if d.options.TaskSource != nil {
+		tasks, err = d.options.TaskSource.Tasks(ctx, d.options.ServiceName)
+		if err != nil {
+			d.log().Error("list tasks", "source", "task_source", "count", len(tasks), "error", err)
+		} else {
+			d.log().Debug("list tasks", "source", "task_source", "count", len(tasks))
+		}
+		recordListed(ctx, "task_source", tasks)
+		if source, ok := d.options.TaskSource.(recordedHealthSource); ok {
+			d.applyHealth(source.Health(d.options.ServiceName))
+		}
+		d.countFailures(err)
+		return d.filterByHealth(ctx, d.markSelf(tasks)), err
+	}
 
 	if !d.options.DisableAgentQuery {
-		var err error
-		tasks, err = d.queryAgent()
-		if err == nil {
-			infof("%s: query agent: cluster=%s service=%s tasks=%d",
-				d.clusterName, d.options.ServiceName, me, len(tasks))
-			return d.filterByHealth(tasks)
+		var errAgent error
+		tasks, errAgent = d.queryAgent(ctx)
+		if errAgent == nil || isPartial(errAgent) {
+			if errAgent != nil {
+				d.log().Error("list tasks", "source", "agent", "count", len(tasks), "error", errAgent)
+			} else {
+				d.log().Debug("list tasks", "source", "agent", "count", len(tasks))
+			}
+			recordListed(ctx, "agent", tasks)
+			d.countFailures(errAgent)
+			return d.filterByHealth(ctx, d.markSelf(tasks)), errAgent
+		}
+		d.log().Error("list tasks", "source", "agent", "error", errAgent)
+		if d.stopped() {
+			return nil, errAgent // query aborted by Stop, do not fall back
 		}
-		errorf("%s: query agent error: cluster=%s service=%s tasks=%d: %v",
-			d.clusterName, d.options.ServiceName, me, len(tasks), err)
+		d.metrics.agentFallback(d.options.ServiceName)
 	}
 
 	if d.options.ForceSingleTask != "" {
@@ -243,123 +714,120 @@ func (d *Discovery) listTasks() []Task {
 				LastStatus:   "RUNNING",
 			},
 		}
+		d.log().Debug("list tasks", "source", "force_single_task", "count", len(tasks))
+		recordListed(ctx, "force_single_task", tasks)
 	} else {
-		var err error
-		tasks, err = Tasks(context.TODO(), d.options.Client, d.clusterName, d.options.ServiceName)
+		if d.finder == nil {
+			d.finder = NewTaskFinder(TaskFinderOptions{
+				Client:              d.options.Client,
+				Cluster:             d.clusterName,
+				ServiceName:         d.options.ServiceName,
+				DescribeConcurrency: d.options.DescribeConcurrency,
+				Incremental:         d.options.IncrementalDescribe,
+				FullRefreshInterval: d.options.FullRefreshInterval,
+				RateLimiter:         d.options.RateLimiter,
+				MetricsRegisterer:   d.options.MetricsRegisterer,
+				MetricsNamespace:    d.options.MetricsNamespace,
+				MetricsSink:         d.metrics.target(),
+				TracerProvider:      d.options.TracerProvider,
+				Logger:              d.log(),
+			})
+		}
+		begin := time.Now()
+		tasks, err = d.finder.Tasks(ctx)
 		if err != nil {
-			errorf("%s: Tasks error: cluster=%s service=%s: %v",
-				me, d.clusterName, d.options.ServiceName, err)
+			d.log().Error("list tasks", "source", "ecs", "count", len(tasks),
+				"elapsed", time.Since(begin), "error", err)
+		} else {
+			d.log().Debug("list tasks", "source", "ecs", "count", len(tasks),
+				"elapsed", time.Since(begin))
 		}
+		recordListed(ctx, "ecs", tasks)
 	}
 
-	return d.filterByHealth(tasks)
+	return d.filterByHealth(ctx, d.markSelf(tasks)), err
 }
 
 // filterByHealth filters tasks to only include HEALTHY tasks when health check detection is enabled.
-func (d *Discovery) filterByHealth(tasks []Task) []Task {
-	if !d.healthCheckEnabled {
-		return tasks
-	}
+// Our own task, if SelfAddress is defined, is always included.
+func (d *Discovery) filterByHealth(ctx context.Context, tasks []Task) []Task {
 	var filtered []Task
-	for _, t := range tasks {
-		if t.HealthStatus == string(types.HealthStatusHealthy) {
-			filtered = append(filtered, t)
+	switch {
+	case d.healthPerRevision:
+		filtered = d.filterByRevisionHealth(ctx, tasks)
+	case !d.healthCheckEnabled:
+		filtered = tasks
+	default:
+		for _, t := range tasks {
+			if d.healthy(t) {
+				filtered = append(filtered, t)
+			}
 		}
 	}
-	return filtered
+	d.metrics.tasksFiltered(d.options.ServiceName, len(tasks)-len(filtered))
+	return d.includeSelf(tasks, filtered)
 }
 
-func (d *Discovery) queryAgent() ([]Task, error) {
-	const me = "Discovery.queryAgent"
-
-	defaultURL := fmt.Sprintf(defaultAgentURL, d.clusterName)
-
-	agentURL := d.options.AgentURL
-	if agentURL == "" {
-		agentURL = os.Getenv(envAgentURL)
-		if agentURL == "" {
-			agentURL = defaultURL
-		}
-	}
-
-	infof("%s: agentURL: (1)AgentURL='%s' (2)%s='%s' (3)default=%s using value: '%s'",
-		me, d.options.AgentURL, envAgentURL, os.Getenv(envAgentURL), defaultURL, agentURL)
-
-	u, errJoin := url.JoinPath(agentURL, d.options.ServiceName)
-	if errJoin != nil {
-		return nil, errJoin
+// healthy reports whether a task is HEALTHY, or UNKNOWN but still within
+// UnknownHealthGrace since it started.
+func (d *Discovery) healthy(t Task) bool {
+	switch d.healthStatus(t) {
+	case string(types.HealthStatusHealthy):
+		return true
+	case string(types.HealthStatusUnknown):
+		return d.options.UnknownHealthGrace > 0 && !t.StartedAt.IsZero() &&
+			time.Since(t.StartedAt) < d.options.UnknownHealthGrace
 	}
+	return false
+}
 
-	resp, errGet := d.httpClient.Get(u)
-	if errGet != nil {
-		return nil, errGet
+// includeSelf adds our own task to filtered, if missing.
+// Nothing is added when discovery found no tasks at all.
+func (d *Discovery) includeSelf(tasks, filtered []Task) []Task {
+	if d.options.SelfAddress == "" || len(tasks) == 0 {
+		return filtered
 	}
-
-	defer resp.Body.Close()
-
-	body, errBody := io.ReadAll(resp.Body)
-	if errBody != nil {
-		return nil, fmt.Errorf("%s: status=%d url=%s body_error:%v",
-			me, resp.StatusCode, u, errBody)
+	for _, t := range filtered {
+		if t.IsSelf || d.isSelfAddress(t) {
+			return filtered
+		}
 	}
 
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("%s: bad_status=%d url=%s error:%s",
-			me, resp.StatusCode, u, string(body))
+	host, port := splitSelfAddress(d.options.SelfAddress)
+	selfTask := Task{ARN: "self", Address: host, Port: port,
+		LastStatus: string(types.DesiredStatusRunning), IsSelf: true}
+	for _, t := range tasks {
+		if t.IsSelf || d.isSelfAddress(t) {
+			selfTask = t
+			break
+		}
 	}
 
-	var tasks []Task
-
-	if errJSON := json.Unmarshal(body, &tasks); errJSON != nil {
-		return nil, fmt.Errorf("%s: status=%d url=%s json_error:%v",
-			me, resp.StatusCode, u, errJSON)
-	}
+	d.log().Debug("including self", "task", selfTask.ARN, "address", selfTask.Address,
+		"health_status", selfTask.HealthStatus)
 
-	return tasks, nil
+	return append(slices.Clone(filtered), selfTask)
 }
 
 // Tasks discovers running ECS tasks.
+// It lists and describes tasks sequentially, without incremental caching.
+// See TaskFinder for concurrent and incremental discovery.
+// If DescribeTasks reports failures, Tasks returns the tasks described
+// successfully along with a *PartialResultError.
 func Tasks(ctx context.Context, clientEcs *ecs.Client, cluster, serviceName string) ([]Task, error) {
-
-	desiredStatus := "RUNNING"
-	maxResults := int32(100) // 1..100
-
-	input := ecs.ListTasksInput{
-		Cluster:       aws.String(cluster),
-		ServiceName:   aws.String(serviceName),
-		MaxResults:    aws.Int32(maxResults),
-		DesiredStatus: types.DesiredStatus(desiredStatus),
-	}
-
-	var tasks []Task // collect all tasks
-
-	//
-	// scan over pages of ListTasks responses
-	//
-	for {
-		out, errList := clientEcs.ListTasks(ctx, &input)
-		if errList != nil {
-			return nil, errList
-		}
-		infof("Tasks: ListTasks: cluster=%s service=%s found %d of maxResults=%d tasks",
-			cluster, serviceName, len(out.TaskArns), maxResults)
-
-		list, errDesc := describeTasks(ctx, clientEcs, cluster, out.TaskArns)
-		if errDesc != nil {
-			return nil, errDesc
-		}
-		tasks = append(tasks, list...)
-		if out.NextToken == nil {
-			break // finished last page
-		}
-		input.NextToken = out.NextToken // next page
-	}
-
-	return tasks, nil
+	finder := NewTaskFinder(TaskFinderOptions{
+		Client:      clientEcs,
+		Cluster:     cluster,
+		ServiceName: serviceName,
+	})
+	return finder.Tasks(ctx)
 }
 
 // describeTasks describes a batch of tasks.
-func describeTasks(ctx context.Context, clientEcs *ecs.Client, cluster string, taskArns []string) ([]Task, error) {
+// Failures reported by DescribeTasks are returned as *PartialResultError,
+// together with the tasks that were described.
+func describeTasks(ctx context.Context, logger *slog.Logger, clientEcs *ecs.Client, cluster string, taskArns []string,
+	optFns ...func(*ecs.Options)) ([]Task, error) {
 	if len(taskArns) == 0 {
 		return nil, nil
 	}
@@ -367,7 +835,7 @@ func describeTasks(ctx context.Context, clientEcs *ecs.Client, cluster string, t
 		Tasks:   taskArns,
 		Cluster: aws.String(cluster),
 	}
-	out, err := clientEcs.DescribeTasks(ctx, &input)
+	out, err := clientEcs.DescribeTasks(ctx, &input, optFns...)
 	if err != nil {
 		return nil, err
 	}
@@ -381,7 +849,7 @@ func describeTasks(ctx context.Context, clientEcs *ecs.Client, cluster string, t
 		switch {
 		case len(t.Attachments) == 0:
 			// log only
-			slog.Error("describeTasks: task missing network attachment",
+			logger.Error("describeTasks: task missing network attachment",
 				"ARN", aws.ToString(t.TaskArn),
 				"healthStatus", t.HealthStatus,
 				"lastStatus", aws.ToString(t.LastStatus),
@@ -391,7 +859,7 @@ func describeTasks(ctx context.Context, clientEcs *ecs.Client, cluster string, t
 		addr := findAddress(t.Attachments)
 
 		if addr == "" {
-			slog.Error("describeTasks: task missing privateIPv4Address",
+			logger.Error("describeTasks: task missing privateIPv4Address",
 				"ARN", aws.ToString(t.TaskArn),
 				"healthStatus", t.HealthStatus,
 				"lastStatus", aws.ToString(t.LastStatus),
@@ -407,12 +875,41 @@ func describeTasks(ctx context.Context, clientEcs *ecs.Client, cluster string, t
 			Address:      addr,
 			HealthStatus: string(t.HealthStatus),
 			LastStatus:   aws.ToString(t.LastStatus),
+
+			TaskDefinitionARN: aws.ToString(t.TaskDefinitionArn),
+			Containers:        containers(t.Containers),
+			StartedAt:         aws.ToTime(t.StartedAt),
+			AvailabilityZone:  aws.ToString(t.AvailabilityZone),
 		})
 	}
 
+	if len(out.Failures) > 0 {
+		partial := &PartialResultError{}
+		for _, f := range out.Failures {
+			partial.Failures = append(partial.Failures, TaskFailure{
+				ARN:    aws.ToString(f.Arn),
+				Reason: aws.ToString(f.Reason),
+				Detail: aws.ToString(f.Detail),
+			})
+		}
+		return tasks, partial
+	}
+
 	return tasks, nil
 }
 
+// containers converts ECS containers.
+func containers(list []types.Container) ContainerHealth {
+	var result []Container
+	for _, c := range list {
+		result = append(result, Container{
+			Name:         aws.ToString(c.Name),
+			HealthStatus: string(c.HealthStatus),
+		})
+	}
+	return NewContainerHealth(result...)
+}
+
 func findAddress(attachments []types.Attachment) string {
 	for _, at := range attachments {
 		for _, kv := range at.Details {
@@ -430,45 +927,34 @@ func MustClusterName() string {
 	if err != nil {
 		fatalf("find cluster error: %v", err)
 	}
-	// extract short cluster name from ARN
+	return shortClusterName(clusterArn)
+}
+
+// shortClusterName extracts short cluster name from ARN.
+func shortClusterName(clusterArn string) string {
 	lastSlash := strings.LastIndexByte(clusterArn, '/')
 	return clusterArn[lastSlash+1:]
 }
 
-const envVarMetadataURI = "ECS_CONTAINER_METADATA_URI_V4"
-
 // FindCluster finds ECS cluster ARN by querying container metadata.
 //
 // EC2: https://docs.aws.amazon.com/AmazonECS/latest/developerguide/task-metadata-endpoint-v4-response.html
 // Fargate: https://docs.aws.amazon.com/AmazonECS/latest/developerguide/task-metadata-endpoint-v4-fargate-response.html
-// Env var: ${ECS_CONTAINER_METADATA_URI_V4}/task
+// Env var: ${ECS_CONTAINER_METADATA_URI_V4}/task, falling back to ${ECS_CONTAINER_METADATA_URI}/task
 // Field: Cluster
 func FindCluster() (string, error) {
-	envValue := os.Getenv(envVarMetadataURI)
-	if envValue == "" {
-		return "", fmt.Errorf("env var '%s' is empty", envVarMetadataURI)
-	}
-	httpClient := newHTTPClient()
-	uri := envValue + "/task"
-	resp, errGet := httpClient.Get(uri)
-	if errGet != nil {
-		return "", errGet
-	}
-	defer resp.Body.Close()
-	body, errBody := io.ReadAll(resp.Body)
-	if errBody != nil {
-		return "", fmt.Errorf("status:%d uri:%s body_error:%v", resp.StatusCode, uri, errBody)
-	}
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("bad_status:%d uri:%s body:%s", resp.StatusCode, uri, string(body))
-	}
-	var metadata metadataFormat
-	if err := json.Unmarshal(body, &metadata); err != nil {
-		return "", fmt.Errorf("status:%d uri:%s json_error:%v", resp.StatusCode, uri, err)
+	metadata, err := findTaskMetadata()
+	if err != nil {
+		return "", err
 	}
 	return metadata.Cluster, nil
 }
 
-type metadataFormat struct {
-	Cluster string `json:"Cluster"`
+// findTaskMetadata queries task metadata with a default MetadataClient.
+func findTaskMetadata() (TaskMetadata, error) {
+	client, err := NewMetadataClient(MetadataClientOptions{})
+	if err != nil {
+		return TaskMetadata{}, err
+	}
+	return client.Task(context.Background())
 }
@@ -1,16 +1,25 @@
 package discovery
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/udhos/ecs-task-discovery/discovery/discoverytest"
 )
 
 type captureTransport struct {
@@ -233,7 +242,7 @@ func TestQueryAgentURLPrecedence(t *testing.T) {
 			httpClient:  &http.Client{Transport: transport},
 		}
 
-		if _, err := d.queryAgent(); err != nil {
+		if _, err := d.queryAgent(context.Background()); err != nil {
 			t.Fatalf("queryAgent() unexpected error: %v", err)
 		}
 
@@ -255,7 +264,7 @@ func TestQueryAgentURLPrecedence(t *testing.T) {
 			httpClient:  &http.Client{Transport: transport},
 		}
 
-		if _, err := d.queryAgent(); err != nil {
+		if _, err := d.queryAgent(context.Background()); err != nil {
 			t.Fatalf("queryAgent() unexpected error: %v", err)
 		}
 
@@ -277,7 +286,7 @@ func TestQueryAgentURLPrecedence(t *testing.T) {
 			httpClient:  &http.Client{Transport: transport},
 		}
 
-		if _, err := d.queryAgent(); err != nil {
+		if _, err := d.queryAgent(context.Background()); err != nil {
 			t.Fatalf("queryAgent() unexpected error: %v", err)
 		}
 
@@ -308,7 +317,10 @@ func TestListTasksFallsBackToECSWhenAgentFails(t *testing.T) {
 		},
 	}
 
-	tasks := d.listTasks()
+	tasks, err := d.listTasks(context.Background())
+	if err != nil {
+		t.Fatalf("listTasks() unexpected error: %v", err)
+	}
 
 	if ecsTransport.listTasksCalls == 0 {
 		t.Fatal("expected ECS ListTasks to be called after agent failure")
@@ -338,7 +350,7 @@ func TestFilterByHealth(t *testing.T) {
 	t.Run("health-check enabled returns HEALTHY only", func(t *testing.T) {
 		d := &Discovery{healthCheckEnabled: true}
 
-		got := d.filterByHealth(input)
+		got := d.filterByHealth(context.Background(), input)
 
 		if len(got) != 1 {
 			t.Fatalf("expected 1 HEALTHY task, got %d", len(got))
@@ -352,14 +364,14 @@ func TestFilterByHealth(t *testing.T) {
 	t.Run("health-check disabled returns all tasks unchanged", func(t *testing.T) {
 		d := &Discovery{healthCheckEnabled: false}
 
-		got := d.filterByHealth(input)
+		got := d.filterByHealth(context.Background(), input)
 
 		if len(got) != len(input) {
 			t.Fatalf("expected %d tasks, got %d", len(input), len(got))
 		}
 
 		for i := range input {
-			if got[i] != input[i] {
+			if !got[i].Equal(input[i]) {
 				t.Fatalf("task at index %d changed: expected=%+v got=%+v", i, input[i], got[i])
 			}
 		}
@@ -367,3 +379,242 @@ func TestFilterByHealth(t *testing.T) {
 }
 
 const metadata = `{"Cluster":"arn:aws:ecs:us-east-1:111122223333:cluster/demo"}`
+
+// partialSource returns a full task list first, then partial results.
+type partialSource struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (s *partialSource) Tasks(_ context.Context, _ string) ([]Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.calls == 1 {
+		return []Task{{ARN: "a", Address: "10.0.0.1"}, {ARN: "b", Address: "10.0.0.2"}}, nil
+	}
+	return []Task{{ARN: "a", Address: "10.0.0.1"}},
+		&PartialResultError{Failures: []TaskFailure{{ARN: "b", Reason: "MISSING"}}}
+}
+
+func TestDiscoveryPartialResults(t *testing.T) {
+	t.Setenv(envVarMetadataURI, "") // no ECS metadata available
+	t.Setenv(envVarMetadataURIV3, "")
+
+	for _, reject := range []bool{false, true} {
+		t.Run(fmt.Sprintf("reject=%t", reject), func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+			ch := make(chan []Task, 10)
+
+			d, err := New(Options{
+				ServiceName:          "svc",
+				Interval:             10 * time.Millisecond,
+				TaskSource:           &partialSource{},
+				Callback:             func(tasks []Task) { ch <- tasks },
+				RejectPartialResults: reject,
+				MetricsRegisterer:    registry,
+			})
+			if err != nil {
+				t.Fatalf("New() error: %v", err)
+			}
+
+			time.Sleep(100 * time.Millisecond)
+			d.Stop()
+
+			first := <-ch
+			if len(first) != 2 {
+				t.Fatalf("expected first delivery with 2 tasks, got %+v", first)
+			}
+
+			var partialDelivered bool
+			select {
+			case tasks := <-ch:
+				partialDelivered = len(tasks) == 1
+			default:
+			}
+			if partialDelivered == reject {
+				t.Fatalf("reject=%t but partialDelivered=%t", reject, partialDelivered)
+			}
+
+			failures := testutil.ToFloat64(prometheusSink(t, d.metrics).describeFailure.WithLabelValues("svc", "MISSING"))
+			if failures < 1 {
+				t.Fatalf("expected describe failures metric, got %v", failures)
+			}
+		})
+	}
+}
+
+func TestDiscoveryLogger(t *testing.T) {
+	t.Setenv(envVarMetadataURI, "") // no ECS metadata available
+	t.Setenv(envVarMetadataURIV3, "")
+
+	var buf syncBuffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	ch := make(chan []Task, 1)
+
+	d, err := New(Options{
+		ServiceName: "svc",
+		TaskSource:  &sequenceSource{lists: [][]Task{makeTasks(2)}},
+		Callback:    func(tasks []Task) { ch <- tasks },
+		Logger:      logger,
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	select {
+	case <-ch:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for callback")
+	}
+	d.Stop()
+
+	var found bool
+	for line := range strings.Lines(buf.String()) {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("bad log line %q: %v", line, err)
+		}
+		if record["service"] != "svc" {
+			t.Fatalf("log record missing service attribute: %q", line)
+		}
+		if record["msg"] == "list tasks" {
+			found = true
+			if record["level"] != "DEBUG" || record["source"] != "task_source" || record["count"] != 2.0 {
+				t.Fatalf("unexpected list tasks record: %q", line)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("missing list tasks record in logs: %s", buf.String())
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestDiscoveryFakeECS(t *testing.T) {
+	fake := discoverytest.NewECSServer()
+	defer fake.Close()
+
+	taskDef := discoverytest.TaskDefinitionARN("demo", 1)
+	fake.PutTaskDefinition(discoverytest.ECSTaskDefinition{
+		ARN:        taskDef,
+		Containers: []discoverytest.ECSContainerDefinition{{Name: "app", HealthCheck: true}},
+	})
+	fake.PutService(discoverytest.ECSService{Cluster: "demo", Name: "svc", TaskDefinitionARN: taskDef})
+	for i := range 3 {
+		fake.PutTask(discoverytest.ECSTask{
+			ARN:               discoverytest.TaskARN("demo", fmt.Sprint(i)),
+			Cluster:           "demo",
+			Service:           "svc",
+			TaskDefinitionARN: taskDef,
+			Address:           fmt.Sprintf("10.0.0.%d", i+1),
+			HealthStatus:      "HEALTHY",
+		})
+	}
+
+	// we are task 0
+	self, _ := fake.Metadata(discoverytest.TaskARN("demo", "0"))
+	metadata := discoverytest.NewMetadataServer(self)
+	defer metadata.Close()
+	metadata.Setenv(t)
+
+	ch := make(chan []Task, 10)
+
+	d, err := New(Options{
+		ServiceName:       "svc",
+		Client:            fake.Client(),
+		DisableAgentQuery: true,
+		Interval:          10 * time.Millisecond,
+		Callback:          func(tasks []Task) { ch <- tasks },
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer d.Stop()
+
+	next := func() []Task {
+		t.Helper()
+		select {
+		case tasks := <-ch:
+			return tasks
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for callback")
+			return nil
+		}
+	}
+
+	if !d.healthCheckEnabled {
+		t.Error("expected health check detected from fake task definition")
+	}
+
+	tasks := next()
+	if len(tasks) != 3 || !tasks[0].IsSelf || tasks[1].IsSelf {
+		t.Fatalf("expected 3 tasks with self first, got %+v", tasks)
+	}
+
+	// churn: one task turns unhealthy
+	fake.SetTaskHealth(discoverytest.TaskARN("demo", "2"), "UNHEALTHY")
+	if tasks := next(); len(tasks) != 2 {
+		t.Fatalf("expected unhealthy task excluded, got %+v", tasks)
+	}
+}
+
+func TestDiscoverySharedAddressStable(t *testing.T) {
+	t.Setenv(envVarMetadataURI, "") // no ECS metadata available
+	t.Setenv(envVarMetadataURIV3, "")
+
+	a := Task{ARN: "a", Address: "10.0.0.1", Port: 5001}
+	b := Task{ARN: "b", Address: "10.0.0.1", Port: 5002}
+	c := Task{ARN: "c", Address: "10.0.0.1", Port: 5003}
+
+	ch := make(chan []Task, 10)
+
+	// SRV answers for tasks on one host come shuffled
+	d, err := New(Options{
+		ServiceName: "svc",
+		Interval:    10 * time.Millisecond,
+		TaskSource: &sequenceSource{lists: [][]Task{
+			{c, a, b},
+			{b, c, a},
+			{a, c, b},
+		}},
+		Callback: func(tasks []Task) { ch <- tasks },
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer d.Stop()
+
+	select {
+	case tasks := <-ch:
+		if !slices.EqualFunc(tasks, []Task{a, b, c}, Task.Equal) {
+			t.Fatalf("expected tasks sorted by address and port, got %+v", tasks)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for callback")
+	}
+
+	select {
+	case tasks := <-ch:
+		t.Fatalf("expected no delivery for reordered tasks, got %+v", tasks)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
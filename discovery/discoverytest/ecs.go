@@ -0,0 +1,559 @@
+package discoverytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+)
+
+// Region and Account are used for building fake ARNs.
+const (
+	Region  = "us-east-1"
+	Account = "111122223333"
+)
+
+// DefaultCluster is assumed when requests omit the cluster.
+const DefaultCluster = "default"
+
+// ClusterARN returns the ARN for a cluster name.
+func ClusterARN(cluster string) string {
+	return fmt.Sprintf("arn:aws:ecs:%s:%s:cluster/%s", Region, Account, cluster)
+}
+
+// ServiceARN returns the ARN for a service.
+func ServiceARN(cluster, service string) string {
+	return fmt.Sprintf("arn:aws:ecs:%s:%s:service/%s/%s", Region, Account, cluster, service)
+}
+
+// TaskARN returns the ARN for a task ID.
+func TaskARN(cluster, id string) string {
+	return fmt.Sprintf("arn:aws:ecs:%s:%s:task/%s/%s", Region, Account, cluster, id)
+}
+
+// TaskDefinitionARN returns the ARN for a task definition revision.
+func TaskDefinitionARN(family string, revision int) string {
+	return fmt.Sprintf("arn:aws:ecs:%s:%s:task-definition/%s:%d", Region, Account, family, revision)
+}
+
+// ECSService is a service in the fake ECS model.
+type ECSService struct {
+	Cluster           string // defaults to DefaultCluster
+	Name              string
+	TaskDefinitionARN string
+}
+
+// ECSTaskDefinition is a task definition in the fake ECS model.
+type ECSTaskDefinition struct {
+	ARN        string
+	Containers []ECSContainerDefinition
+}
+
+// ECSContainerDefinition is a container definition.
+type ECSContainerDefinition struct {
+	Name         string
+	NonEssential bool
+	HealthCheck  bool
+}
+
+// ECSTask is a task in the fake ECS model.
+type ECSTask struct {
+	ARN               string // required
+	Cluster           string // defaults to DefaultCluster
+	Service           string
+	TaskDefinitionARN string
+	Address           string // private IPv4 address, tasks without address have no network attachment
+	LastStatus        string // defaults to RUNNING
+	DesiredStatus     string // defaults to RUNNING
+	HealthStatus      string // defaults to UNKNOWN
+	AvailabilityZone  string
+	StartedAt         time.Time
+	Containers        []ECSContainer
+}
+
+// ECSContainer is a container of a task.
+type ECSContainer struct {
+	Name         string
+	HealthStatus string
+}
+
+// ECSServer is a fake ECS API endpoint speaking the ECS JSON protocol, backed by
+// a mutable in-memory model of services, task definitions and tasks.
+// It supports ListTasks (with pagination), DescribeTasks, DescribeServices
+// and DescribeTaskDefinition. It is safe for concurrent use.
+type ECSServer struct {
+	// URL is the base URL of the ECS endpoint.
+	URL string
+
+	server *httptest.Server
+
+	mu              sync.Mutex
+	services        map[string]ECSService // key: cluster/name
+	taskDefinitions map[string]ECSTaskDefinition
+	tasks           []ECSTask // in insertion order
+	pageSize        int
+	calls           map[string]int
+	failures        map[string]failure
+}
+
+type failure struct {
+	count int
+	code  string
+}
+
+// NewECSServer starts a fake ECS API endpoint with an empty model.
+// Call Close when done.
+func NewECSServer() *ECSServer {
+	s := NewECSHandler()
+	s.server = httptest.NewServer(s)
+	s.URL = s.server.URL
+	return s
+}
+
+// NewECSHandler creates a fake ECS API with an empty model, without starting
+// a server, for serving it on a real listener with ServeHTTP.
+// URL is then empty, and Client and Close are not usable.
+func NewECSHandler() *ECSServer {
+	return &ECSServer{
+		services:        map[string]ECSService{},
+		taskDefinitions: map[string]ECSTaskDefinition{},
+		pageSize:        100,
+		calls:           map[string]int{},
+		failures:        map[string]failure{},
+	}
+}
+
+// Close shuts down the server.
+func (s *ECSServer) Close() {
+	s.server.Close()
+}
+
+// Client returns an ECS client pointed to the server.
+func (s *ECSServer) Client() *ecs.Client {
+	return ecs.NewFromConfig(aws.Config{
+		Region:       Region,
+		BaseEndpoint: aws.String(s.URL),
+		Credentials:  aws.AnonymousCredentials{},
+	})
+}
+
+// PutService adds or replaces a service.
+func (s *ECSServer) PutService(service ECSService) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if service.Cluster == "" {
+		service.Cluster = DefaultCluster
+	}
+	s.services[service.Cluster+"/"+service.Name] = service
+}
+
+// PutTaskDefinition adds or replaces a task definition.
+func (s *ECSServer) PutTaskDefinition(taskDef ECSTaskDefinition) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.taskDefinitions[taskDef.ARN] = taskDef
+}
+
+// PutTask adds a task, or replaces the task with the same ARN.
+func (s *ECSServer) PutTask(task ECSTask) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if task.Cluster == "" {
+		task.Cluster = DefaultCluster
+	}
+	if task.LastStatus == "" {
+		task.LastStatus = "RUNNING"
+	}
+	if task.DesiredStatus == "" {
+		task.DesiredStatus = "RUNNING"
+	}
+	if task.HealthStatus == "" {
+		task.HealthStatus = "UNKNOWN"
+	}
+	i := slices.IndexFunc(s.tasks, func(t ECSTask) bool { return t.ARN == task.ARN })
+	if i < 0 {
+		s.tasks = append(s.tasks, task)
+		return
+	}
+	s.tasks[i] = task
+}
+
+// RemoveTask removes a task. Later DescribeTasks calls report it as MISSING.
+func (s *ECSServer) RemoveTask(arn string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks = slices.DeleteFunc(s.tasks, func(t ECSTask) bool { return t.ARN == arn })
+}
+
+// SetTaskHealth changes the health status of a task.
+func (s *ECSServer) SetTaskHealth(arn, healthStatus string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.tasks {
+		if s.tasks[i].ARN == arn {
+			s.tasks[i].HealthStatus = healthStatus
+		}
+	}
+}
+
+// SetContainerHealth changes the health status of a task container.
+func (s *ECSServer) SetContainerHealth(arn, containerName, healthStatus string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.tasks {
+		if s.tasks[i].ARN != arn {
+			continue
+		}
+		for j := range s.tasks[i].Containers {
+			if s.tasks[i].Containers[j].Name == containerName {
+				s.tasks[i].Containers[j].HealthStatus = healthStatus
+			}
+		}
+	}
+}
+
+// Task returns a task by ARN.
+func (s *ECSServer) Task(arn string) (ECSTask, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.tasks, func(t ECSTask) bool { return t.ARN == arn })
+	if i < 0 {
+		return ECSTask{}, false
+	}
+	return s.tasks[i], true
+}
+
+// Tasks returns all tasks.
+func (s *ECSServer) Tasks() []ECSTask {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.tasks)
+}
+
+// SetPageSize limits the number of ARNs per ListTasks page, below the
+// requested maxResults. Defaults to 100.
+func (s *ECSServer) SetPageSize(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pageSize = n
+}
+
+// FailNext makes the next n calls to operation, for instance "ListTasks",
+// fail with the ECS error code, for instance "ThrottlingException".
+// Code "ServerException" fails with status 500, other codes with status 400.
+func (s *ECSServer) FailNext(operation string, n int, code string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[operation] = failure{count: n, code: code}
+}
+
+// Calls returns the number of calls received for operation, including failed ones.
+func (s *ECSServer) Calls(operation string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[operation]
+}
+
+// Metadata returns the metadata task for a task in the model, for serving
+// with NewMetadataServer, so the task can discover its cluster and identity.
+func (s *ECSServer) Metadata(arn string) (Task, bool) {
+	task, found := s.Task(arn)
+	if !found {
+		return Task{}, false
+	}
+	family, revision, _ := strings.Cut(task.TaskDefinitionARN[strings.LastIndexByte(task.TaskDefinitionARN, '/')+1:], ":")
+	m := Task{
+		Cluster:          ClusterARN(task.Cluster),
+		TaskARN:          task.ARN,
+		Family:           family,
+		Revision:         revision,
+		AvailabilityZone: task.AvailabilityZone,
+	}
+	names := []string{"app"}
+	if len(task.Containers) > 0 {
+		names = names[:0]
+		for _, c := range task.Containers {
+			names = append(names, c.Name)
+		}
+	}
+	for _, name := range names {
+		c := Container{Name: name, DockerID: task.ARN[strings.LastIndexByte(task.ARN, '/')+1:] + "-" + name}
+		if task.Address != "" {
+			c.IPv4Addresses = []string{task.Address}
+		}
+		m.Containers = append(m.Containers, c)
+	}
+	return m, true
+}
+
+// ecsError is an ECS API error response.
+type ecsError struct {
+	status  int
+	code    string
+	message string
+}
+
+// ServeHTTP serves ECS API requests.
+func (s *ECSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.Header.Get("X-Amz-Target")
+	operation := target[strings.LastIndexByte(target, '.')+1:]
+
+	var input ecsInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, ecsError{http.StatusBadRequest, "SerializationException", err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	s.calls[operation]++
+	if f := s.failures[operation]; f.count > 0 {
+		f.count--
+		s.failures[operation] = f
+		s.mu.Unlock()
+		status := http.StatusBadRequest
+		if f.code == "ServerException" {
+			status = http.StatusInternalServerError
+		}
+		writeError(w, ecsError{status, f.code, "fake failure"})
+		return
+	}
+	var body any
+	var errECS *ecsError
+	switch operation {
+	case "ListTasks":
+		body, errECS = s.listTasks(input)
+	case "DescribeTasks":
+		body, errECS = s.describeTasks(input)
+	case "DescribeServices":
+		body, errECS = s.describeServices(input)
+	case "DescribeTaskDefinition":
+		body, errECS = s.describeTaskDefinition(input)
+	default:
+		errECS = &ecsError{http.StatusBadRequest, "UnknownOperationException", "unsupported target: " + target}
+	}
+	s.mu.Unlock()
+
+	if errECS != nil {
+		writeError(w, *errECS)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, e ecsError) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.Header().Set("X-Amzn-Errortype", e.code)
+	w.WriteHeader(e.status)
+	json.NewEncoder(w).Encode(map[string]string{"__type": e.code, "message": e.message})
+}
+
+// ecsInput holds request fields of all supported operations.
+type ecsInput struct {
+	Cluster        string   `json:"cluster"`
+	ServiceName    string   `json:"serviceName"`
+	DesiredStatus  string   `json:"desiredStatus"`
+	MaxResults     int      `json:"maxResults"`
+	NextToken      string   `json:"nextToken"`
+	Tasks          []string `json:"tasks"`
+	Services       []string `json:"services"`
+	TaskDefinition string   `json:"taskDefinition"`
+}
+
+// clusterName accepts a cluster name or ARN.
+func clusterName(cluster string) string {
+	if cluster == "" {
+		return DefaultCluster
+	}
+	return cluster[strings.LastIndexByte(cluster, '/')+1:]
+}
+
+func (s *ECSServer) listTasks(input ecsInput) (any, *ecsError) {
+	cluster := clusterName(input.Cluster)
+	desired := input.DesiredStatus
+	if desired == "" {
+		desired = "RUNNING"
+	}
+
+	var arns []string
+	for _, t := range s.tasks {
+		if t.Cluster != cluster || t.DesiredStatus != desired {
+			continue
+		}
+		if input.ServiceName != "" && t.Service != input.ServiceName {
+			continue
+		}
+		arns = append(arns, t.ARN)
+	}
+
+	pageSize := s.pageSize
+	if input.MaxResults > 0 {
+		pageSize = min(pageSize, input.MaxResults)
+	}
+
+	var start int
+	if input.NextToken != "" {
+		n, err := strconv.Atoi(input.NextToken)
+		if err != nil || n < 0 {
+			return nil, &ecsError{http.StatusBadRequest, "InvalidParameterException", "bad nextToken: " + input.NextToken}
+		}
+		start = min(n, len(arns))
+	}
+	end := min(start+pageSize, len(arns))
+
+	out := map[string]any{"taskArns": nonNil(arns[start:end])}
+	if end < len(arns) {
+		out["nextToken"] = strconv.Itoa(end)
+	}
+	return out, nil
+}
+
+func (s *ECSServer) describeTasks(input ecsInput) (any, *ecsError) {
+	if len(input.Tasks) > 100 {
+		return nil, &ecsError{http.StatusBadRequest, "InvalidParameterException", "too many tasks: " + strconv.Itoa(len(input.Tasks))}
+	}
+	cluster := clusterName(input.Cluster)
+
+	tasks := []any{}
+	failures := []any{}
+	for _, arn := range input.Tasks {
+		i := slices.IndexFunc(s.tasks, func(t ECSTask) bool {
+			return t.Cluster == cluster && (t.ARN == arn || strings.HasSuffix(t.ARN, "/"+arn))
+		})
+		if i < 0 {
+			failures = append(failures, map[string]any{"arn": arn, "reason": "MISSING"})
+			continue
+		}
+		tasks = append(tasks, describeTask(s.tasks[i]))
+	}
+
+	return map[string]any{"tasks": tasks, "failures": failures}, nil
+}
+
+func describeTask(t ECSTask) map[string]any {
+	containers := []any{}
+	for _, c := range t.Containers {
+		health := c.HealthStatus
+		if health == "" {
+			health = "UNKNOWN"
+		}
+		containers = append(containers, map[string]any{
+			"name":         c.Name,
+			"taskArn":      t.ARN,
+			"lastStatus":   t.LastStatus,
+			"healthStatus": health,
+		})
+	}
+	out := map[string]any{
+		"taskArn":           t.ARN,
+		"clusterArn":        ClusterARN(t.Cluster),
+		"taskDefinitionArn": t.TaskDefinitionARN,
+		"lastStatus":        t.LastStatus,
+		"desiredStatus":     t.DesiredStatus,
+		"healthStatus":      t.HealthStatus,
+		"launchType":        "FARGATE",
+		"containers":        containers,
+		"attachments":       []any{},
+	}
+	if t.Service != "" {
+		out["group"] = "service:" + t.Service
+	}
+	if t.AvailabilityZone != "" {
+		out["availabilityZone"] = t.AvailabilityZone
+	}
+	if !t.StartedAt.IsZero() {
+		out["startedAt"] = float64(t.StartedAt.UnixMilli()) / 1000 // epoch seconds
+	}
+	if t.Address != "" {
+		out["attachments"] = []any{map[string]any{
+			"type":   "ElasticNetworkInterface",
+			"status": "ATTACHED",
+			"details": []any{
+				map[string]any{"name": "privateIPv4Address", "value": t.Address},
+			},
+		}}
+	}
+	return out
+}
+
+func (s *ECSServer) describeServices(input ecsInput) (any, *ecsError) {
+	cluster := clusterName(input.Cluster)
+
+	services := []any{}
+	failures := []any{}
+	for _, name := range input.Services {
+		name = name[strings.LastIndexByte(name, '/')+1:]
+		svc, found := s.services[cluster+"/"+name]
+		if !found {
+			failures = append(failures, map[string]any{"arn": ServiceARN(cluster, name), "reason": "MISSING"})
+			continue
+		}
+		var running int
+		for _, t := range s.tasks {
+			if t.Cluster == cluster && t.Service == name && t.LastStatus == "RUNNING" {
+				running++
+			}
+		}
+		services = append(services, map[string]any{
+			"serviceName":    svc.Name,
+			"serviceArn":     ServiceARN(cluster, svc.Name),
+			"clusterArn":     ClusterARN(cluster),
+			"taskDefinition": svc.TaskDefinitionARN,
+			"status":         "ACTIVE",
+			"runningCount":   running,
+			"deployments": []any{map[string]any{
+				"status":         "PRIMARY",
+				"taskDefinition": svc.TaskDefinitionARN,
+				"runningCount":   running,
+			}},
+		})
+	}
+
+	return map[string]any{"services": services, "failures": failures}, nil
+}
+
+func (s *ECSServer) describeTaskDefinition(input ecsInput) (any, *ecsError) {
+	taskDef, found := s.taskDefinitions[input.TaskDefinition]
+	if !found {
+		return nil, &ecsError{http.StatusBadRequest, "ClientException", "Unable to describe task definition."}
+	}
+
+	containers := []any{}
+	for _, c := range taskDef.Containers {
+		def := map[string]any{
+			"name":      c.Name,
+			"essential": !c.NonEssential,
+		}
+		if c.HealthCheck {
+			def["healthCheck"] = map[string]any{"command": []string{"CMD-SHELL", "exit 0"}}
+		}
+		containers = append(containers, def)
+	}
+
+	family, revision, _ := strings.Cut(taskDef.ARN[strings.LastIndexByte(taskDef.ARN, '/')+1:], ":")
+	rev, _ := strconv.Atoi(revision)
+
+	return map[string]any{"taskDefinition": map[string]any{
+		"taskDefinitionArn":    taskDef.ARN,
+		"family":               family,
+		"revision":             rev,
+		"status":               "ACTIVE",
+		"containerDefinitions": containers,
+	}}, nil
+}
+
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
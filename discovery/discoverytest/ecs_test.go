@@ -0,0 +1,171 @@
+package discoverytest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/smithy-go"
+)
+
+func newTestServer(t *testing.T, tasks int) *ECSServer {
+	t.Helper()
+	s := NewECSServer()
+	t.Cleanup(s.Close)
+	taskDef := TaskDefinitionARN("demo", 3)
+	s.PutTaskDefinition(ECSTaskDefinition{
+		ARN: taskDef,
+		Containers: []ECSContainerDefinition{
+			{Name: "app", HealthCheck: true},
+			{Name: "sidecar", NonEssential: true},
+		},
+	})
+	s.PutService(ECSService{Cluster: "demo", Name: "svc", TaskDefinitionARN: taskDef})
+	for i := range tasks {
+		s.PutTask(ECSTask{
+			ARN:               TaskARN("demo", fmt.Sprintf("%03d", i)),
+			Cluster:           "demo",
+			Service:           "svc",
+			TaskDefinitionARN: taskDef,
+			Address:           fmt.Sprintf("10.0.0.%d", i+1),
+			HealthStatus:      "HEALTHY",
+			AvailabilityZone:  "us-east-1a",
+			StartedAt:         time.Unix(1700000000, 0),
+			Containers:        []ECSContainer{{Name: "app", HealthStatus: "HEALTHY"}},
+		})
+	}
+	return s
+}
+
+func TestECSServerListTasksPagination(t *testing.T) {
+	s := newTestServer(t, 5)
+	s.SetPageSize(2)
+	client := s.Client()
+
+	// a task from another service is not listed
+	s.PutTask(ECSTask{ARN: TaskARN("demo", "other"), Cluster: "demo", Service: "other"})
+
+	paginator := ecs.NewListTasksPaginator(client, &ecs.ListTasksInput{
+		Cluster:     aws.String(ClusterARN("demo")),
+		ServiceName: aws.String("svc"),
+	})
+	var arns []string
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(context.Background())
+		if err != nil {
+			t.Fatalf("ListTasks error: %v", err)
+		}
+		arns = append(arns, out.TaskArns...)
+	}
+	if len(arns) != 5 {
+		t.Fatalf("expected 5 tasks, got %v", arns)
+	}
+	if got := s.Calls("ListTasks"); got != 3 {
+		t.Errorf("expected 3 pages, got %d", got)
+	}
+}
+
+func TestECSServerDescribeTasks(t *testing.T) {
+	s := newTestServer(t, 2)
+	client := s.Client()
+
+	s.RemoveTask(TaskARN("demo", "001"))
+
+	out, err := client.DescribeTasks(context.Background(), &ecs.DescribeTasksInput{
+		Cluster: aws.String("demo"),
+		Tasks:   []string{TaskARN("demo", "000"), TaskARN("demo", "001")},
+	})
+	if err != nil {
+		t.Fatalf("DescribeTasks error: %v", err)
+	}
+	if len(out.Tasks) != 1 || len(out.Failures) != 1 {
+		t.Fatalf("expected 1 task and 1 failure, got %d and %d", len(out.Tasks), len(out.Failures))
+	}
+	task := out.Tasks[0]
+	if aws.ToString(task.LastStatus) != "RUNNING" || string(task.HealthStatus) != "HEALTHY" ||
+		aws.ToString(task.AvailabilityZone) != "us-east-1a" || !aws.ToTime(task.StartedAt).Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("unexpected task: %+v", task)
+	}
+	if len(task.Attachments) != 1 || aws.ToString(task.Attachments[0].Details[0].Value) != "10.0.0.1" {
+		t.Errorf("unexpected attachments: %+v", task.Attachments)
+	}
+	if aws.ToString(out.Failures[0].Reason) != "MISSING" {
+		t.Errorf("unexpected failure: %+v", out.Failures[0])
+	}
+}
+
+func TestECSServerDescribeServiceAndTaskDefinition(t *testing.T) {
+	s := newTestServer(t, 1)
+	client := s.Client()
+
+	outSvc, err := client.DescribeServices(context.Background(), &ecs.DescribeServicesInput{
+		Cluster:  aws.String("demo"),
+		Services: []string{"svc"},
+	})
+	if err != nil {
+		t.Fatalf("DescribeServices error: %v", err)
+	}
+	if len(outSvc.Services) != 1 || aws.ToString(outSvc.Services[0].TaskDefinition) != TaskDefinitionARN("demo", 3) {
+		t.Fatalf("unexpected services: %+v", outSvc.Services)
+	}
+
+	outDef, err := client.DescribeTaskDefinition(context.Background(), &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: outSvc.Services[0].TaskDefinition,
+	})
+	if err != nil {
+		t.Fatalf("DescribeTaskDefinition error: %v", err)
+	}
+	defs := outDef.TaskDefinition.ContainerDefinitions
+	if len(defs) != 2 || defs[0].HealthCheck == nil || !aws.ToBool(defs[0].Essential) ||
+		defs[1].HealthCheck != nil || aws.ToBool(defs[1].Essential) {
+		t.Errorf("unexpected container definitions: %+v", defs)
+	}
+
+	_, err = client.DescribeTaskDefinition(context.Background(), &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String("missing"),
+	})
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "ClientException" {
+		t.Errorf("expected ClientException, got %v", err)
+	}
+}
+
+func TestECSServerFailNext(t *testing.T) {
+	s := newTestServer(t, 1)
+	client := s.Client()
+
+	s.FailNext("ListTasks", 1, "AccessDeniedException")
+
+	input := &ecs.ListTasksInput{Cluster: aws.String("demo")}
+
+	_, err := client.ListTasks(context.Background(), input)
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "AccessDeniedException" {
+		t.Fatalf("expected AccessDeniedException, got %v", err)
+	}
+
+	if _, err := client.ListTasks(context.Background(), input); err != nil {
+		t.Fatalf("expected recovery, got %v", err)
+	}
+}
+
+func TestECSServerMetadata(t *testing.T) {
+	s := newTestServer(t, 1)
+
+	m, found := s.Metadata(TaskARN("demo", "000"))
+	if !found {
+		t.Fatal("task not found")
+	}
+	if m.Cluster != ClusterARN("demo") || m.Family != "demo" || m.Revision != "3" ||
+		len(m.Containers) != 1 || m.Containers[0].IPv4Addresses[0] != "10.0.0.1" {
+		t.Errorf("unexpected metadata: %+v", m)
+	}
+
+	if _, found := s.Metadata("missing"); found {
+		t.Error("unexpected metadata for missing task")
+	}
+}
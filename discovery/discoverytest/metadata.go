@@ -0,0 +1,252 @@
+// Package discoverytest provides fake ECS endpoints for testing discovery.
+package discoverytest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Env vars pointing to the container metadata endpoint.
+const (
+	EnvMetadataURI   = "ECS_CONTAINER_METADATA_URI_V4"
+	EnvMetadataURIV3 = "ECS_CONTAINER_METADATA_URI"
+)
+
+// Task describes the fake task served by MetadataServer.
+type Task struct {
+	Cluster          string
+	TaskARN          string
+	Family           string
+	Revision         string
+	AvailabilityZone string
+	LaunchType       string // defaults to "FARGATE"
+	CPU              float64
+	Memory           int64
+
+	// Containers lists task containers. The first one is our own container,
+	// served by the container and container stats endpoints.
+	Containers []Container
+}
+
+// Container describes a container of the fake task.
+type Container struct {
+	Name           string
+	DockerID       string
+	Image          string
+	IPv4Addresses  []string
+	IPv6Addresses  []string
+	IPv4SubnetCIDR string
+	CPU            float64
+	Memory         int64
+}
+
+// MetadataServer is a fake ECS container metadata endpoint.
+// It serves ${ECS_CONTAINER_METADATA_URI_V4} paths: /, /task, /stats and /task/stats.
+type MetadataServer struct {
+	// URL is the base URL of the metadata endpoint.
+	URL string
+
+	server *httptest.Server
+
+	mu         sync.Mutex
+	task       Task
+	failNext   int
+	failStatus int
+	requests   map[string]int
+}
+
+// NewMetadataServer starts a fake metadata endpoint serving task.
+// Call Close when done.
+func NewMetadataServer(task Task) *MetadataServer {
+	s := &MetadataServer{
+		task:     task,
+		requests: map[string]int{},
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL
+	return s
+}
+
+// Close shuts down the server.
+func (s *MetadataServer) Close() {
+	s.server.Close()
+}
+
+// Setenv points ECS_CONTAINER_METADATA_URI_V4 to the server for the duration of the test.
+func (s *MetadataServer) Setenv(t testing.TB) {
+	t.Setenv(EnvMetadataURI, s.URL)
+	t.Setenv(EnvMetadataURIV3, "")
+}
+
+// SetenvV3 points only ECS_CONTAINER_METADATA_URI to the server for the duration of the test,
+// as found on older container agents.
+func (s *MetadataServer) SetenvV3(t testing.TB) {
+	t.Setenv(EnvMetadataURI, "")
+	t.Setenv(EnvMetadataURIV3, s.URL)
+}
+
+// SetTask replaces the served task.
+func (s *MetadataServer) SetTask(task Task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.task = task
+}
+
+// FailNext makes the next n requests fail with the HTTP status.
+func (s *MetadataServer) FailNext(n, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failNext = n
+	s.failStatus = status
+}
+
+// Requests returns the number of requests received for path, including failed ones.
+func (s *MetadataServer) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+func (s *MetadataServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests[r.URL.Path]++
+	task := s.task
+	fail := s.failNext > 0
+	if fail {
+		s.failNext--
+	}
+	status := s.failStatus
+	s.mu.Unlock()
+
+	if fail {
+		http.Error(w, "fake failure", status)
+		return
+	}
+
+	serveMetadata(w, r, task)
+}
+
+// MetadataHandler serves metadata paths /, /task, /stats and /task/stats
+// for the task returned by lookup, or 404 if lookup reports no task.
+// It is useful for serving metadata of many tasks, under distinct
+// path prefixes removed by http.StripPrefix.
+func MetadataHandler(lookup func() (Task, bool)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		task, found := lookup()
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		serveMetadata(w, r, task)
+	})
+}
+
+func serveMetadata(w http.ResponseWriter, r *http.Request, task Task) {
+	var body any
+	switch r.URL.Path {
+	case "/", "":
+		body = containerResponse(task.own())
+	case "/task":
+		body = taskResponse(task)
+	case "/stats":
+		body = statsResponse(task.own())
+	case "/task/stats":
+		stats := map[string]any{}
+		for _, c := range task.Containers {
+			stats[c.DockerID] = statsResponse(c)
+		}
+		body = stats
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// own returns our own container.
+func (t Task) own() Container {
+	if len(t.Containers) == 0 {
+		return Container{}
+	}
+	return t.Containers[0]
+}
+
+// startedAt is a fixed timestamp for deterministic responses.
+var startedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func taskResponse(t Task) map[string]any {
+	launchType := t.LaunchType
+	if launchType == "" {
+		launchType = "FARGATE"
+	}
+	containers := make([]any, 0, len(t.Containers))
+	for _, c := range t.Containers {
+		containers = append(containers, containerResponse(c))
+	}
+	return map[string]any{
+		"Cluster":          t.Cluster,
+		"TaskARN":          t.TaskARN,
+		"Family":           t.Family,
+		"Revision":         t.Revision,
+		"DesiredStatus":    "RUNNING",
+		"KnownStatus":      "RUNNING",
+		"AvailabilityZone": t.AvailabilityZone,
+		"LaunchType":       launchType,
+		"Limits":           map[string]any{"CPU": t.CPU, "Memory": t.Memory},
+		"PullStartedAt":    startedAt,
+		"PullStoppedAt":    startedAt,
+		"Containers":       containers,
+	}
+}
+
+func containerResponse(c Container) map[string]any {
+	return map[string]any{
+		"DockerId":      c.DockerID,
+		"Name":          c.Name,
+		"DockerName":    "ecs-" + c.Name,
+		"Image":         c.Image,
+		"ImageID":       "sha256:" + c.DockerID,
+		"Labels":        map[string]string{"com.amazonaws.ecs.container-name": c.Name},
+		"DesiredStatus": "RUNNING",
+		"KnownStatus":   "RUNNING",
+		"Limits":        map[string]any{"CPU": c.CPU, "Memory": c.Memory},
+		"CreatedAt":     startedAt,
+		"StartedAt":     startedAt,
+		"Type":          "NORMAL",
+		"Networks": []any{
+			map[string]any{
+				"NetworkMode":         "awsvpc",
+				"IPv4Addresses":       c.IPv4Addresses,
+				"IPv6Addresses":       c.IPv6Addresses,
+				"AttachmentIndex":     0,
+				"IPv4SubnetCIDRBlock": c.IPv4SubnetCIDR,
+			},
+		},
+	}
+}
+
+func statsResponse(c Container) map[string]any {
+	return map[string]any{
+		"read": startedAt,
+		"name": "ecs-" + c.Name,
+		"id":   c.DockerID,
+		"cpu_stats": map[string]any{
+			"cpu_usage":        map[string]any{"total_usage": 1000000},
+			"system_cpu_usage": 100000000,
+			"online_cpus":      2,
+		},
+		"memory_stats": map[string]any{
+			"usage": 1 << 20,
+			"limit": uint64(c.Memory) << 20,
+		},
+		"networks": map[string]any{
+			"eth1": map[string]any{"rx_bytes": 1000, "tx_bytes": 2000},
+		},
+	}
+}
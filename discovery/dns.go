@@ -0,0 +1,189 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+)
+
+// DNSRecordType defines which DNS records are queried by DNSSource.
+type DNSRecordType string
+
+const (
+	// DNSRecordA queries IPv4 address records (default).
+	DNSRecordA DNSRecordType = "A"
+
+	// DNSRecordAAAA queries IPv6 address records.
+	DNSRecordAAAA DNSRecordType = "AAAA"
+
+	// DNSRecordSRV queries service records, whose targets are then resolved to addresses.
+	// Ports are taken from the SRV records.
+	DNSRecordSRV DNSRecordType = "SRV"
+)
+
+// DNSSourceOptions define settings for creating a DNSSource.
+type DNSSourceOptions struct {
+	// Name is the DNS name to query.
+	// The placeholder {service} is replaced by the service name.
+	// For instance, with Cloud Map: "{service}.my-namespace".
+	// If undefined, defaults to "{service}".
+	Name string
+
+	// RecordType selects the records to query: "A" (default), "AAAA" or "SRV".
+	RecordType DNSRecordType
+
+	// ResolverAddr optionally forces the DNS server address, for instance "10.0.0.2:53".
+	// If undefined, the system resolver is used.
+	ResolverAddr string
+
+	// Port is assigned to tasks discovered with A or AAAA records.
+	// It is ignored for SRV records, which carry their own ports.
+	Port int
+
+	// Timeout limits each DNS query, defaults to 5s if undefined.
+	Timeout time.Duration
+
+	// Logger optionally provides structured logger. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// DNSSource discovers tasks by querying DNS records, like the ones
+// created by Cloud Map service discovery in a private DNS namespace.
+// It requires neither AWS credentials nor the discovery agent.
+type DNSSource struct {
+	options  DNSSourceOptions
+	resolver *net.Resolver
+}
+
+// NewDNSSource creates a DNSSource.
+func NewDNSSource(options DNSSourceOptions) (*DNSSource, error) {
+
+	if options.Name == "" {
+		options.Name = "{service}"
+	}
+
+	if options.RecordType == "" {
+		options.RecordType = DNSRecordA
+	}
+
+	options.RecordType = DNSRecordType(strings.ToUpper(string(options.RecordType)))
+
+	switch options.RecordType {
+	case DNSRecordA, DNSRecordAAAA, DNSRecordSRV:
+	default:
+		return nil, fmt.Errorf("invalid DNS record type: %s", options.RecordType)
+	}
+
+	if options.Timeout == 0 {
+		options.Timeout = 5 * time.Second
+	}
+
+	if options.Logger == nil {
+		options.Logger = slog.Default()
+	}
+
+	s := &DNSSource{
+		options:  options,
+		resolver: net.DefaultResolver,
+	}
+
+	if options.ResolverAddr != "" {
+		s.resolver = newResolver(options.ResolverAddr)
+	}
+
+	return s, nil
+}
+
+// newResolver returns a resolver that sends every query to resolverAddr.
+func newResolver(resolverAddr string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, resolverAddr)
+		},
+	}
+}
+
+// Tasks discovers tasks for service by querying DNS.
+func (s *DNSSource) Tasks(ctx context.Context, serviceName string) ([]Task, error) {
+	name := strings.ReplaceAll(s.options.Name, "{service}", serviceName)
+
+	ctx, cancel := context.WithTimeout(ctx, s.options.Timeout)
+	defer cancel()
+
+	switch s.options.RecordType {
+	case DNSRecordSRV:
+		return s.srvTasks(ctx, name)
+	case DNSRecordAAAA:
+		return s.addrTasks(ctx, "ip6", name)
+	default:
+		return s.addrTasks(ctx, "ip4", name)
+	}
+}
+
+func (s *DNSSource) addrTasks(ctx context.Context, network, name string) ([]Task, error) {
+	ips, err := s.resolver.LookupIP(ctx, network, name)
+	if err != nil {
+		return nil, fmt.Errorf("dns lookup %s %s: %w", s.options.RecordType, name, err)
+	}
+
+	tasks := make([]Task, 0, len(ips))
+	for _, ip := range ips {
+		addr := ip.String()
+		tasks = append(tasks, Task{
+			ARN:        addr,
+			Address:    addr,
+			Port:       s.options.Port,
+			LastStatus: "RUNNING",
+		})
+	}
+
+	return tasks, nil
+}
+
+func (s *DNSSource) srvTasks(ctx context.Context, name string) ([]Task, error) {
+	_, records, err := s.resolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, fmt.Errorf("dns lookup SRV %s: %w", name, err)
+	}
+
+	tasks := make([]Task, 0, len(records))
+	for _, srv := range records {
+		addr, errAddr := s.lookupTarget(ctx, srv.Target)
+		if errAddr != nil {
+			s.options.Logger.Error("DNSSource: resolve SRV target", "name", name,
+				"target", srv.Target, "port", srv.Port, "error", errAddr)
+			continue
+		}
+		tasks = append(tasks, Task{
+			ARN:        strings.TrimSuffix(srv.Target, "."),
+			Address:    addr,
+			Port:       int(srv.Port),
+			LastStatus: "RUNNING",
+		})
+	}
+
+	return tasks, nil
+}
+
+// lookupTarget resolves an SRV target, preferring IPv4 over IPv6.
+func (s *DNSSource) lookupTarget(ctx context.Context, target string) (string, error) {
+	ips, err := s.resolver.LookupIP(ctx, "ip", target)
+	if err != nil {
+		return "", err
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip.String(), nil
+		}
+	}
+	if len(ips) > 0 {
+		return ips[0].String(), nil
+	}
+	return "", errors.New("no address found")
+}
@@ -0,0 +1,269 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsZone maps a query (name and type) to its resource record bodies.
+type dnsZone map[dnsmessage.Question][]dnsmessage.ResourceBody
+
+// newDNSServer starts an in-process UDP DNS server answering from zone.
+func newDNSServer(t *testing.T, zone dnsZone) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("dns server listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, errRead := conn.ReadFrom(buf)
+			if errRead != nil {
+				return // closed
+			}
+			var p dnsmessage.Parser
+			hdr, errParse := p.Start(buf[:n])
+			if errParse != nil {
+				continue
+			}
+			q, errQ := p.Question()
+			if errQ != nil {
+				continue
+			}
+			resp, errResp := dnsAnswer(hdr.ID, q, zone)
+			if errResp != nil {
+				t.Errorf("dns server answer: %v", errResp)
+				continue
+			}
+			conn.WriteTo(resp, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func dnsAnswer(id uint16, q dnsmessage.Question, zone dnsZone) ([]byte, error) {
+	key := dnsmessage.Question{Name: q.Name, Type: q.Type, Class: dnsmessage.ClassINET}
+	records, found := zone[key]
+
+	hdr := dnsmessage.Header{ID: id, Response: true, Authoritative: true}
+	if !found {
+		hdr.RCode = dnsmessage.RCodeNameError
+	}
+
+	b := dnsmessage.NewBuilder(nil, hdr)
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 10}
+	for _, r := range records {
+		var err error
+		switch body := r.(type) {
+		case *dnsmessage.AResource:
+			err = b.AResource(rh, *body)
+		case *dnsmessage.AAAAResource:
+			err = b.AAAAResource(rh, *body)
+		case *dnsmessage.SRVResource:
+			err = b.SRVResource(rh, *body)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+func dnsQuestion(name string, qtype dnsmessage.Type) dnsmessage.Question {
+	return dnsmessage.Question{
+		Name:  dnsmessage.MustNewName(name),
+		Type:  qtype,
+		Class: dnsmessage.ClassINET,
+	}
+}
+
+func testZone() dnsZone {
+	return dnsZone{
+		dnsQuestion("svc.ns.local.", dnsmessage.TypeA): {
+			&dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}},
+			&dnsmessage.AResource{A: [4]byte{10, 0, 0, 2}},
+		},
+		dnsQuestion("svc.ns.local.", dnsmessage.TypeAAAA): {
+			&dnsmessage.AAAAResource{AAAA: [16]byte{0xfd, 0, 15: 1}},
+		},
+		dnsQuestion("svc.ns.local.", dnsmessage.TypeSRV): {
+			&dnsmessage.SRVResource{Target: dnsmessage.MustNewName("task1.svc.ns.local."), Port: 5001},
+			&dnsmessage.SRVResource{Target: dnsmessage.MustNewName("task2.svc.ns.local."), Port: 5002},
+		},
+		dnsQuestion("task1.svc.ns.local.", dnsmessage.TypeA): {
+			&dnsmessage.AResource{A: [4]byte{10, 0, 0, 11}},
+		},
+		dnsQuestion("task1.svc.ns.local.", dnsmessage.TypeAAAA): {},
+		dnsQuestion("task2.svc.ns.local.", dnsmessage.TypeA): {
+			&dnsmessage.AResource{A: [4]byte{10, 0, 0, 12}},
+		},
+		dnsQuestion("task2.svc.ns.local.", dnsmessage.TypeAAAA): {},
+	}
+}
+
+func TestDNSSourceA(t *testing.T) {
+	resolverAddr := newDNSServer(t, testZone())
+
+	source, err := NewDNSSource(DNSSourceOptions{
+		Name:         "{service}.ns.local.",
+		ResolverAddr: resolverAddr,
+		Port:         5000,
+	})
+	if err != nil {
+		t.Fatalf("NewDNSSource() error: %v", err)
+	}
+
+	tasks, err := source.Tasks(context.Background(), "svc")
+	if err != nil {
+		t.Fatalf("Tasks() error: %v", err)
+	}
+
+	expected := []Task{
+		{ARN: "10.0.0.1", Address: "10.0.0.1", Port: 5000, LastStatus: "RUNNING"},
+		{ARN: "10.0.0.2", Address: "10.0.0.2", Port: 5000, LastStatus: "RUNNING"},
+	}
+	if len(tasks) != len(expected) {
+		t.Fatalf("expected %d tasks, got %d: %+v", len(expected), len(tasks), tasks)
+	}
+	for i := range expected {
+		if !tasks[i].Equal(expected[i]) {
+			t.Errorf("task %d: expected=%+v got=%+v", i, expected[i], tasks[i])
+		}
+	}
+}
+
+func TestDNSSourceAAAA(t *testing.T) {
+	resolverAddr := newDNSServer(t, testZone())
+
+	source, err := NewDNSSource(DNSSourceOptions{
+		Name:         "{service}.ns.local.",
+		RecordType:   "aaaa",
+		ResolverAddr: resolverAddr,
+	})
+	if err != nil {
+		t.Fatalf("NewDNSSource() error: %v", err)
+	}
+
+	tasks, err := source.Tasks(context.Background(), "svc")
+	if err != nil {
+		t.Fatalf("Tasks() error: %v", err)
+	}
+
+	if len(tasks) != 1 || tasks[0].Address != "fd00::1" {
+		t.Fatalf("unexpected tasks: %+v", tasks)
+	}
+}
+
+func TestDNSSourceSRV(t *testing.T) {
+	resolverAddr := newDNSServer(t, testZone())
+
+	source, err := NewDNSSource(DNSSourceOptions{
+		Name:         "{service}.ns.local.", // Cloud Map publishes SRV at the service name
+		RecordType:   DNSRecordSRV,
+		ResolverAddr: resolverAddr,
+	})
+	if err != nil {
+		t.Fatalf("NewDNSSource() error: %v", err)
+	}
+
+	tasks, err := source.Tasks(context.Background(), "svc")
+	if err != nil {
+		t.Fatalf("Tasks() error: %v", err)
+	}
+
+	ports := map[string]int{}
+	for _, task := range tasks {
+		ports[task.Address] = task.Port
+	}
+
+	expected := map[string]int{"10.0.0.11": 5001, "10.0.0.12": 5002}
+	if len(ports) != len(expected) {
+		t.Fatalf("expected %d tasks, got %d: %+v", len(expected), len(tasks), tasks)
+	}
+	for addr, port := range expected {
+		if ports[addr] != port {
+			t.Errorf("address %s: expected port %d, got %d", addr, port, ports[addr])
+		}
+	}
+}
+
+func TestDNSSourceNotFound(t *testing.T) {
+	resolverAddr := newDNSServer(t, testZone())
+
+	source, err := NewDNSSource(DNSSourceOptions{
+		Name:         "{service}.ns.local.",
+		ResolverAddr: resolverAddr,
+	})
+	if err != nil {
+		t.Fatalf("NewDNSSource() error: %v", err)
+	}
+
+	if _, err := source.Tasks(context.Background(), "missing"); err == nil {
+		t.Fatal("expected error for missing name, got nil")
+	}
+}
+
+func TestNewDNSSourceInvalidRecordType(t *testing.T) {
+	if _, err := NewDNSSource(DNSSourceOptions{RecordType: "MX"}); err == nil {
+		t.Fatal("expected error for invalid record type, got nil")
+	}
+}
+
+func TestDiscoveryWithDNSSource(t *testing.T) {
+	resolverAddr := newDNSServer(t, testZone())
+
+	t.Setenv(envVarMetadataURI, "") // no ECS metadata available
+	t.Setenv(envVarMetadataURIV3, "")
+
+	source, err := NewDNSSource(DNSSourceOptions{
+		Name:         "{service}.ns.local.",
+		ResolverAddr: resolverAddr,
+		Port:         5000,
+	})
+	if err != nil {
+		t.Fatalf("NewDNSSource() error: %v", err)
+	}
+
+	ch := make(chan []Task, 1)
+
+	d, err := New(Options{
+		ServiceName: "svc",
+		TaskSource:  source,
+		Callback:    func(tasks []Task) { ch <- tasks },
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer d.Stop()
+
+	select {
+	case tasks := <-ch:
+		if len(tasks) != 2 {
+			t.Fatalf("expected 2 tasks, got %d: %+v", len(tasks), tasks)
+		}
+		if tasks[0].Address != "10.0.0.1" || tasks[1].Address != "10.0.0.2" {
+			t.Fatalf("unexpected task addresses: %+v", tasks)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for callback")
+	}
+}
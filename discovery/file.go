@@ -0,0 +1,158 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileSourceOptions define settings for creating a FileSource.
+type FileSourceOptions struct {
+	// Path is the file holding lists of tasks per service.
+	// Files named *.yaml or *.yml are parsed as YAML, any other as JSON.
+	Path string
+
+	// Logger optionally provides structured logger. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// FileSource reads tasks from a static file, for local development.
+// The file maps service names to lists of tasks, with the same fields
+// as the agent JSON response:
+//
+//	my-service:
+//	  - arn: task-1
+//	    address: 127.0.0.1
+//	    port: 5001
+//	    health_status: HEALTHY
+//	  - address: 127.0.0.2 # arn defaults to address
+//
+// LastStatus defaults to "RUNNING". The file is reloaded when changed,
+// so editing it simulates membership churn. If reloading fails, the
+// previous tasks are kept.
+type FileSource struct {
+	options FileSourceOptions
+
+	mu       sync.Mutex
+	services map[string][]Task
+	modTime  time.Time
+	size     int64
+}
+
+// NewFileSource creates a FileSource. It fails if the file cannot be loaded.
+func NewFileSource(options FileSourceOptions) (*FileSource, error) {
+	if options.Path == "" {
+		return nil, fmt.Errorf("file source: missing path")
+	}
+
+	if options.Logger == nil {
+		options.Logger = slog.Default()
+	}
+
+	s := &FileSource{options: options}
+
+	info, errStat := os.Stat(options.Path)
+	if errStat != nil {
+		return nil, fmt.Errorf("file source: %w", errStat)
+	}
+
+	if err := s.reload(info); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Tasks returns tasks for service from the file, reloading it if changed.
+func (s *FileSource) Tasks(_ context.Context, serviceName string) ([]Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, errStat := os.Stat(s.options.Path)
+	switch {
+	case errStat != nil:
+		s.options.Logger.Error("FileSource: stat, keeping previous tasks",
+			"path", s.options.Path, "error", errStat)
+	case !info.ModTime().Equal(s.modTime) || info.Size() != s.size:
+		if err := s.reload(info); err != nil {
+			s.options.Logger.Error("FileSource: reload, keeping previous tasks",
+				"path", s.options.Path, "error", err)
+		} else {
+			s.options.Logger.Info("FileSource: reloaded",
+				"path", s.options.Path, "services", len(s.services))
+		}
+	}
+
+	tasks, found := s.services[serviceName]
+	if !found {
+		return nil, fmt.Errorf("file source: %s: service not found: %s", s.options.Path, serviceName)
+	}
+
+	return append([]Task(nil), tasks...), nil
+}
+
+// reload loads the file, recording its version from info.
+func (s *FileSource) reload(info os.FileInfo) error {
+	data, errRead := os.ReadFile(s.options.Path)
+	if errRead != nil {
+		return fmt.Errorf("file source: %w", errRead)
+	}
+
+	services, errParse := parseTaskFile(s.options.Path, data)
+	if errParse != nil {
+		return fmt.Errorf("file source: %s: %w", s.options.Path, errParse)
+	}
+
+	s.services = services
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+
+	return nil
+}
+
+// parseTaskFile decodes lists of tasks per service from JSON or YAML.
+func parseTaskFile(path string, data []byte) (map[string][]Task, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		// convert YAML to JSON so tasks are decoded with their JSON field names
+		var doc any
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		converted, err := json.Marshal(doc)
+		if err != nil {
+			return nil, fmt.Errorf("yaml: %w", err)
+		}
+		data = converted
+	}
+
+	var services map[string][]Task
+	if err := json.Unmarshal(data, &services); err != nil {
+		return nil, fmt.Errorf("json: %w", err)
+	}
+
+	for name, tasks := range services {
+		for i := range tasks {
+			t := &tasks[i]
+			if t.Address == "" {
+				return nil, fmt.Errorf("service %s: task %d: missing address", name, i+1)
+			}
+			if t.ARN == "" {
+				t.ARN = t.Address
+			}
+			if t.LastStatus == "" {
+				t.LastStatus = "RUNNING"
+			}
+		}
+	}
+
+	return services, nil
+}
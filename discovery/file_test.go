@@ -0,0 +1,180 @@
+package discovery
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const taskFileYAML = `
+svc:
+  - arn: task-1
+    address: 127.0.0.1
+    port: 5001
+    health_status: HEALTHY
+  - address: 127.0.0.2
+    port: 5002
+other: []
+`
+
+const taskFileJSON = `{
+	"svc": [
+		{"arn": "task-1", "address": "127.0.0.1", "port": 5001, "health_status": "HEALTHY"},
+		{"address": "127.0.0.2", "port": 5002}
+	],
+	"other": []
+}`
+
+// writeTaskFile writes data to path, bumping modification time so a reload is detected
+// even within the file system timestamp resolution.
+func writeTaskFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(time.Duration(len(data)) * time.Second)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileSourceFormats(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data string
+	}{
+		{"tasks.yaml", taskFileYAML},
+		{"tasks.yml", taskFileYAML},
+		{"tasks.json", taskFileJSON},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tc.name)
+			writeTaskFile(t, path, tc.data)
+
+			s, err := NewFileSource(FileSourceOptions{Path: path})
+			if err != nil {
+				t.Fatalf("NewFileSource() error: %v", err)
+			}
+
+			tasks, err := s.Tasks(context.Background(), "svc")
+			if err != nil {
+				t.Fatalf("Tasks() error: %v", err)
+			}
+			expected := []Task{
+				{ARN: "task-1", Address: "127.0.0.1", Port: 5001, HealthStatus: "HEALTHY", LastStatus: "RUNNING"},
+				{ARN: "127.0.0.2", Address: "127.0.0.2", Port: 5002, LastStatus: "RUNNING"},
+			}
+			if len(tasks) != len(expected) {
+				t.Fatalf("expected %d tasks, got %+v", len(expected), tasks)
+			}
+			for i := range expected {
+				if !tasks[i].Equal(expected[i]) {
+					t.Errorf("task %d: expected %+v, got %+v", i, expected[i], tasks[i])
+				}
+			}
+
+			if tasks, err := s.Tasks(context.Background(), "other"); err != nil || len(tasks) != 0 {
+				t.Errorf("expected empty service, got %+v error: %v", tasks, err)
+			}
+			if _, err := s.Tasks(context.Background(), "missing"); err == nil {
+				t.Error("expected error for missing service")
+			}
+		})
+	}
+}
+
+func TestFileSourceInvalid(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := NewFileSource(FileSourceOptions{Path: filepath.Join(dir, "missing.json")}); err == nil {
+		t.Error("expected error for missing file")
+	}
+
+	path := filepath.Join(dir, "tasks.json")
+	writeTaskFile(t, path, `{"svc": [{"arn": "no-address"}]}`)
+	if _, err := NewFileSource(FileSourceOptions{Path: path}); err == nil {
+		t.Error("expected error for task without address")
+	}
+
+	writeTaskFile(t, path, `not json`)
+	if _, err := NewFileSource(FileSourceOptions{Path: path}); err == nil {
+		t.Error("expected error for bad json")
+	}
+}
+
+func TestFileSourceReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.yaml")
+	writeTaskFile(t, path, "svc:\n  - address: 127.0.0.1\n")
+
+	s, err := NewFileSource(FileSourceOptions{Path: path})
+	if err != nil {
+		t.Fatalf("NewFileSource() error: %v", err)
+	}
+
+	// scale out
+	writeTaskFile(t, path, "svc:\n  - address: 127.0.0.1\n  - address: 127.0.0.2\n")
+	if tasks, _ := s.Tasks(context.Background(), "svc"); len(tasks) != 2 {
+		t.Fatalf("expected reload with 2 tasks, got %+v", tasks)
+	}
+
+	// broken edit keeps previous tasks
+	writeTaskFile(t, path, "svc: [broken")
+	if tasks, _ := s.Tasks(context.Background(), "svc"); len(tasks) != 2 {
+		t.Fatalf("expected previous 2 tasks, got %+v", tasks)
+	}
+
+	// scale in
+	writeTaskFile(t, path, "svc:\n  - address: 127.0.0.2\n")
+	if tasks, _ := s.Tasks(context.Background(), "svc"); len(tasks) != 1 || tasks[0].Address != "127.0.0.2" {
+		t.Fatalf("expected reload with 1 task, got %+v", tasks)
+	}
+}
+
+func TestFileSourceDiscovery(t *testing.T) {
+	t.Setenv(envVarMetadataURI, "") // no ECS metadata available
+	t.Setenv(envVarMetadataURIV3, "")
+
+	path := filepath.Join(t.TempDir(), "tasks.json")
+	writeTaskFile(t, path, taskFileJSON)
+
+	source, err := NewFileSource(FileSourceOptions{Path: path})
+	if err != nil {
+		t.Fatalf("NewFileSource() error: %v", err)
+	}
+
+	ch := make(chan []Task, 10)
+
+	d, err := New(Options{
+		ServiceName: "svc",
+		Interval:    10 * time.Millisecond,
+		TaskSource:  source,
+		Callback:    func(tasks []Task) { ch <- tasks },
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer d.Stop()
+
+	next := func() []Task {
+		t.Helper()
+		select {
+		case tasks := <-ch:
+			return tasks
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for callback")
+			return nil
+		}
+	}
+
+	if tasks := next(); len(tasks) != 2 {
+		t.Fatalf("expected 2 tasks, got %+v", tasks)
+	}
+
+	writeTaskFile(t, path, `{"svc": [{"address": "127.0.0.3"}]}`)
+
+	if tasks := next(); len(tasks) != 1 || tasks[0].Address != "127.0.0.3" {
+		t.Fatalf("expected reloaded task, got %+v", tasks)
+	}
+}
@@ -0,0 +1,265 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxDescribeTasks is the maximum number of tasks accepted by a DescribeTasks call.
+const maxDescribeTasks = 100
+
+// TaskFinderOptions define settings for creating a TaskFinder.
+type TaskFinderOptions struct {
+	// Client is required ECS client.
+	Client *ecs.Client
+
+	// Cluster is required cluster name.
+	Cluster string
+
+	// ServiceName is required service name.
+	ServiceName string
+
+	// DescribeConcurrency limits how many DescribeTasks calls run concurrently.
+	// ListTasks pages are described while next pages are listed.
+	// Defaults to 1 (sequential) if undefined.
+	DescribeConcurrency int
+
+	// Incremental keeps previously described tasks by ARN, and only
+	// describes ARNs that were not seen before. Tasks that disappear from
+	// ListTasks are dropped. Since task attributes like health status are not
+	// refreshed for known tasks, a full refresh happens every FullRefreshInterval.
+	Incremental bool
+
+	// FullRefreshInterval forces describing all tasks periodically in incremental mode.
+	// Defaults to 1m if undefined.
+	FullRefreshInterval time.Duration
+
+	// RateLimiter optionally limits ECS API calls and enables adaptive retry.
+	// It may be shared by many finders.
+	RateLimiter *RateLimiter
+
+	// MetricsRegisterer optionally sends metrics to Prometheus.
+	MetricsRegisterer prometheus.Registerer
+
+	// MetricsNamespace provides optional namespace for prometheus metrics.
+	MetricsNamespace string
+
+	// MetricsSink optionally receives metrics.
+	// If undefined and MetricsRegisterer is defined, defaults to a PrometheusSink.
+	MetricsSink MetricsSink
+
+	// TracerProvider optionally provides OpenTelemetry tracing of ECS API calls.
+	// Defaults to the global provider.
+	TracerProvider trace.TracerProvider
+
+	// Logger optionally provides structured logger. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// TaskFinder discovers running ECS tasks for a service.
+// It is safe for concurrent use.
+type TaskFinder struct {
+	options    TaskFinderOptions
+	apiOptions []func(*ecs.Options)
+	metrics    *metrics
+
+	mu          sync.Mutex
+	known       map[string]Task // by ARN, incremental mode only
+	lastRefresh time.Time       // last full refresh, incremental mode only
+}
+
+// NewTaskFinder creates a TaskFinder.
+func NewTaskFinder(options TaskFinderOptions) *TaskFinder {
+	if options.DescribeConcurrency < 1 {
+		options.DescribeConcurrency = 1
+	}
+	if options.FullRefreshInterval <= 0 {
+		options.FullRefreshInterval = time.Minute
+	}
+	if options.Logger == nil {
+		options.Logger = slog.Default()
+	}
+	m := newMetrics(options.MetricsSink, options.MetricsNamespace, options.MetricsRegisterer)
+	return &TaskFinder{
+		options:    options,
+		apiOptions: ecsOptions(options.RateLimiter, m, newTracer(options.TracerProvider), options.ServiceName),
+		metrics:    m,
+	}
+}
+
+// describeJob is a batch of task ARNs from a ListTasks page.
+type describeJob struct {
+	arns  []string
+	tasks []Task
+	err   error
+}
+
+// Tasks discovers running ECS tasks.
+// If DescribeTasks reports failures, Tasks returns the tasks described
+// successfully along with a *PartialResultError.
+func (f *TaskFinder) Tasks(ctx context.Context) ([]Task, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	cluster := f.options.Cluster
+	serviceName := f.options.ServiceName
+
+	now := time.Now()
+	full := !f.options.Incremental || f.known == nil ||
+		now.Sub(f.lastRefresh) >= f.options.FullRefreshInterval
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	desiredStatus := "RUNNING"
+	maxResults := int32(100) // 1..100
+
+	input := ecs.ListTasksInput{
+		Cluster:       aws.String(cluster),
+		ServiceName:   aws.String(serviceName),
+		MaxResults:    aws.Int32(maxResults),
+		DesiredStatus: types.DesiredStatus(desiredStatus),
+	}
+
+	var listed []string     // all listed ARNs, in order
+	var jobs []*describeJob // describe batches, in order
+	var pending []string    // ARNs not yet assigned to a batch
+	var wg sync.WaitGroup
+	var abortOnce sync.Once
+	var errAbort error // first describe error that cancelled ctx
+
+	sem := make(chan struct{}, f.options.DescribeConcurrency)
+
+	submit := func(arns []string) {
+		job := &describeJob{arns: arns}
+		jobs = append(jobs, job)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				job.err = ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
+			job.tasks, job.err = describeTasks(ctx, f.options.Logger, f.options.Client, cluster, job.arns, f.apiOptions...)
+			var partial *PartialResultError
+			if job.err != nil && !errors.As(job.err, &partial) && ctx.Err() == nil { // not a result of cancellation
+				abortOnce.Do(func() { errAbort = job.err })
+				cancel() // abort remaining work
+			}
+		}()
+	}
+
+	//
+	// scan over pages of ListTasks responses,
+	// describing each page while listing next ones
+	//
+	var errList error
+	for {
+		out, err := f.options.Client.ListTasks(ctx, &input, f.apiOptions...)
+		if err != nil {
+			errList = err
+			cancel() // result is discarded, abort running describe jobs
+			break
+		}
+		f.options.Logger.Debug("TaskFinder: ListTasks", "cluster", cluster, "service", serviceName,
+			"count", len(out.TaskArns), "max_results", maxResults)
+
+		listed = append(listed, out.TaskArns...)
+
+		for _, arn := range out.TaskArns {
+			if full {
+				pending = append(pending, arn)
+				continue
+			}
+			if _, found := f.known[arn]; !found {
+				pending = append(pending, arn)
+			}
+		}
+
+		// in incremental mode, batches fill up across pages
+		for len(pending) >= maxDescribeTasks {
+			submit(pending[:maxDescribeTasks:maxDescribeTasks])
+			pending = pending[maxDescribeTasks:]
+		}
+
+		if out.NextToken == nil {
+			break // finished last page
+		}
+		input.NextToken = out.NextToken // next page
+	}
+
+	if errList == nil && len(pending) > 0 {
+		submit(pending)
+	}
+
+	wg.Wait()
+
+	if errAbort != nil {
+		// report the cause, not "context canceled" from work aborted after it
+		return nil, errAbort
+	}
+
+	if errList != nil {
+		return nil, errList
+	}
+
+	described := map[string]Task{}
+	var failures []TaskFailure
+	for _, job := range jobs {
+		if job.err != nil {
+			var partial *PartialResultError
+			if !errors.As(job.err, &partial) {
+				return nil, job.err
+			}
+			failures = append(failures, partial.Failures...)
+		}
+		for _, t := range job.tasks {
+			described[t.ARN] = t
+		}
+	}
+
+	//
+	// merge described tasks with known tasks, in listing order
+	//
+	tasks := make([]Task, 0, len(listed))
+	for _, arn := range listed {
+		if t, found := described[arn]; found {
+			tasks = append(tasks, t)
+			continue
+		}
+		if t, found := f.known[arn]; found && !full {
+			tasks = append(tasks, t)
+		}
+	}
+
+	if f.options.Incremental {
+		known := make(map[string]Task, len(tasks))
+		for _, t := range tasks {
+			known[t.ARN] = t
+		}
+		f.known = known
+		if full {
+			f.lastRefresh = now
+		}
+	}
+
+	if len(failures) > 0 {
+		f.metrics.describeFailures(serviceName, failures)
+		// failed tasks are not known, so they are described again next time
+		return tasks, &PartialResultError{Failures: failures}
+	}
+
+	return tasks, nil
+}
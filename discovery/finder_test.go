@@ -0,0 +1,447 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// pagedECSTransport simulates ListTasks pagination and DescribeTasks
+// for a mutable set of tasks. It is safe for concurrent use.
+type pagedECSTransport struct {
+	pageSize      int
+	describeDelay time.Duration
+	listDelay     time.Duration // delays ListTasks pages after the first one
+	describeFail  bool          // rejects DescribeTasks
+	listFail      bool          // rejects ListTasks pages after the first one
+
+	listTasksCalls     atomic.Int64
+	describeTasksCalls atomic.Int64
+	describedTasks     atomic.Int64
+	inFlight           atomic.Int64
+	maxInFlight        atomic.Int64
+
+	mu      sync.Mutex
+	arns    []string
+	health  map[string]string
+	missing map[string]bool // reported as DescribeTasks failures
+}
+
+func newPagedECSTransport(tasks, pageSize int) *pagedECSTransport {
+	m := &pagedECSTransport{pageSize: pageSize, health: map[string]string{}, missing: map[string]bool{}}
+	for i := range tasks {
+		m.arns = append(m.arns, fmt.Sprintf("arn:aws:ecs:us-east-1:111122223333:task/demo/%05d", i))
+	}
+	return m
+}
+
+func (m *pagedECSTransport) setTasks(arns []string) {
+	m.mu.Lock()
+	m.arns = arns
+	m.mu.Unlock()
+}
+
+func (m *pagedECSTransport) setHealth(arn, health string) {
+	m.mu.Lock()
+	m.health[arn] = health
+	m.mu.Unlock()
+}
+
+func (m *pagedECSTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target := req.Header.Get("X-Amz-Target")
+
+	var input struct {
+		NextToken string   `json:"nextToken"`
+		Tasks     []string `json:"tasks"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		return nil, err
+	}
+
+	if strings.HasSuffix(target, ".ListTasks") && input.NextToken != "" && m.listDelay > 0 {
+		select {
+		case <-time.After(m.listDelay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+
+	if strings.HasSuffix(target, ".ListTasks") && input.NextToken != "" && m.listFail {
+		return &http.Response{
+			StatusCode: http.StatusBadRequest,
+			Body:       io.NopCloser(strings.NewReader(`{"__type":"InvalidParameterException","message":"bad token"}`)),
+			Header:     http.Header{"Content-Type": []string{"application/x-amz-json-1.1"}},
+		}, nil
+	}
+
+	if strings.HasSuffix(target, ".DescribeTasks") && m.describeFail {
+		return &http.Response{
+			StatusCode: http.StatusBadRequest,
+			Body:       io.NopCloser(strings.NewReader(`{"__type":"AccessDeniedException","message":"denied"}`)),
+			Header:     http.Header{"Content-Type": []string{"application/x-amz-json-1.1"}},
+		}, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var body any
+
+	switch {
+	case strings.HasSuffix(target, ".ListTasks"):
+		m.listTasksCalls.Add(1)
+		var start int
+		if input.NextToken != "" {
+			fmt.Sscanf(input.NextToken, "%d", &start)
+		}
+		end := min(start+m.pageSize, len(m.arns))
+		out := map[string]any{"taskArns": m.arns[start:end]}
+		if end < len(m.arns) {
+			out["nextToken"] = fmt.Sprint(end)
+		}
+		body = out
+	case strings.HasSuffix(target, ".DescribeTasks"):
+		m.describeTasksCalls.Add(1)
+		m.describedTasks.Add(int64(len(input.Tasks)))
+
+		if m.describeDelay > 0 {
+			inFlight := m.inFlight.Add(1)
+			for {
+				prev := m.maxInFlight.Load()
+				if inFlight <= prev || m.maxInFlight.CompareAndSwap(prev, inFlight) {
+					break
+				}
+			}
+			m.mu.Unlock()
+			var errCtx error
+			select {
+			case <-time.After(m.describeDelay):
+			case <-req.Context().Done():
+				errCtx = req.Context().Err()
+			}
+			m.mu.Lock()
+			m.inFlight.Add(-1)
+			if errCtx != nil {
+				return nil, errCtx
+			}
+		}
+
+		var tasks, failures []map[string]any
+		for i, arn := range input.Tasks {
+			if m.missing[arn] {
+				failures = append(failures, map[string]any{"arn": arn, "reason": "MISSING"})
+				continue
+			}
+			health := m.health[arn]
+			if health == "" {
+				health = "HEALTHY"
+			}
+			tasks = append(tasks, map[string]any{
+				"taskArn":      arn,
+				"healthStatus": health,
+				"lastStatus":   "RUNNING",
+				"attachments": []any{map[string]any{"details": []any{
+					map[string]any{"name": "privateIPv4Address", "value": fmt.Sprintf("10.0.%d.%d", i/256, i%256)},
+				}}},
+			})
+		}
+		body = map[string]any{"tasks": tasks, "failures": failures}
+	default:
+		return &http.Response{
+			StatusCode: http.StatusBadRequest,
+			Body:       io.NopCloser(strings.NewReader("unexpected target: " + target)),
+			Header:     make(http.Header),
+		}, nil
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(string(data))),
+		Header:     make(http.Header),
+	}, nil
+}
+
+func newPagedClient(transport http.RoundTripper) *ecs.Client {
+	return ecs.NewFromConfig(aws.Config{
+		Region:     "us-east-1",
+		HTTPClient: &http.Client{Transport: transport},
+	})
+}
+
+func taskARNs(tasks []Task) []string {
+	var arns []string
+	for _, t := range tasks {
+		arns = append(arns, t.ARN)
+	}
+	return arns
+}
+
+func TestTaskFinderConcurrentKeepsOrder(t *testing.T) {
+	transport := newPagedECSTransport(550, 100)
+	transport.describeDelay = 20 * time.Millisecond
+
+	finder := NewTaskFinder(TaskFinderOptions{
+		Client:              newPagedClient(transport),
+		Cluster:             "demo",
+		ServiceName:         "svc",
+		DescribeConcurrency: 3,
+	})
+
+	tasks, err := finder.Tasks(context.Background())
+	if err != nil {
+		t.Fatalf("Tasks() unexpected error: %v", err)
+	}
+
+	if got := strings.Join(taskARNs(tasks), ","); got != strings.Join(transport.arns, ",") {
+		t.Fatal("Tasks() did not preserve listing order")
+	}
+	if got := transport.describeTasksCalls.Load(); got != 6 {
+		t.Fatalf("expected 6 DescribeTasks calls, got %d", got)
+	}
+	if got := transport.maxInFlight.Load(); got < 2 || got > 3 {
+		t.Fatalf("expected concurrent DescribeTasks bounded by 3, got max in flight %d", got)
+	}
+}
+
+func TestTaskFinderSequentialByDefault(t *testing.T) {
+	transport := newPagedECSTransport(250, 100)
+	transport.describeDelay = 5 * time.Millisecond
+
+	finder := NewTaskFinder(TaskFinderOptions{
+		Client:      newPagedClient(transport),
+		Cluster:     "demo",
+		ServiceName: "svc",
+	})
+
+	if _, err := finder.Tasks(context.Background()); err != nil {
+		t.Fatalf("Tasks() unexpected error: %v", err)
+	}
+	if got := transport.maxInFlight.Load(); got != 1 {
+		t.Fatalf("expected sequential DescribeTasks, got max in flight %d", got)
+	}
+}
+
+func TestTaskFinderIncremental(t *testing.T) {
+	transport := newPagedECSTransport(150, 100)
+
+	finder := NewTaskFinder(TaskFinderOptions{
+		Client:              newPagedClient(transport),
+		Cluster:             "demo",
+		ServiceName:         "svc",
+		Incremental:         true,
+		FullRefreshInterval: time.Hour,
+	})
+
+	first, err := finder.Tasks(context.Background())
+	if err != nil {
+		t.Fatalf("Tasks() unexpected error: %v", err)
+	}
+	if len(first) != 150 || transport.describedTasks.Load() != 150 {
+		t.Fatalf("first poll: tasks=%d described=%d", len(first), transport.describedTasks.Load())
+	}
+
+	// remove one task, add two new ones
+	arns := append([]string{}, transport.arns[1:]...)
+	arns = append(arns, "arn:new:1", "arn:new:2")
+	transport.setTasks(arns)
+	transport.describedTasks.Store(0)
+
+	second, err := finder.Tasks(context.Background())
+	if err != nil {
+		t.Fatalf("Tasks() unexpected error: %v", err)
+	}
+	if got := transport.describedTasks.Load(); got != 2 {
+		t.Fatalf("expected only 2 new tasks described, got %d", got)
+	}
+	if got := strings.Join(taskARNs(second), ","); got != strings.Join(arns, ",") {
+		t.Fatal("incremental poll returned unexpected tasks")
+	}
+}
+
+func TestTaskFinderIncrementalFullRefresh(t *testing.T) {
+	transport := newPagedECSTransport(3, 100)
+
+	finder := NewTaskFinder(TaskFinderOptions{
+		Client:              newPagedClient(transport),
+		Cluster:             "demo",
+		ServiceName:         "svc",
+		Incremental:         true,
+		FullRefreshInterval: 50 * time.Millisecond,
+	})
+
+	if _, err := finder.Tasks(context.Background()); err != nil {
+		t.Fatalf("Tasks() unexpected error: %v", err)
+	}
+
+	transport.setHealth(transport.arns[0], "UNHEALTHY")
+
+	tasks, err := finder.Tasks(context.Background())
+	if err != nil {
+		t.Fatalf("Tasks() unexpected error: %v", err)
+	}
+	if tasks[0].HealthStatus != "HEALTHY" {
+		t.Fatalf("expected cached health before full refresh, got %s", tasks[0].HealthStatus)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	tasks, err = finder.Tasks(context.Background())
+	if err != nil {
+		t.Fatalf("Tasks() unexpected error: %v", err)
+	}
+	if tasks[0].HealthStatus != "UNHEALTHY" {
+		t.Fatalf("expected refreshed health after full refresh, got %s", tasks[0].HealthStatus)
+	}
+}
+
+func TestTaskFinderPartialResult(t *testing.T) {
+	transport := newPagedECSTransport(250, 100)
+	missing := transport.arns[120]
+	transport.missing[missing] = true
+
+	finder := NewTaskFinder(TaskFinderOptions{
+		Client:              newPagedClient(transport),
+		Cluster:             "demo",
+		ServiceName:         "svc",
+		DescribeConcurrency: 2,
+		Incremental:         true,
+		FullRefreshInterval: time.Hour,
+		MetricsRegisterer:   prometheus.NewRegistry(),
+	})
+
+	tasks, err := finder.Tasks(context.Background())
+
+	if failures := testutil.ToFloat64(prometheusSink(t, finder.metrics).describeFailure.WithLabelValues("svc", "MISSING")); failures != 1 {
+		t.Errorf("expected describe failure counted by finder, got %v", failures)
+	}
+
+	var partial *PartialResultError
+	if !errors.As(err, &partial) {
+		t.Fatalf("expected PartialResultError, got %v", err)
+	}
+	if len(partial.Failures) != 1 || partial.Failures[0].ARN != missing {
+		t.Fatalf("unexpected failures: %+v", partial.Failures)
+	}
+	if len(tasks) != 249 {
+		t.Fatalf("expected 249 described tasks, got %d", len(tasks))
+	}
+
+	// failed task is described again on next poll
+	transport.mu.Lock()
+	delete(transport.missing, missing)
+	transport.mu.Unlock()
+	transport.describedTasks.Store(0)
+
+	tasks, err = finder.Tasks(context.Background())
+	if err != nil {
+		t.Fatalf("Tasks() unexpected error: %v", err)
+	}
+	if len(tasks) != 250 || transport.describedTasks.Load() != 1 {
+		t.Fatalf("expected failed task described again: tasks=%d described=%d",
+			len(tasks), transport.describedTasks.Load())
+	}
+}
+
+func TestTaskFinderDescribeError(t *testing.T) {
+	finder := NewTaskFinder(TaskFinderOptions{
+		Client: newPagedClient(&describeTasksTransport{}), // rejects ListTasks
+	})
+
+	if _, err := finder.Tasks(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestTaskFinderDescribeErrorCancelsListing(t *testing.T) {
+	transport := newPagedECSTransport(300, 100)
+	transport.describeFail = true
+	transport.listDelay = 2 * time.Second // still listing when describe fails
+
+	finder := NewTaskFinder(TaskFinderOptions{
+		Client:              newPagedClient(transport),
+		Cluster:             "demo",
+		ServiceName:         "svc",
+		DescribeConcurrency: 4,
+	})
+
+	_, err := finder.Tasks(context.Background())
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Fatalf("expected describe error, got: %v", err)
+	}
+}
+
+func TestTaskFinderListErrorCancelsDescribe(t *testing.T) {
+	transport := newPagedECSTransport(300, 100)
+	transport.listFail = true
+	transport.describeDelay = 5 * time.Second // still describing when listing fails
+
+	finder := NewTaskFinder(TaskFinderOptions{
+		Client:              newPagedClient(transport),
+		Cluster:             "demo",
+		ServiceName:         "svc",
+		DescribeConcurrency: 4,
+	})
+
+	begin := time.Now()
+	_, err := finder.Tasks(context.Background())
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), "InvalidParameter") {
+		t.Fatalf("expected list error, got: %v", err)
+	}
+	if elapsed := time.Since(begin); elapsed >= transport.describeDelay {
+		t.Fatalf("running describe not cancelled: elapsed=%v", elapsed)
+	}
+}
+
+// go test -run=^$ -bench=BenchmarkTaskFinder ./discovery
+func BenchmarkTaskFinderFull(b *testing.B) {
+	benchmarkTaskFinder(b, false)
+}
+
+func BenchmarkTaskFinderIncremental(b *testing.B) {
+	benchmarkTaskFinder(b, true)
+}
+
+func benchmarkTaskFinder(b *testing.B, incremental bool) {
+	transport := newPagedECSTransport(1000, 100)
+
+	finder := NewTaskFinder(TaskFinderOptions{
+		Client:              newPagedClient(transport),
+		Cluster:             "demo",
+		ServiceName:         "svc",
+		DescribeConcurrency: 4,
+		Incremental:         incremental,
+		FullRefreshInterval: time.Hour,
+	})
+
+	for b.Loop() {
+		if _, err := finder.Tasks(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(transport.describeTasksCalls.Load())/float64(b.N), "describe_calls/op")
+	b.ReportMetric(float64(transport.describedTasks.Load())/float64(b.N), "described_tasks/op")
+}
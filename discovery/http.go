@@ -1,9 +1,20 @@
 package discovery
 
 import (
+	"crypto/tls"
+	"errors"
 	"net"
 	"net/http"
+	"os"
 	"time"
+
+	"github.com/udhos/ecs-task-discovery/internal/tlsreload"
+)
+
+const (
+	envAgentCAFile   = "ECS_TASK_DISCOVERY_AGENT_CA_FILE"
+	envAgentCertFile = "ECS_TASK_DISCOVERY_AGENT_CERT_FILE"
+	envAgentKeyFile  = "ECS_TASK_DISCOVERY_AGENT_KEY_FILE"
 )
 
 // newHTTPClient returns a new http.Client with custom settings for timeouts and connection pooling.
@@ -14,6 +25,60 @@ func newHTTPClient() *http.Client {
 	}
 }
 
+// newAgentHTTPClient returns a new http.Client for querying the agent,
+// with optional TLS settings: CA bundle for verifying the agent, and client
+// certificate for mutual TLS. The client certificate is reloaded on change.
+func newAgentHTTPClient(options Options) (*http.Client, error) {
+	caFile := firstNonEmpty(options.AgentCAFile, os.Getenv(envAgentCAFile))
+	certFile := firstNonEmpty(options.AgentCertFile, os.Getenv(envAgentCertFile))
+	keyFile := firstNonEmpty(options.AgentKeyFile, os.Getenv(envAgentKeyFile))
+
+	client := newHTTPClient()
+
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return client, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pool, err := tlsreload.LoadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("agent client certificate requires both cert file and key file")
+		}
+		cert, err := tlsreload.NewCertificate(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = cert.GetClientCertificate
+	}
+
+	client.Transport.(*http.Transport).TLSClientConfig = tlsConfig
+
+	return client, nil
+}
+
+// agentWatchDeadline bounds an agent watch request. The agent holds a long
+// poll for its WATCH_TIMEOUT, which it clamps well below this deadline.
+const agentWatchDeadline = 2 * time.Minute
+
+// newWatchHTTPClient returns a copy of the agent client for long-polling the
+// agent watch endpoint. It has neither overall timeout nor response header
+// timeout, since the agent replies only on change or when its watch timeout
+// expires. Each watch request is bounded by agentWatchDeadline instead.
+func newWatchHTTPClient(client *http.Client) *http.Client {
+	t := client.Transport.(*http.Transport).Clone() // keeps TLS settings
+	t.ResponseHeaderTimeout = 0
+	return &http.Client{Transport: t}
+}
+
 // newTransport returns a new http.Transport with custom settings for timeouts and connection pooling.
 func newTransport() *http.Transport {
 	dialer := net.Dialer{
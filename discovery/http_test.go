@@ -0,0 +1,109 @@
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/udhos/ecs-task-discovery/internal/testcert"
+	"github.com/udhos/ecs-task-discovery/internal/tlsreload"
+)
+
+func TestAgentMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := testcert.NewCA(t, dir, "ca")
+	serverCert, serverKey := ca.Issue(t, dir, "server")
+	clientCert, clientKey := ca.Issue(t, dir, "client")
+
+	cert, err := tls.LoadX509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := tlsreload.LoadCertPool(ca.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`[{"arn":"a","address":"10.0.0.1"}]`))
+	}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	t.Run("with client certificate", func(t *testing.T) {
+		options := Options{
+			ServiceName:   "svc",
+			AgentURL:      ts.URL + "/tasks",
+			AgentCAFile:   ca.File,
+			AgentCertFile: clientCert,
+			AgentKeyFile:  clientKey,
+		}
+		client, errClient := newAgentHTTPClient(options)
+		if errClient != nil {
+			t.Fatalf("newAgentHTTPClient() error: %v", errClient)
+		}
+		d := &Discovery{options: options, clusterName: "demo", httpClient: client}
+		tasks, errQuery := d.queryAgent(context.Background())
+		if errQuery != nil {
+			t.Fatalf("queryAgent() error: %v", errQuery)
+		}
+		if len(tasks) != 1 {
+			t.Fatalf("expected 1 task, got %d", len(tasks))
+		}
+	})
+
+	t.Run("without client certificate", func(t *testing.T) {
+		options := Options{
+			ServiceName: "svc",
+			AgentURL:    ts.URL + "/tasks",
+			AgentCAFile: ca.File,
+		}
+		client, errClient := newAgentHTTPClient(options)
+		if errClient != nil {
+			t.Fatalf("newAgentHTTPClient() error: %v", errClient)
+		}
+		d := &Discovery{options: options, clusterName: "demo", httpClient: client}
+		if _, errQuery := d.queryAgent(context.Background()); errQuery == nil {
+			t.Fatal("expected TLS error without client certificate")
+		}
+	})
+}
+
+func TestNewAgentHTTPClientRequiresKeyFile(t *testing.T) {
+	if _, err := newAgentHTTPClient(Options{AgentCertFile: "cert.pem"}); err == nil {
+		t.Fatal("expected error for cert file without key file")
+	}
+}
+
+func TestNewWatchHTTPClient(t *testing.T) {
+	dir := t.TempDir()
+	ca := testcert.NewCA(t, dir, "ca")
+
+	client, err := newAgentHTTPClient(Options{AgentCAFile: ca.File})
+	if err != nil {
+		t.Fatalf("newAgentHTTPClient() error: %v", err)
+	}
+
+	watchClient := newWatchHTTPClient(client)
+
+	if watchClient.Timeout != 0 {
+		t.Errorf("expected no overall timeout, got %v", watchClient.Timeout)
+	}
+	transport := watchClient.Transport.(*http.Transport)
+	if transport.ResponseHeaderTimeout != 0 {
+		t.Errorf("expected no response header timeout, got %v", transport.ResponseHeaderTimeout)
+	}
+	if transport.TLSClientConfig == nil || transport.TLSClientConfig.RootCAs == nil {
+		t.Error("expected TLS settings from agent client")
+	}
+	if client.Transport.(*http.Transport).ResponseHeaderTimeout == 0 {
+		t.Error("agent client transport must keep its response header timeout")
+	}
+}
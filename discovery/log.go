@@ -11,10 +11,19 @@ func fatalf(format string, a ...any) {
 	os.Exit(1)
 }
 
-func infof(format string, a ...any) {
-	slog.Info(fmt.Sprintf(format, a...))
+// newLogger returns logger, or slog.Default() if nil, enriched with cluster and service.
+func newLogger(logger *slog.Logger, cluster, service string) *slog.Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return logger.With("cluster", cluster, "service", service)
 }
 
-func errorf(format string, a ...any) {
-	slog.Error(fmt.Sprintf(format, a...))
+// log returns the Discovery logger.
+// It falls back to the default logger for a Discovery not created by New.
+func (d *Discovery) log() *slog.Logger {
+	if d.logger == nil {
+		d.logger = newLogger(nil, d.clusterName, d.options.ServiceName)
+	}
+	return d.logger
 }
@@ -0,0 +1,273 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	envVarMetadataURI   = "ECS_CONTAINER_METADATA_URI_V4"
+	envVarMetadataURIV3 = "ECS_CONTAINER_METADATA_URI"
+)
+
+// MetadataClientOptions define settings for creating a MetadataClient.
+type MetadataClientOptions struct {
+	// BaseURL optionally provides the metadata endpoint.
+	// If undefined, retrieves value from env var ECS_CONTAINER_METADATA_URI_V4,
+	// falling back to ECS_CONTAINER_METADATA_URI (version 3).
+	BaseURL string
+
+	// HTTPClient optionally provides the http client.
+	HTTPClient *http.Client
+
+	// Retries is the number of retries for transient errors:
+	// connection errors, 429 and 5xx responses. Defaults to 3.
+	// Negative disables retries.
+	Retries int
+
+	// RetryInterval is the wait before the first retry, doubled on each retry.
+	// Defaults to 100ms.
+	RetryInterval time.Duration
+}
+
+// MetadataClient queries the ECS container metadata endpoint.
+//
+// Version 4: https://docs.aws.amazon.com/AmazonECS/latest/developerguide/task-metadata-endpoint-v4.html
+// Version 3: https://docs.aws.amazon.com/AmazonECS/latest/developerguide/task-metadata-endpoint-v3.html
+type MetadataClient struct {
+	options MetadataClientOptions
+	version int
+}
+
+// NewMetadataClient creates a MetadataClient.
+// It fails if no metadata endpoint is available, as when not running on ECS.
+func NewMetadataClient(options MetadataClientOptions) (*MetadataClient, error) {
+	version := 4
+	if options.BaseURL == "" {
+		options.BaseURL = os.Getenv(envVarMetadataURI)
+	}
+	if options.BaseURL == "" {
+		options.BaseURL = os.Getenv(envVarMetadataURIV3)
+		version = 3
+	}
+	if options.BaseURL == "" {
+		return nil, fmt.Errorf("env vars '%s' and '%s' are empty", envVarMetadataURI, envVarMetadataURIV3)
+	}
+	options.BaseURL = strings.TrimSuffix(options.BaseURL, "/")
+	if options.HTTPClient == nil {
+		options.HTTPClient = newHTTPClient()
+	}
+	if options.Retries == 0 {
+		options.Retries = 3
+	}
+	if options.RetryInterval <= 0 {
+		options.RetryInterval = 100 * time.Millisecond
+	}
+	return &MetadataClient{options: options, version: version}, nil
+}
+
+// Version returns the metadata endpoint version: 4 or 3.
+func (c *MetadataClient) Version() int {
+	return c.version
+}
+
+// TaskMetadata is the response from the task metadata endpoint.
+// Some fields are only available in version 4, or only on Fargate.
+type TaskMetadata struct {
+	Cluster          string              `json:"Cluster"`
+	TaskARN          string              `json:"TaskARN"`
+	Family           string              `json:"Family"`
+	Revision         string              `json:"Revision"`
+	DesiredStatus    string              `json:"DesiredStatus"`
+	KnownStatus      string              `json:"KnownStatus"`
+	AvailabilityZone string              `json:"AvailabilityZone"`
+	LaunchType       string              `json:"LaunchType"`
+	Limits           Limits              `json:"Limits"`
+	PullStartedAt    time.Time           `json:"PullStartedAt"`
+	PullStoppedAt    time.Time           `json:"PullStoppedAt"`
+	Containers       []ContainerMetadata `json:"Containers"`
+}
+
+// ContainerMetadata is the response from the container metadata endpoint,
+// also found in TaskMetadata.Containers.
+type ContainerMetadata struct {
+	DockerID      string            `json:"DockerId"`
+	Name          string            `json:"Name"`
+	DockerName    string            `json:"DockerName"`
+	Image         string            `json:"Image"`
+	ImageID       string            `json:"ImageID"`
+	Labels        map[string]string `json:"Labels"`
+	DesiredStatus string            `json:"DesiredStatus"`
+	KnownStatus   string            `json:"KnownStatus"`
+	Limits        Limits            `json:"Limits"`
+	CreatedAt     time.Time         `json:"CreatedAt"`
+	StartedAt     time.Time         `json:"StartedAt"`
+	Type          string            `json:"Type"`
+	ContainerARN  string            `json:"ContainerARN"`
+	Networks      []NetworkMetadata `json:"Networks"`
+}
+
+// Limits are CPU units and memory (MiB) limits.
+type Limits struct {
+	CPU    float64 `json:"CPU"`
+	Memory int64   `json:"Memory"`
+}
+
+// NetworkMetadata describes a container network.
+type NetworkMetadata struct {
+	NetworkMode              string   `json:"NetworkMode"`
+	IPv4Addresses            []string `json:"IPv4Addresses"`
+	IPv6Addresses            []string `json:"IPv6Addresses"`
+	AttachmentIndex          int      `json:"AttachmentIndex"`
+	MACAddress               string   `json:"MACAddress"`
+	IPv4SubnetCIDRBlock      string   `json:"IPv4SubnetCIDRBlock"`
+	IPv6SubnetCIDRBlock      string   `json:"IPv6SubnetCIDRBlock"`
+	PrivateDNSName           string   `json:"PrivateDNSName"`
+	SubnetGatewayIPv4Address string   `json:"SubnetGatewayIpv4Address"`
+}
+
+// ContainerStats holds the main fields of the Docker stats response
+// from the stats endpoints.
+type ContainerStats struct {
+	Read        time.Time               `json:"read"`
+	CPUStats    CPUStats                `json:"cpu_stats"`
+	PreCPUStats CPUStats                `json:"precpu_stats"`
+	MemoryStats MemoryStats             `json:"memory_stats"`
+	Networks    map[string]NetworkStats `json:"networks"`
+}
+
+// CPUStats holds CPU usage, in nanoseconds.
+type CPUStats struct {
+	CPUUsage struct {
+		TotalUsage uint64 `json:"total_usage"`
+	} `json:"cpu_usage"`
+	SystemCPUUsage uint64 `json:"system_cpu_usage"`
+	OnlineCPUs     int    `json:"online_cpus"`
+}
+
+// MemoryStats holds memory usage, in bytes.
+type MemoryStats struct {
+	Usage    uint64 `json:"usage"`
+	MaxUsage uint64 `json:"max_usage"`
+	Limit    uint64 `json:"limit"`
+}
+
+// NetworkStats holds network interface counters.
+type NetworkStats struct {
+	RxBytes   uint64 `json:"rx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	RxErrors  uint64 `json:"rx_errors"`
+	RxDropped uint64 `json:"rx_dropped"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	TxPackets uint64 `json:"tx_packets"`
+	TxErrors  uint64 `json:"tx_errors"`
+	TxDropped uint64 `json:"tx_dropped"`
+}
+
+// Task queries the task metadata: ${ECS_CONTAINER_METADATA_URI_V4}/task.
+func (c *MetadataClient) Task(ctx context.Context) (TaskMetadata, error) {
+	var task TaskMetadata
+	err := c.get(ctx, "/task", &task)
+	return task, err
+}
+
+// Container queries our own container metadata: ${ECS_CONTAINER_METADATA_URI_V4}.
+func (c *MetadataClient) Container(ctx context.Context) (ContainerMetadata, error) {
+	var container ContainerMetadata
+	err := c.get(ctx, "", &container)
+	return container, err
+}
+
+// TaskStats queries stats for all containers in the task, by Docker ID:
+// ${ECS_CONTAINER_METADATA_URI_V4}/task/stats.
+func (c *MetadataClient) TaskStats(ctx context.Context) (map[string]ContainerStats, error) {
+	var stats map[string]ContainerStats
+	err := c.get(ctx, "/task/stats", &stats)
+	return stats, err
+}
+
+// ContainerStats queries stats for our own container: ${ECS_CONTAINER_METADATA_URI_V4}/stats.
+func (c *MetadataClient) ContainerStats(ctx context.Context) (ContainerStats, error) {
+	var stats ContainerStats
+	err := c.get(ctx, "/stats", &stats)
+	return stats, err
+}
+
+// Address returns the first IPv4 address of our own container,
+// falling back to the first IPv6 address.
+func (c *MetadataClient) Address(ctx context.Context) (string, error) {
+	container, err := c.Container(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, n := range container.Networks {
+		if len(n.IPv4Addresses) > 0 {
+			return n.IPv4Addresses[0], nil
+		}
+	}
+	for _, n := range container.Networks {
+		if len(n.IPv6Addresses) > 0 {
+			return n.IPv6Addresses[0], nil
+		}
+	}
+	return "", fmt.Errorf("container %s: no network address found", container.Name)
+}
+
+// errTransient marks errors worth retrying.
+var errTransient = errors.New("transient")
+
+// get queries path and decodes the JSON response into result, retrying transient errors.
+func (c *MetadataClient) get(ctx context.Context, path string, result any) error {
+	interval := c.options.RetryInterval
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = c.getOnce(ctx, path, result)
+		if err == nil || !errors.Is(err, errTransient) || attempt >= c.options.Retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(interval):
+		}
+		interval *= 2
+	}
+}
+
+func (c *MetadataClient) getOnce(ctx context.Context, path string, result any) error {
+	uri := c.options.BaseURL + path
+	req, errReq := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if errReq != nil {
+		return errReq
+	}
+	resp, errGet := c.options.HTTPClient.Do(req)
+	if errGet != nil {
+		if ctx.Err() != nil {
+			return errGet
+		}
+		return fmt.Errorf("%w: %w", errTransient, errGet)
+	}
+	defer resp.Body.Close()
+	body, errBody := io.ReadAll(resp.Body)
+	if errBody != nil {
+		return fmt.Errorf("%w: status:%d uri:%s body_error:%w", errTransient, resp.StatusCode, uri, errBody)
+	}
+	if resp.StatusCode != 200 {
+		err := fmt.Errorf("bad_status:%d uri:%s body:%s", resp.StatusCode, uri, string(body))
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return fmt.Errorf("%w: %w", errTransient, err)
+		}
+		return err
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("status:%d uri:%s json_error:%v", resp.StatusCode, uri, err)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/udhos/ecs-task-discovery/discovery/discoverytest"
+)
+
+var fakeTask = discoverytest.Task{
+	Cluster:          "arn:aws:ecs:us-east-1:111122223333:cluster/demo",
+	TaskARN:          "arn:aws:ecs:us-east-1:111122223333:task/demo/self",
+	Family:           "demo",
+	Revision:         "7",
+	AvailabilityZone: "us-east-1a",
+	CPU:              0.5,
+	Memory:           1024,
+	Containers: []discoverytest.Container{
+		{
+			Name:           "app",
+			DockerID:       "app-id",
+			Image:          "demo:latest",
+			IPv4Addresses:  []string{"10.0.0.5"},
+			IPv6Addresses:  []string{"2001:db8::5"},
+			IPv4SubnetCIDR: "10.0.0.0/24",
+			Memory:         512,
+		},
+		{
+			Name:          "sidecar",
+			DockerID:      "sidecar-id",
+			IPv4Addresses: []string{"10.0.0.5"},
+			Memory:        128,
+		},
+	},
+}
+
+func TestMetadataClient(t *testing.T) {
+	server := discoverytest.NewMetadataServer(fakeTask)
+	defer server.Close()
+	server.Setenv(t)
+
+	client, err := NewMetadataClient(MetadataClientOptions{})
+	if err != nil {
+		t.Fatalf("NewMetadataClient() error: %v", err)
+	}
+	if client.Version() != 4 {
+		t.Errorf("expected version 4, got %d", client.Version())
+	}
+
+	ctx := context.Background()
+
+	task, err := client.Task(ctx)
+	if err != nil {
+		t.Fatalf("Task() error: %v", err)
+	}
+	if task.Cluster != fakeTask.Cluster || task.TaskARN != fakeTask.TaskARN ||
+		task.Family != "demo" || task.Revision != "7" ||
+		task.AvailabilityZone != "us-east-1a" || task.LaunchType != "FARGATE" {
+		t.Errorf("unexpected task metadata: %+v", task)
+	}
+	if task.Limits.CPU != 0.5 || task.Limits.Memory != 1024 {
+		t.Errorf("unexpected task limits: %+v", task.Limits)
+	}
+	if len(task.Containers) != 2 {
+		t.Fatalf("expected 2 containers, got %d", len(task.Containers))
+	}
+	network := task.Containers[0].Networks[0]
+	if network.IPv4Addresses[0] != "10.0.0.5" || network.IPv6Addresses[0] != "2001:db8::5" ||
+		network.IPv4SubnetCIDRBlock != "10.0.0.0/24" {
+		t.Errorf("unexpected network: %+v", network)
+	}
+
+	container, err := client.Container(ctx)
+	if err != nil {
+		t.Fatalf("Container() error: %v", err)
+	}
+	if container.Name != "app" || container.DockerID != "app-id" || container.Limits.Memory != 512 {
+		t.Errorf("unexpected container metadata: %+v", container)
+	}
+
+	addr, err := client.Address(ctx)
+	if err != nil || addr != "10.0.0.5" {
+		t.Errorf("Address(): expected 10.0.0.5, got %q error: %v", addr, err)
+	}
+
+	stats, err := client.ContainerStats(ctx)
+	if err != nil {
+		t.Fatalf("ContainerStats() error: %v", err)
+	}
+	if stats.MemoryStats.Limit != 512<<20 || stats.Networks["eth1"].TxBytes != 2000 {
+		t.Errorf("unexpected container stats: %+v", stats)
+	}
+
+	taskStats, err := client.TaskStats(ctx)
+	if err != nil {
+		t.Fatalf("TaskStats() error: %v", err)
+	}
+	if len(taskStats) != 2 || taskStats["sidecar-id"].MemoryStats.Limit != 128<<20 {
+		t.Errorf("unexpected task stats: %+v", taskStats)
+	}
+}
+
+func TestMetadataClientV3(t *testing.T) {
+	server := discoverytest.NewMetadataServer(fakeTask)
+	defer server.Close()
+	server.SetenvV3(t)
+
+	client, err := NewMetadataClient(MetadataClientOptions{})
+	if err != nil {
+		t.Fatalf("NewMetadataClient() error: %v", err)
+	}
+	if client.Version() != 3 {
+		t.Errorf("expected version 3, got %d", client.Version())
+	}
+
+	cluster, err := FindCluster()
+	if err != nil || cluster != fakeTask.Cluster {
+		t.Errorf("FindCluster(): expected %s, got %q error: %v", fakeTask.Cluster, cluster, err)
+	}
+}
+
+func TestMetadataClientUnavailable(t *testing.T) {
+	t.Setenv(envVarMetadataURI, "")
+	t.Setenv(envVarMetadataURIV3, "")
+
+	if _, err := NewMetadataClient(MetadataClientOptions{}); err == nil {
+		t.Fatal("expected error without metadata endpoint")
+	}
+}
+
+func TestMetadataClientRetry(t *testing.T) {
+	server := discoverytest.NewMetadataServer(fakeTask)
+	defer server.Close()
+
+	client, err := NewMetadataClient(MetadataClientOptions{
+		BaseURL:       server.URL,
+		RetryInterval: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewMetadataClient() error: %v", err)
+	}
+
+	// transient errors are retried
+	server.FailNext(2, http.StatusServiceUnavailable)
+	if _, err := client.Task(context.Background()); err != nil {
+		t.Fatalf("Task() error after retries: %v", err)
+	}
+	if got := server.Requests("/task"); got != 3 {
+		t.Errorf("expected 3 requests, got %d", got)
+	}
+
+	// retries are exhausted
+	server.FailNext(10, http.StatusInternalServerError)
+	if _, err := client.Task(context.Background()); err == nil {
+		t.Fatal("expected error after exhausting retries")
+	}
+	if got := server.Requests("/task"); got != 7 {
+		t.Errorf("expected 4 more requests, got %d", got-3)
+	}
+
+	// client errors are not retried
+	server.FailNext(1, http.StatusNotFound)
+	if _, err := client.Task(context.Background()); err == nil {
+		t.Fatal("expected error for not found")
+	}
+	if got := server.Requests("/task"); got != 8 {
+		t.Errorf("expected a single request, got %d", got-7)
+	}
+}
+
+func TestMetadataClientRetryCanceled(t *testing.T) {
+	server := discoverytest.NewMetadataServer(fakeTask)
+	defer server.Close()
+
+	client, _ := NewMetadataClient(MetadataClientOptions{
+		BaseURL:       server.URL,
+		RetryInterval: time.Hour,
+	})
+
+	server.FailNext(1, http.StatusServiceUnavailable)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := client.Task(ctx); err == nil {
+		t.Fatal("expected error on canceled context")
+	}
+}
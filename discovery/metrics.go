@@ -0,0 +1,304 @@
+package discovery
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsSink receives instrumentation from discovery.
+// Implementations must be safe for concurrent use, since a sink may be
+// shared by many Discovery and TaskFinder instances.
+// PrometheusSink is the default implementation.
+type MetricsSink interface {
+	// PollDone reports a poll duration and whether it found tasks without error.
+	PollDone(service string, elapsed time.Duration, success bool)
+
+	// SnapshotChanged reports delivery of a changed task list.
+	SnapshotChanged(service string, tasks int)
+
+	// TasksFiltered reports how many tasks the last poll excluded by health.
+	TasksFiltered(service string, count int)
+
+	// ECSRequest reports an ECS API call attempt, including retries.
+	ECSRequest(service, operation string, err error)
+
+	// ECSThrottled reports an ECS API call attempt rejected by throttling.
+	ECSThrottled(service, operation string)
+
+	// AgentRequest reports an agent request.
+	AgentRequest(service, endpoint string, success bool)
+
+	// AgentCircuit reports a circuit breaker state change of an agent
+	// endpoint: opened, closed, or cooldown expired (half-open, reported as closed).
+	AgentCircuit(service, endpoint string, open bool)
+
+	// AgentSkipped reports that every agent endpoint circuit was open.
+	AgentSkipped(service string)
+
+	// AgentFallback reports that a poll fell back from the agent to ECS API.
+	AgentFallback(service string)
+
+	// DescribeFailure reports a task that DescribeTasks failed to describe.
+	DescribeFailure(service, reason string)
+
+	// ShrinkBlocked reports a poll blocked by shrink protection.
+	ShrinkBlocked(service string)
+}
+
+// PrometheusSink is a MetricsSink exporting Prometheus metrics.
+// Metrics are labeled by service.
+type PrometheusSink struct {
+	pollDuration     *prometheus.HistogramVec
+	lastSuccess      *prometheus.GaugeVec
+	snapshotChanges  *prometheus.CounterVec
+	tasks            *prometheus.GaugeVec
+	tasksFiltered    *prometheus.GaugeVec
+	ecsRequests      *prometheus.CounterVec
+	ecsThrottle      *prometheus.CounterVec
+	agentCircuitOpen *prometheus.GaugeVec
+	agentRequests    *prometheus.CounterVec
+	agentFallbacks   *prometheus.CounterVec
+	describeFailure  *prometheus.CounterVec
+	shrinkBlock      *prometheus.CounterVec
+}
+
+// NewPrometheusSink creates a PrometheusSink registering metrics in registerer.
+// Sinks created with the same registerer share the same collectors.
+func NewPrometheusSink(namespace string, registerer prometheus.Registerer) *PrometheusSink {
+	counter := func(name, help string, labels ...string) *prometheus.CounterVec {
+		return registerCollector(registerer, prometheus.NewCounterVec(
+			prometheus.CounterOpts{Namespace: namespace, Name: name, Help: help}, labels))
+	}
+	gauge := func(name, help string, labels ...string) *prometheus.GaugeVec {
+		return registerCollector(registerer, prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{Namespace: namespace, Name: name, Help: help}, labels))
+	}
+
+	return &PrometheusSink{
+		pollDuration: registerCollector(registerer, prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Name:      "poll_duration_seconds",
+				Help:      "Duration of discovery polls.",
+				Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+			},
+			[]string{"service"},
+		)),
+		lastSuccess: gauge("last_success_timestamp_seconds",
+			"Unix time of last successful poll. Time since last success is time() minus this value.",
+			"service"),
+		snapshotChanges: counter("snapshot_changes_total",
+			"Number of changed task lists delivered.",
+			"service"),
+		tasks: gauge("tasks",
+			"Number of tasks in last delivered task list.",
+			"service"),
+		tasksFiltered: gauge("tasks_filtered",
+			"Number of tasks excluded by health in last poll.",
+			"service"),
+		ecsRequests: counter("ecs_requests_total",
+			"Number of ECS API call attempts by operation and result: success or error.",
+			"service", "operation", "result"),
+		ecsThrottle: counter("ecs_throttles_total",
+			"Number of ECS API calls that were throttled, by operation.",
+			"service", "operation"),
+		agentCircuitOpen: gauge("agent_circuit_open",
+			"Agent endpoint circuit breaker state: 1 for open, 0 for closed.",
+			"service", "url"),
+		agentRequests: counter("agent_requests_total",
+			"Number of agent requests by result: success, failure or skipped (all circuits open).",
+			"service", "url", "result"),
+		agentFallbacks: counter("agent_fallbacks_total",
+			"Number of polls that fell back from agent to ECS API.",
+			"service"),
+		describeFailure: counter("describe_failures_total",
+			"Number of tasks that DescribeTasks failed to describe, by reason.",
+			"service", "reason"),
+		shrinkBlock: counter("shrink_blocked_total",
+			"Number of polls whose task list was not delivered because it removed too many tasks.",
+			"service"),
+	}
+}
+
+// registerCollector registers c, or returns the collector previously
+// registered by another Discovery instance.
+func registerCollector[C prometheus.Collector](registerer prometheus.Registerer, c C) C {
+	if err := registerer.Register(c); err != nil {
+		var already prometheus.AlreadyRegisteredError
+		if errors.As(err, &already) {
+			if existing, ok := already.ExistingCollector.(C); ok {
+				return existing
+			}
+		}
+		panic(err)
+	}
+	return c
+}
+
+// PollDone implements MetricsSink.
+func (s *PrometheusSink) PollDone(service string, elapsed time.Duration, success bool) {
+	s.pollDuration.WithLabelValues(service).Observe(elapsed.Seconds())
+	if success {
+		s.lastSuccess.WithLabelValues(service).SetToCurrentTime()
+	}
+}
+
+// SnapshotChanged implements MetricsSink.
+func (s *PrometheusSink) SnapshotChanged(service string, tasks int) {
+	s.snapshotChanges.WithLabelValues(service).Inc()
+	s.tasks.WithLabelValues(service).Set(float64(tasks))
+}
+
+// TasksFiltered implements MetricsSink.
+func (s *PrometheusSink) TasksFiltered(service string, count int) {
+	s.tasksFiltered.WithLabelValues(service).Set(float64(count))
+}
+
+// ECSRequest implements MetricsSink.
+func (s *PrometheusSink) ECSRequest(service, operation string, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	s.ecsRequests.WithLabelValues(service, operation, result).Inc()
+}
+
+// ECSThrottled implements MetricsSink.
+func (s *PrometheusSink) ECSThrottled(service, operation string) {
+	s.ecsThrottle.WithLabelValues(service, operation).Inc()
+}
+
+// AgentRequest implements MetricsSink.
+func (s *PrometheusSink) AgentRequest(service, endpoint string, success bool) {
+	result := "failure"
+	if success {
+		result = "success"
+	}
+	s.agentRequests.WithLabelValues(service, endpoint, result).Inc()
+}
+
+// AgentCircuit implements MetricsSink.
+func (s *PrometheusSink) AgentCircuit(service, endpoint string, open bool) {
+	var value float64
+	if open {
+		value = 1
+	}
+	s.agentCircuitOpen.WithLabelValues(service, endpoint).Set(value)
+}
+
+// AgentSkipped implements MetricsSink.
+func (s *PrometheusSink) AgentSkipped(service string) {
+	s.agentRequests.WithLabelValues(service, "", "skipped").Inc()
+}
+
+// AgentFallback implements MetricsSink.
+func (s *PrometheusSink) AgentFallback(service string) {
+	s.agentFallbacks.WithLabelValues(service).Inc()
+}
+
+// DescribeFailure implements MetricsSink.
+func (s *PrometheusSink) DescribeFailure(service, reason string) {
+	s.describeFailure.WithLabelValues(service, reason).Inc()
+}
+
+// ShrinkBlocked implements MetricsSink.
+func (s *PrometheusSink) ShrinkBlocked(service string) {
+	s.shrinkBlock.WithLabelValues(service).Inc()
+}
+
+// metrics forwards instrumentation to an optional sink.
+// A nil *metrics, or one without sink, discards everything.
+type metrics struct {
+	sink MetricsSink
+}
+
+// newMetrics selects sink, or a PrometheusSink for registerer.
+// Without both, metrics are disabled.
+func newMetrics(sink MetricsSink, namespace string, registerer prometheus.Registerer) *metrics {
+	if sink == nil && registerer != nil {
+		sink = NewPrometheusSink(namespace, registerer)
+	}
+	return &metrics{sink: sink}
+}
+
+// target returns the sink, or nil if metrics are disabled.
+func (m *metrics) target() MetricsSink {
+	if m == nil {
+		return nil
+	}
+	return m.sink
+}
+
+func (m *metrics) enabled() bool {
+	return m != nil && m.sink != nil
+}
+
+func (m *metrics) pollDone(service string, elapsed time.Duration, success bool) {
+	if m.enabled() {
+		m.sink.PollDone(service, elapsed, success)
+	}
+}
+
+func (m *metrics) snapshotChanged(service string, tasks int) {
+	if m.enabled() {
+		m.sink.SnapshotChanged(service, tasks)
+	}
+}
+
+func (m *metrics) tasksFiltered(service string, count int) {
+	if m.enabled() {
+		m.sink.TasksFiltered(service, count)
+	}
+}
+
+func (m *metrics) ecsRequest(service, operation string, err error) {
+	if m.enabled() {
+		m.sink.ECSRequest(service, operation, err)
+	}
+}
+
+func (m *metrics) ecsThrottled(service, operation string) {
+	if m.enabled() {
+		m.sink.ECSThrottled(service, operation)
+	}
+}
+
+func (m *metrics) agentResult(service, endpoint string, success bool) {
+	if m.enabled() {
+		m.sink.AgentRequest(service, endpoint, success)
+	}
+}
+
+func (m *metrics) agentCircuit(service, endpoint string, open bool) {
+	if m.enabled() {
+		m.sink.AgentCircuit(service, endpoint, open)
+	}
+}
+
+func (m *metrics) agentSkipped(service string) {
+	if m.enabled() {
+		m.sink.AgentSkipped(service)
+	}
+}
+
+func (m *metrics) agentFallback(service string) {
+	if m.enabled() {
+		m.sink.AgentFallback(service)
+	}
+}
+
+func (m *metrics) describeFailures(service string, failures []TaskFailure) {
+	if m.enabled() {
+		for _, f := range failures {
+			m.sink.DescribeFailure(service, f.Reason)
+		}
+	}
+}
+
+func (m *metrics) shrinkBlocked(service string) {
+	if m.enabled() {
+		m.sink.ShrinkBlocked(service)
+	}
+}
@@ -0,0 +1,216 @@
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// prometheusSink returns the PrometheusSink behind m.
+func prometheusSink(t *testing.T, m *metrics) *PrometheusSink {
+	t.Helper()
+	s, ok := m.target().(*PrometheusSink)
+	if !ok {
+		t.Fatalf("expected *PrometheusSink, got %T", m.target())
+	}
+	return s
+}
+
+// recordingSink counts events reported to MetricsSink.
+type recordingSink struct {
+	mu          sync.Mutex
+	polls       int
+	successes   int
+	changes     int
+	filtered    int
+	ecsRequests map[string]int // by operation
+	ecsErrors   map[string]int // by operation
+	agentOK     int
+	agentFailed int
+	fallbacks   int
+}
+
+func newRecordingSink() *recordingSink {
+	return &recordingSink{ecsRequests: map[string]int{}, ecsErrors: map[string]int{}}
+}
+
+func (s *recordingSink) PollDone(_ string, _ time.Duration, success bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.polls++
+	if success {
+		s.successes++
+	}
+}
+
+func (s *recordingSink) SnapshotChanged(_ string, _ int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changes++
+}
+
+func (s *recordingSink) TasksFiltered(_ string, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filtered = count
+}
+
+func (s *recordingSink) ECSRequest(_, operation string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ecsRequests[operation]++
+	if err != nil {
+		s.ecsErrors[operation]++
+	}
+}
+
+func (s *recordingSink) ECSThrottled(_, _ string) {}
+
+func (s *recordingSink) AgentRequest(_, _ string, success bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if success {
+		s.agentOK++
+	} else {
+		s.agentFailed++
+	}
+}
+
+func (s *recordingSink) AgentCircuit(_, _ string, _ bool) {}
+
+func (s *recordingSink) AgentSkipped(_ string) {}
+
+func (s *recordingSink) AgentFallback(_ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fallbacks++
+}
+
+func (s *recordingSink) DescribeFailure(_, _ string) {}
+
+func (s *recordingSink) ShrinkBlocked(_ string) {}
+
+func TestMetricsSinkAgentFallback(t *testing.T) {
+	sink := newRecordingSink()
+
+	d := &Discovery{
+		options: Options{
+			ServiceName: "svc",
+			Client: ecs.NewFromConfig(aws.Config{
+				Region:     "us-east-1",
+				HTTPClient: &http.Client{Transport: &mockECSTransport{}},
+			}),
+		},
+		clusterName:        "demo",
+		healthCheckEnabled: true,
+		httpClient:         &http.Client{Transport: &agentErrorTransport{}},
+		metrics:            newMetrics(sink, "", nil),
+	}
+
+	if _, err := d.listTasks(context.Background()); err != nil {
+		t.Fatalf("listTasks() unexpected error: %v", err)
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+
+	if sink.agentFailed == 0 || sink.agentOK != 0 {
+		t.Errorf("expected agent failures only, got ok=%d failed=%d", sink.agentOK, sink.agentFailed)
+	}
+	if sink.fallbacks != 1 {
+		t.Errorf("expected 1 fallback, got %d", sink.fallbacks)
+	}
+	for _, op := range []string{"ListTasks", "DescribeTasks"} {
+		if sink.ecsRequests[op] != 1 || sink.ecsErrors[op] != 0 {
+			t.Errorf("%s: expected 1 successful request, got requests=%d errors=%d",
+				op, sink.ecsRequests[op], sink.ecsErrors[op])
+		}
+	}
+	if sink.filtered != 0 {
+		t.Errorf("expected no task filtered, got %d", sink.filtered)
+	}
+}
+
+func TestMetricsSinkPolls(t *testing.T) {
+	t.Setenv(envVarMetadataURI, "") // no ECS metadata available
+	t.Setenv(envVarMetadataURIV3, "")
+
+	sink := newRecordingSink()
+	ch := make(chan []Task, 10)
+
+	d, err := New(Options{
+		ServiceName: "svc",
+		Interval:    10 * time.Millisecond,
+		TaskSource:  &partialSource{},
+		Callback:    func(tasks []Task) { ch <- tasks },
+		MetricsSink: sink,
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	d.Stop()
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+
+	if sink.polls < 2 {
+		t.Fatalf("expected at least 2 polls, got %d", sink.polls)
+	}
+	if sink.successes != 1 {
+		t.Errorf("expected only first poll to succeed, got %d successes", sink.successes)
+	}
+	if sink.changes != len(ch) {
+		t.Errorf("expected %d snapshot changes, got %d", len(ch), sink.changes)
+	}
+}
+
+func TestPrometheusSink(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	s := NewPrometheusSink("test", registry)
+
+	s.PollDone("svc", 100*time.Millisecond, true)
+	s.PollDone("svc", 200*time.Millisecond, false)
+	s.SnapshotChanged("svc", 3)
+	s.TasksFiltered("svc", 2)
+	s.ECSRequest("svc", "ListTasks", nil)
+	s.ECSRequest("svc", "ListTasks", context.DeadlineExceeded)
+	s.AgentFallback("svc")
+
+	if got := testutil.CollectAndCount(s.pollDuration); got != 1 {
+		t.Errorf("expected 1 poll duration series, got %d", got)
+	}
+	if got := testutil.ToFloat64(s.lastSuccess.WithLabelValues("svc")); got < float64(time.Now().Add(-time.Minute).Unix()) {
+		t.Errorf("unexpected last success timestamp: %v", got)
+	}
+	if got := testutil.ToFloat64(s.snapshotChanges.WithLabelValues("svc")); got != 1 {
+		t.Errorf("expected 1 snapshot change, got %v", got)
+	}
+	if got := testutil.ToFloat64(s.tasks.WithLabelValues("svc")); got != 3 {
+		t.Errorf("expected 3 tasks, got %v", got)
+	}
+	if got := testutil.ToFloat64(s.tasksFiltered.WithLabelValues("svc")); got != 2 {
+		t.Errorf("expected 2 filtered tasks, got %v", got)
+	}
+	for _, result := range []string{"success", "error"} {
+		if got := testutil.ToFloat64(s.ecsRequests.WithLabelValues("svc", "ListTasks", result)); got != 1 {
+			t.Errorf("expected 1 %s ListTasks request, got %v", result, got)
+		}
+	}
+	if got := testutil.ToFloat64(s.agentFallbacks.WithLabelValues("svc")); got != 1 {
+		t.Errorf("expected 1 fallback, got %v", got)
+	}
+
+	// A second sink on the same registerer shares collectors.
+	if again := NewPrometheusSink("test", registry); again.snapshotChanges != s.snapshotChanges {
+		t.Error("expected shared collectors")
+	}
+}
@@ -0,0 +1,50 @@
+package discovery
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// HeaderTaskFailures is the agent response header carrying, as a JSON list
+// of TaskFailure, the describe failures of a partial task list.
+const HeaderTaskFailures = "X-Task-Failures"
+
+// TaskFailure describes a task that DescribeTasks could not describe.
+type TaskFailure struct {
+	ARN    string `json:"arn"`
+	Reason string `json:"reason"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// PartialResultError reports that a task list is incomplete because
+// DescribeTasks returned failures. Functions returning it also return
+// the tasks that were described successfully.
+type PartialResultError struct {
+	Failures []TaskFailure
+}
+
+// Error implements error.
+func (e *PartialResultError) Error() string {
+	list := make([]string, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		list = append(list, f.ARN+": "+f.Reason)
+	}
+	return fmt.Sprintf("partial result: %d task(s) failed to describe: %s",
+		len(e.Failures), strings.Join(list, ", "))
+}
+
+// isPartial reports whether err is a *PartialResultError.
+func isPartial(err error) bool {
+	var partial *PartialResultError
+	return errors.As(err, &partial)
+}
+
+// countFailures counts describe failures from a partial result. Failures
+// found by TaskFinder are counted by the finder itself.
+func (d *Discovery) countFailures(err error) {
+	var partial *PartialResultError
+	if errors.As(err, &partial) {
+		d.metrics.describeFailures(d.options.ServiceName, partial.Failures)
+	}
+}
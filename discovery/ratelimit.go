@@ -0,0 +1,137 @@
+package discovery
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/smithy-go/middleware"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RateLimiter is a token bucket limiting ECS API calls.
+// A single RateLimiter may be shared by many Discovery and TaskFinder
+// instances in one process, so that they share one API budget.
+// It also holds an adaptive retryer, shared by the same instances,
+// that slows down retries when ECS API reports throttling.
+// It is safe for concurrent use.
+type RateLimiter struct {
+	retryer aws.RetryerV2
+
+	mu     sync.Mutex
+	rate   float64 // tokens per second, zero means unlimited
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a RateLimiter allowing ratePerSecond ECS API calls,
+// with bursts of up to burst calls. Zero ratePerSecond means unlimited, and
+// only the adaptive retry is applied. Burst defaults to 1 if undefined.
+func NewRateLimiter(ratePerSecond float64, burst int) *RateLimiter {
+	burst = max(burst, 1)
+	return &RateLimiter{
+		retryer: retry.NewAdaptiveMode(),
+		rate:    max(ratePerSecond, 0),
+		burst:   float64(burst),
+		tokens:  float64(burst),
+		last:    time.Now(),
+	}
+}
+
+// SetRate changes the rate. Zero means unlimited.
+func (l *RateLimiter) SetRate(ratePerSecond float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.rate = max(ratePerSecond, 0)
+}
+
+// Rate returns the current rate.
+func (l *RateLimiter) Rate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// refill adds tokens accumulated since last refill. Must be called with mu held.
+func (l *RateLimiter) refill(now time.Time) {
+	if l.rate > 0 {
+		l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+}
+
+// Wait blocks until a call is allowed or ctx is done.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.rate == 0 {
+			l.mu.Unlock()
+			return nil
+		}
+		l.refill(time.Now())
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// isThrottle detects ECS API throttling errors.
+var isThrottle = retry.ThrottleErrorCode{Codes: retry.DefaultThrottleErrorCodes}
+
+// ecsOptions returns per-call options for ECS API calls.
+// Every attempt, including retries, waits on limiter, if any.
+// Attempts and throttled attempts are reported to metrics.
+// Each call, including its retries, is traced by tracer.
+func ecsOptions(limiter *RateLimiter, m *metrics, tracer trace.Tracer, service string) []func(*ecs.Options) {
+	return []func(*ecs.Options){
+		func(o *ecs.Options) {
+			if limiter != nil {
+				o.Retryer = limiter.retryer
+			}
+			o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+				if err := stack.Initialize.Add(traceMiddleware(tracer, service), middleware.Before); err != nil {
+					return err
+				}
+				if err := stack.Deserialize.Add(recordMiddleware(), middleware.After); err != nil {
+					return err
+				}
+				return stack.Finalize.Insert(throttleMiddleware(limiter, m, service), "Retry", middleware.After)
+			})
+		},
+	}
+}
+
+func throttleMiddleware(limiter *RateLimiter, m *metrics, service string) middleware.FinalizeMiddleware {
+	return middleware.FinalizeMiddlewareFunc("ECSTaskDiscoveryThrottle",
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (
+			middleware.FinalizeOutput, middleware.Metadata, error) {
+			if limiter != nil {
+				if err := limiter.Wait(ctx); err != nil {
+					return middleware.FinalizeOutput{}, middleware.Metadata{}, err
+				}
+			}
+			out, metadata, err := next.HandleFinalize(ctx, in)
+			m.ecsRequest(service, middleware.GetOperationName(ctx), err)
+			if err != nil && isThrottle.IsErrorThrottle(err) == aws.TrueTernary {
+				m.ecsThrottled(service, middleware.GetOperationName(ctx))
+			}
+			return out, metadata, err
+		})
+}
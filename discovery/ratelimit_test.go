@@ -0,0 +1,130 @@
+package discovery
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRateLimiterWait(t *testing.T) {
+	limiter := NewRateLimiter(50, 2)
+
+	begin := time.Now()
+	for range 7 {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait() unexpected error: %v", err)
+		}
+	}
+	elapsed := time.Since(begin)
+
+	// burst of 2, then 5 calls at 50/s
+	if elapsed < 80*time.Millisecond {
+		t.Fatalf("expected rate limiting, elapsed=%v", elapsed)
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	limiter := NewRateLimiter(0, 0)
+
+	begin := time.Now()
+	for range 1000 {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait() unexpected error: %v", err)
+		}
+	}
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Fatalf("unexpected delay for unlimited rate: %v", elapsed)
+	}
+}
+
+func TestRateLimiterWaitCanceled(t *testing.T) {
+	limiter := NewRateLimiter(0.001, 1)
+
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait() unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := limiter.Wait(ctx); err == nil {
+		t.Fatal("expected error from canceled context")
+	}
+}
+
+func TestRateLimiterSetRate(t *testing.T) {
+	limiter := NewRateLimiter(10, 1)
+	limiter.SetRate(2.5)
+	if got := limiter.Rate(); got != 2.5 {
+		t.Fatalf("expected rate 2.5, got %v", got)
+	}
+}
+
+// throttleTransport answers ListTasks with throttling errors.
+type throttleTransport struct {
+	calls atomic.Int64
+}
+
+func (tt *throttleTransport) RoundTrip(_ *http.Request) (*http.Response, error) {
+	tt.calls.Add(1)
+	h := make(http.Header)
+	h.Set("Content-Type", "application/x-amz-json-1.1")
+	return &http.Response{
+		StatusCode: http.StatusBadRequest,
+		Body:       io.NopCloser(strings.NewReader(`{"__type":"ThrottlingException","message":"Rate exceeded"}`)),
+		Header:     h,
+	}, nil
+}
+
+func TestECSOptionsCountsThrottles(t *testing.T) {
+	transport := &throttleTransport{}
+	client := ecs.NewFromConfig(aws.Config{
+		Region:     "us-east-1",
+		HTTPClient: &http.Client{Transport: transport},
+		Retryer:    func() aws.Retryer { return aws.NopRetryer{} },
+	})
+
+	registry := prometheus.NewRegistry()
+	m := newMetrics(nil, "", registry)
+
+	_, err := client.ListTasks(context.Background(), &ecs.ListTasksInput{}, ecsOptions(nil, m, newTracer(nil), "svc")...)
+	if err == nil {
+		t.Fatal("expected throttling error")
+	}
+
+	if got := testutil.ToFloat64(prometheusSink(t, m).ecsThrottle.WithLabelValues("svc", "ListTasks")); got != 1 {
+		t.Fatalf("expected 1 throttle counted, got %v", got)
+	}
+}
+
+func TestECSOptionsWaitsOnLimiter(t *testing.T) {
+	transport := newPagedECSTransport(500, 100)
+
+	finder := NewTaskFinder(TaskFinderOptions{
+		Client:              newPagedClient(transport),
+		Cluster:             "demo",
+		ServiceName:         "svc",
+		DescribeConcurrency: 4,
+		RateLimiter:         NewRateLimiter(100, 1),
+	})
+
+	begin := time.Now()
+	if _, err := finder.Tasks(context.Background()); err != nil {
+		t.Fatalf("Tasks() unexpected error: %v", err)
+	}
+	elapsed := time.Since(begin)
+
+	// 5 ListTasks + 5 DescribeTasks = 10 calls at 100/s with burst 1
+	if elapsed < 80*time.Millisecond {
+		t.Fatalf("expected calls paced by limiter, elapsed=%v", elapsed)
+	}
+}
@@ -0,0 +1,227 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+const (
+	envRecordFile = "ECS_TASK_DISCOVERY_RECORD_FILE"
+
+	defaultRecordMaxBytes = 10 << 20 // 10 MiB
+	defaultRecordMaxFiles = 3
+)
+
+// PollRecord is one line of a recording: a poll's raw inputs and resulting snapshot.
+type PollRecord struct {
+	Time     time.Time       `json:"time"`
+	Cluster  string          `json:"cluster,omitempty"`
+	Service  string          `json:"service"`
+	Source   string          `json:"source,omitempty"` // task_source, agent, force_single_task or ecs
+	Calls    []RecordedCall  `json:"calls,omitempty"`  // raw ECS API and agent responses
+	Listed   []Task          `json:"listed"`           // tasks from the source, before health filtering
+	Failures []TaskFailure   `json:"failures,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Health   *RecordedHealth `json:"health,omitempty"` // health check resolution used for filtering
+	Tasks    []Task          `json:"tasks"`            // tasks after health filtering
+	Changed  bool            `json:"changed"`          // tasks were delivered to Callback
+	Snapshot []Task          `json:"snapshot"`         // last delivered tasks, after this poll
+}
+
+// RecordedHealth is the health check resolution in effect during a poll,
+// so that a replay filters tasks as the recorded poll did.
+type RecordedHealth struct {
+	Enabled     bool            `json:"enabled"`                // service-level resolution
+	Container   string          `json:"container,omitempty"`    // HealthContainer
+	PerRevision bool            `json:"per_revision,omitempty"` // mode Revision
+	Revisions   map[string]bool `json:"revisions,omitempty"`    // task definition ARN => has health check
+}
+
+// RecordedCall is a raw response received during a poll.
+type RecordedCall struct {
+	Operation string          `json:"operation"` // ECS API operation, or "agent"
+	URL       string          `json:"url,omitempty"`
+	Status    int             `json:"status,omitempty"`
+	Response  json.RawMessage `json:"response,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// rawJSON keeps a JSON body as is, or encodes any other body as a JSON string.
+func rawJSON(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	if json.Valid(body) {
+		return json.RawMessage(bytes.TrimSpace(body))
+	}
+	data, _ := json.Marshal(string(body))
+	return data
+}
+
+// pollRecord collects a PollRecord during a poll. Calls may be
+// recorded concurrently, for instance by parallel DescribeTasks.
+type pollRecord struct {
+	mu     sync.Mutex
+	record PollRecord
+}
+
+type pollRecordKey struct{}
+
+func withPollRecord(ctx context.Context, p *pollRecord) context.Context {
+	return context.WithValue(ctx, pollRecordKey{}, p)
+}
+
+func pollRecordFrom(ctx context.Context) *pollRecord {
+	p, _ := ctx.Value(pollRecordKey{}).(*pollRecord)
+	return p
+}
+
+// recordCall adds a raw response to the poll being recorded, if any.
+func recordCall(ctx context.Context, call RecordedCall) {
+	p := pollRecordFrom(ctx)
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.record.Calls = append(p.record.Calls, call)
+}
+
+// recordListed saves tasks from the source, before health filtering, for the poll being recorded, if any.
+func recordListed(ctx context.Context, source string, tasks []Task) {
+	p := pollRecordFrom(ctx)
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.record.Source = source
+	p.record.Listed = tasks
+}
+
+// recordMiddleware records raw ECS API responses for the poll being recorded.
+// It sits right before the transport, so it sees every retry attempt.
+func recordMiddleware() middleware.DeserializeMiddleware {
+	return middleware.DeserializeMiddlewareFunc("ECSTaskDiscoveryRecord",
+		func(ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (
+			middleware.DeserializeOutput, middleware.Metadata, error) {
+			out, metadata, err := next.HandleDeserialize(ctx, in)
+			if pollRecordFrom(ctx) == nil {
+				return out, metadata, err
+			}
+			call := RecordedCall{Operation: middleware.GetOperationName(ctx)}
+			if err != nil {
+				call.Error = err.Error()
+			}
+			if resp, ok := out.RawResponse.(*smithyhttp.Response); ok && resp.Body != nil {
+				body, errBody := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				resp.Body = io.NopCloser(bytes.NewReader(body))
+				call.Status = resp.StatusCode
+				call.Response = rawJSON(body)
+				if errBody != nil {
+					call.Error = errBody.Error()
+				}
+			}
+			recordCall(ctx, call)
+			return out, metadata, err
+		})
+}
+
+// recorder writes poll records to a JSONL file, rotating it by size.
+// It is only accessed by the run goroutine. A nil recorder records nothing.
+type recorder struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// newRecorder opens path for appending. It returns nil when path is empty.
+func newRecorder(path string, maxBytes int64, maxFiles int) (*recorder, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultRecordMaxBytes
+	}
+	if maxFiles <= 0 {
+		maxFiles = defaultRecordMaxFiles
+	}
+	r := &recorder{path: path, maxBytes: maxBytes, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *recorder) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("record file: %w", err)
+	}
+	info, errStat := f.Stat()
+	if errStat != nil {
+		f.Close()
+		return fmt.Errorf("record file: %w", errStat)
+	}
+	r.file = f
+	r.size = info.Size()
+	return nil
+}
+
+// write appends record as a JSON line, rotating the file first if it would exceed maxBytes.
+func (r *recorder) write(record PollRecord) error {
+	if r == nil {
+		return nil
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if r.size > 0 && r.size+int64(len(data)) > r.maxBytes {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := r.file.Write(data)
+	r.size += int64(n)
+	return err
+}
+
+// rotate renames path to path.1, path.1 to path.2, and so on,
+// dropping files beyond maxFiles, then reopens path.
+func (r *recorder) rotate() error {
+	r.file.Close()
+	os.Remove(r.path + "." + strconv.Itoa(r.maxFiles))
+	for i := r.maxFiles - 1; i >= 1; i-- {
+		os.Rename(r.path+"."+strconv.Itoa(i), r.path+"."+strconv.Itoa(i+1))
+	}
+	errRename := os.Rename(r.path, r.path+".1")
+	if err := r.open(); err != nil {
+		return err
+	}
+	if errRename != nil {
+		return fmt.Errorf("record file rotate: %w", errRename)
+	}
+	return nil
+}
+
+func (r *recorder) close() {
+	if r == nil {
+		return
+	}
+	r.file.Close()
+}
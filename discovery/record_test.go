@@ -0,0 +1,325 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/udhos/ecs-task-discovery/discovery/discoverytest"
+)
+
+func TestRecorderRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "record.jsonl")
+
+	r, err := newRecorder(path, 200, 2)
+	if err != nil {
+		t.Fatalf("newRecorder: %v", err)
+	}
+	for i := range 10 {
+		if err := r.write(PollRecord{Service: fmt.Sprint(i)}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	r.close()
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected at most 2 rotated files, stat error: %v", err)
+	}
+
+	records, err := ReadRecording(path+".2", path+".1", path)
+	if err != nil {
+		t.Fatalf("ReadRecording: %v", err)
+	}
+	if len(records) == 0 || len(records) == 10 {
+		t.Fatalf("expected oldest records dropped by rotation, got %d", len(records))
+	}
+	for i, r := range records {
+		if want := fmt.Sprint(10 - len(records) + i); r.Service != want {
+			t.Errorf("record %d: expected service %s, got %s", i, want, r.Service)
+		}
+	}
+}
+
+func TestNewRecorderDisabled(t *testing.T) {
+	r, err := newRecorder("", 0, 0)
+	if err != nil || r != nil {
+		t.Fatalf("expected no recorder for empty path, got %v %v", r, err)
+	}
+	// nil recorder is safe to use
+	if err := r.write(PollRecord{}); err != nil {
+		t.Errorf("nil recorder write: %v", err)
+	}
+	r.close()
+}
+
+func TestRecordReplay(t *testing.T) {
+	fake := discoverytest.NewECSServer()
+	defer fake.Close()
+
+	taskDef := discoverytest.TaskDefinitionARN("demo", 1)
+	fake.PutTaskDefinition(discoverytest.ECSTaskDefinition{
+		ARN:        taskDef,
+		Containers: []discoverytest.ECSContainerDefinition{{Name: "app", HealthCheck: true}},
+	})
+	fake.PutService(discoverytest.ECSService{Cluster: "demo", Name: "svc", TaskDefinitionARN: taskDef})
+	for i := range 3 {
+		fake.PutTask(discoverytest.ECSTask{
+			ARN:               discoverytest.TaskARN("demo", fmt.Sprint(i)),
+			Cluster:           "demo",
+			Service:           "svc",
+			TaskDefinitionARN: taskDef,
+			Address:           fmt.Sprintf("10.0.0.%d", i+1),
+			HealthStatus:      "HEALTHY",
+		})
+	}
+
+	self, _ := fake.Metadata(discoverytest.TaskARN("demo", "0"))
+	metadata := discoverytest.NewMetadataServer(self)
+	defer metadata.Close()
+	metadata.Setenv(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "record.jsonl")
+
+	ch := make(chan []Task, 10)
+
+	d, err := New(Options{
+		ServiceName:       "svc",
+		Client:            fake.Client(),
+		DisableAgentQuery: true,
+		Interval:          10 * time.Millisecond,
+		Callback:          func(tasks []Task) { ch <- tasks },
+		RecordFile:        path,
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer d.Stop()
+
+	next := func() []Task {
+		t.Helper()
+		select {
+		case tasks := <-ch:
+			return tasks
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for callback")
+			return nil
+		}
+	}
+
+	if tasks := next(); len(tasks) != 3 {
+		t.Fatalf("expected 3 tasks, got %+v", tasks)
+	}
+	fake.SetTaskHealth(discoverytest.TaskARN("demo", "2"), "UNHEALTHY")
+	if tasks := next(); len(tasks) != 2 {
+		t.Fatalf("expected unhealthy task excluded, got %+v", tasks)
+	}
+
+	// the record is written after the callback: wait for it
+	var records []PollRecord
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		records, _ = ReadRecording(path) // may catch a partial line
+		if n := len(records); n > 0 && records[n-1].Changed && len(records[n-1].Snapshot) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for record, got %d records", len(records))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	d.Stop()
+
+	first := records[0]
+	if first.Source != "ecs" || first.Service != "svc" || !first.Changed || len(first.Listed) != 3 {
+		t.Errorf("unexpected first record: %+v", first)
+	}
+	if first.Health == nil || !first.Health.Enabled {
+		t.Errorf("expected enabled health check recorded, got %+v", first.Health)
+	}
+	var ops []string
+	for _, c := range first.Calls {
+		if c.Status != 200 || len(c.Response) == 0 {
+			t.Errorf("expected raw response for call: %+v", c)
+		}
+		ops = append(ops, c.Operation)
+	}
+	if !slices.Contains(ops, "ListTasks") || !slices.Contains(ops, "DescribeTasks") {
+		t.Errorf("expected ListTasks and DescribeTasks calls recorded, got %v", ops)
+	}
+
+	// replay a stable copy of the recording
+	replayPath := filepath.Join(dir, "replay.jsonl")
+	r, err := newRecorder(replayPath, 0, 0)
+	if err != nil {
+		t.Fatalf("newRecorder: %v", err)
+	}
+	var want [][]Task
+	for _, record := range records {
+		if err := r.write(record); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if record.Changed {
+			want = append(want, record.Snapshot)
+		}
+	}
+	r.close()
+
+	replay, err := NewReplaySource(ReplaySourceOptions{Paths: []string{replayPath}})
+	if err != nil {
+		t.Fatalf("NewReplaySource: %v", err)
+	}
+
+	replayed := make(chan []Task, 10)
+
+	dr, err := New(Options{
+		ServiceName: "svc",
+		TaskSource:  replay, // health checks resolved from recording
+		Interval:    time.Millisecond,
+		Callback: func(tasks []Task) {
+			tasks = slices.Clone(tasks)
+			for i := range tasks {
+				tasks[i].IsSelf = false // not recorded
+			}
+			replayed <- tasks
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() replay error: %v", err)
+	}
+	defer dr.Stop()
+
+	for i, w := range want {
+		select {
+		case got := <-replayed:
+			if !slices.EqualFunc(got, w, Task.Equal) {
+				t.Errorf("replayed snapshot %d: expected %+v, got %+v", i, w, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for replayed snapshot %d", i)
+		}
+	}
+}
+
+func TestReplayAppliesRecordedHealth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "record.jsonl")
+	r, err := newRecorder(path, 0, 0)
+	if err != nil {
+		t.Fatalf("newRecorder: %v", err)
+	}
+	unhealthyApp := NewContainerHealth(Container{Name: "app", HealthStatus: "UNHEALTHY"})
+	healthyApp := NewContainerHealth(Container{Name: "app", HealthStatus: "HEALTHY"})
+	for _, record := range []PollRecord{
+		{
+			Service: "svc",
+			Health:  &RecordedHealth{PerRevision: true, Revisions: map[string]bool{"td:1": true, "td:2": false}},
+			Listed: []Task{
+				{ARN: "a", Address: "10.0.0.1", TaskDefinitionARN: "td:1", HealthStatus: "UNHEALTHY"},
+				{ARN: "b", Address: "10.0.0.2", TaskDefinitionARN: "td:2", HealthStatus: "UNHEALTHY"},
+				{ARN: "c", Address: "10.0.0.3", TaskDefinitionARN: "td:1", HealthStatus: "HEALTHY"},
+			},
+		},
+		{
+			Service: "svc",
+			Health:  &RecordedHealth{Enabled: true, Container: "app"},
+			Listed: []Task{
+				{ARN: "a", Address: "10.0.0.1", HealthStatus: "HEALTHY", Containers: unhealthyApp},
+				{ARN: "c", Address: "10.0.0.3", HealthStatus: "HEALTHY", Containers: healthyApp},
+			},
+		},
+		{
+			Service: "svc", // recorded without health: keeps previous resolution
+			Listed:  []Task{{ARN: "a", Address: "10.0.0.1", HealthStatus: "HEALTHY", Containers: unhealthyApp}},
+		},
+	} {
+		if err := r.write(record); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	r.close()
+
+	replay, err := NewReplaySource(ReplaySourceOptions{Paths: []string{path}})
+	if err != nil {
+		t.Fatalf("NewReplaySource: %v", err)
+	}
+
+	// replay without ECS client: health checks would resolve to skipped/false
+	d := &Discovery{options: Options{ServiceName: "svc", TaskSource: replay}}
+
+	for i, expected := range [][]string{{"b", "c"}, {"c"}, nil} {
+		tasks, err := d.listTasks(context.Background())
+		if err != nil {
+			t.Fatalf("poll %d: listTasks error: %v", i+1, err)
+		}
+		var got []string
+		for _, task := range tasks {
+			got = append(got, task.ARN)
+		}
+		if !slices.Equal(got, expected) {
+			t.Errorf("poll %d: expected %v, got %v", i+1, expected, got)
+		}
+	}
+}
+
+func TestReplaySource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "record.jsonl")
+	r, err := newRecorder(path, 0, 0)
+	if err != nil {
+		t.Fatalf("newRecorder: %v", err)
+	}
+	for _, record := range []PollRecord{
+		{Service: "svc", Listed: []Task{{ARN: "a", Address: "10.0.0.1"}}},
+		{Service: "other", Listed: []Task{{ARN: "x", Address: "10.0.1.1"}}},
+		{Service: "svc", Listed: []Task{{ARN: "a", Address: "10.0.0.1"}},
+			Failures: []TaskFailure{{ARN: "b", Reason: "MISSING"}}, Error: "partial result"},
+		{Service: "svc", Error: "throttled"},
+	} {
+		if err := r.write(record); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	r.close()
+
+	ctx := context.Background()
+
+	for _, loop := range []bool{false, true} {
+		s, err := NewReplaySource(ReplaySourceOptions{Paths: []string{path}, Loop: loop})
+		if err != nil {
+			t.Fatalf("NewReplaySource: %v", err)
+		}
+
+		if tasks, err := s.Tasks(ctx, "svc"); err != nil || len(tasks) != 1 {
+			t.Errorf("poll 1: expected 1 task, got %+v %v", tasks, err)
+		}
+		tasks, err := s.Tasks(ctx, "svc")
+		if partial, ok := err.(*PartialResultError); !ok || len(partial.Failures) != 1 || len(tasks) != 1 {
+			t.Errorf("poll 2: expected partial result, got %+v %v", tasks, err)
+		}
+		if tasks, err := s.Tasks(ctx, "svc"); err == nil || err.Error() != "throttled" || len(tasks) != 0 {
+			t.Errorf("poll 3: expected recorded error, got %+v %v", tasks, err)
+		}
+		if !s.Done("svc") || s.Done("other") {
+			t.Errorf("expected only svc done")
+		}
+
+		tasks, err = s.Tasks(ctx, "svc")
+		switch {
+		case loop && (err != nil || len(tasks) != 1):
+			t.Errorf("loop: expected recording restarted, got %+v %v", tasks, err)
+		case !loop && (err == nil || err.Error() != "throttled"):
+			t.Errorf("no loop: expected last record repeated, got %+v %v", tasks, err)
+		}
+
+		if _, err := s.Tasks(ctx, "missing"); err == nil {
+			t.Errorf("expected error for service not recorded")
+		}
+	}
+
+	if _, err := NewReplaySource(ReplaySourceOptions{}); err == nil {
+		t.Errorf("expected error for missing paths")
+	}
+}
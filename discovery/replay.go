@@ -0,0 +1,150 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// ReplaySourceOptions define settings for creating a ReplaySource.
+type ReplaySourceOptions struct {
+	// Paths are recording files written by Options.RecordFile, oldest first.
+	// For a rotated recording: RecordFile.2, RecordFile.1, RecordFile.
+	Paths []string
+
+	// Loop restarts the recording when exhausted. By default, the last
+	// recorded poll is repeated forever.
+	Loop bool
+}
+
+// ReplaySource feeds a recording back as a TaskSource, one recorded poll
+// per call to Tasks, so that an incident can be turned into a regression test.
+// Each call returns the tasks listed by the recorded poll, before health
+// filtering, along with its error: a *PartialResultError for recorded
+// describe failures. Discovery applies the health check resolution recorded
+// with each poll, overriding TaskDefinitionHasHealthCheck and HealthContainer.
+// Replay is deterministic, except for health checks depending on wall clock,
+// like UnknownHealthGrace.
+type ReplaySource struct {
+	options ReplaySourceOptions
+
+	mu       sync.Mutex
+	services map[string][]PollRecord
+	next     map[string]int             // next record per service
+	health   map[string]*RecordedHealth // health of the record last returned per service
+}
+
+// NewReplaySource creates a ReplaySource. It fails if any file cannot be loaded.
+func NewReplaySource(options ReplaySourceOptions) (*ReplaySource, error) {
+	if len(options.Paths) == 0 {
+		return nil, fmt.Errorf("replay source: missing paths")
+	}
+
+	records, err := ReadRecording(options.Paths...)
+	if err != nil {
+		return nil, fmt.Errorf("replay source: %w", err)
+	}
+
+	s := &ReplaySource{
+		options:  options,
+		services: map[string][]PollRecord{},
+		next:     map[string]int{},
+		health:   map[string]*RecordedHealth{},
+	}
+	for _, r := range records {
+		s.services[r.Service] = append(s.services[r.Service], r)
+	}
+
+	return s, nil
+}
+
+// Tasks returns the tasks listed by the next recorded poll for service.
+func (s *ReplaySource) Tasks(_ context.Context, serviceName string) ([]Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := s.services[serviceName]
+	if len(records) == 0 {
+		return nil, fmt.Errorf("replay source: service not found: %s", serviceName)
+	}
+
+	i := s.next[serviceName]
+	if i >= len(records) {
+		if s.options.Loop {
+			i = 0
+		} else {
+			i = len(records) - 1
+		}
+	}
+	s.next[serviceName] = i + 1
+
+	r := records[i]
+	s.health[serviceName] = r.Health
+	tasks := append([]Task(nil), r.Listed...)
+
+	switch {
+	case len(r.Failures) > 0:
+		return tasks, &PartialResultError{Failures: r.Failures}
+	case r.Error != "":
+		return tasks, errors.New(r.Error)
+	}
+
+	return tasks, nil
+}
+
+// Health returns the health check resolution recorded with the poll last
+// returned by Tasks for service. It is nil for recordings lacking it.
+func (s *ReplaySource) Health(serviceName string) *RecordedHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.health[serviceName]
+}
+
+// Done reports whether every recorded poll for service has been replayed.
+func (s *ReplaySource) Done(serviceName string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.next[serviceName] >= len(s.services[serviceName])
+}
+
+// ReadRecording reads poll records from recording files, in order.
+func ReadRecording(paths ...string) ([]PollRecord, error) {
+	var records []PollRecord
+	for _, path := range paths {
+		list, err := readRecordFile(path)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, list...)
+	}
+	return records, nil
+}
+
+func readRecordFile(path string) ([]PollRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []PollRecord
+
+	dec := json.NewDecoder(f)
+	for {
+		var r PollRecord
+		err := dec.Decode(&r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: record %d: %w", path, len(records)+1, err)
+		}
+		records = append(records, r)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,123 @@
+package discovery
+
+import (
+	"net"
+	"slices"
+	"strconv"
+)
+
+// SelfIdentity identifies our own task.
+type SelfIdentity struct {
+	TaskARN   string   // our own task ARN
+	Addresses []string // IPv4 and IPv6 addresses of our own task
+}
+
+// FindSelfIdentity finds our own task ARN and addresses by querying container metadata.
+//
+// Env var: ${ECS_CONTAINER_METADATA_URI_V4}/task, falling back to ${ECS_CONTAINER_METADATA_URI}/task
+// Fields: TaskARN, Containers[].Networks[].IPv4Addresses, Containers[].Networks[].IPv6Addresses
+func FindSelfIdentity() (SelfIdentity, error) {
+	metadata, err := findTaskMetadata()
+	if err != nil {
+		return SelfIdentity{}, err
+	}
+	return metadata.identity(), nil
+}
+
+// identity extracts our own task identity from task metadata.
+func (m TaskMetadata) identity() SelfIdentity {
+	id := SelfIdentity{TaskARN: m.TaskARN}
+	for _, c := range m.Containers {
+		for _, n := range c.Networks {
+			for _, addr := range slices.Concat(n.IPv4Addresses, n.IPv6Addresses) {
+				if !slices.Contains(id.Addresses, addr) {
+					id.Addresses = append(id.Addresses, addr)
+				}
+			}
+		}
+	}
+	return id
+}
+
+// isSelf reports whether t is our own task, matching either the task ARN
+// from metadata, one of our addresses from metadata, or SelfAddress.
+func (d *Discovery) isSelf(t Task) bool {
+	if d.selfIdentity.TaskARN != "" && t.ARN == d.selfIdentity.TaskARN {
+		return true
+	}
+	if t.Address == "" {
+		return false
+	}
+	return d.isSelfAddress(t) || slices.Contains(d.selfIdentity.Addresses, t.Address)
+}
+
+// isSelfAddress reports whether t matches SelfAddress. When both
+// SelfAddress and t carry a port, ports must match too.
+func (d *Discovery) isSelfAddress(t Task) bool {
+	if d.options.SelfAddress == "" {
+		return false
+	}
+	host, port := splitSelfAddress(d.options.SelfAddress)
+	return t.Address == host && (port == 0 || t.Port == 0 || t.Port == port)
+}
+
+// splitSelfAddress splits SelfAddress into address and optional port.
+// A bare IPv6 address has no port.
+func splitSelfAddress(addr string) (string, int) {
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, 0
+	}
+	port, errPort := strconv.Atoi(p)
+	if errPort != nil {
+		return addr, 0
+	}
+	return host, port
+}
+
+// markSelf returns a copy of tasks with our own task marked as IsSelf.
+// It records our own task for Self, and warns when it is missing.
+// An empty task list, as from a failed poll, is left alone.
+func (d *Discovery) markSelf(tasks []Task) []Task {
+	known := d.selfIdentity.TaskARN != "" || len(d.selfIdentity.Addresses) > 0 || d.options.SelfAddress != ""
+	if !known || len(tasks) == 0 {
+		return tasks
+	}
+
+	marked := slices.Clone(tasks)
+
+	var self Task
+	var found bool
+	for i := range marked {
+		if d.isSelf(marked[i]) {
+			marked[i].IsSelf = true
+			self, found = marked[i], true
+		}
+	}
+
+	d.selfMu.Lock()
+	d.self, d.selfFound = self, found
+	d.selfMu.Unlock()
+
+	switch {
+	case !found && !d.selfMissing:
+		d.log().Warn("own task missing from discovered tasks", "task", d.selfIdentity.TaskARN,
+			"addresses", d.selfIdentity.Addresses, "self_address", d.options.SelfAddress,
+			"count", len(marked))
+		d.selfMissing = true
+	case found && d.selfMissing:
+		d.log().Info("own task found in discovered tasks", "task", self.ARN, "address", self.Address)
+		d.selfMissing = false
+	}
+
+	return marked
+}
+
+// Self returns our own task as found in the last poll, whatever its health status.
+// It returns false if our own task was missing, or if our identity is unknown:
+// neither task metadata nor SelfAddress are available.
+func (d *Discovery) Self() (Task, bool) {
+	d.selfMu.Lock()
+	defer d.selfMu.Unlock()
+	return d.self, d.selfFound
+}
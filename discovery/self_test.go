@@ -0,0 +1,141 @@
+package discovery
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const selfMetadata = `{
+	"Cluster": "arn:aws:ecs:us-east-1:111122223333:cluster/demo",
+	"TaskARN": "arn:aws:ecs:us-east-1:111122223333:task/demo/self",
+	"Containers": [
+		{"Networks": [{"NetworkMode": "awsvpc", "IPv4Addresses": ["10.0.0.5"], "IPv6Addresses": ["2001:db8::5"]}]},
+		{"Networks": [{"NetworkMode": "awsvpc", "IPv4Addresses": ["10.0.0.5"]}]}
+	]
+}`
+
+func newMetadataServer(t *testing.T, body string) {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintln(w, body)
+	}))
+	t.Cleanup(ts.Close)
+	t.Setenv(envVarMetadataURI, ts.URL)
+}
+
+func TestFindSelfIdentity(t *testing.T) {
+	newMetadataServer(t, selfMetadata)
+
+	id, err := FindSelfIdentity()
+	if err != nil {
+		t.Fatalf("FindSelfIdentity() error: %v", err)
+	}
+
+	if id.TaskARN != "arn:aws:ecs:us-east-1:111122223333:task/demo/self" {
+		t.Errorf("unexpected task ARN: %q", id.TaskARN)
+	}
+	if expected := []string{"10.0.0.5", "2001:db8::5"}; !slices.Equal(id.Addresses, expected) {
+		t.Errorf("addresses: expected=%v got=%v", expected, id.Addresses)
+	}
+}
+
+func TestDiscoverySelf(t *testing.T) {
+	newMetadataServer(t, selfMetadata)
+
+	var buf syncBuffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	self := Task{ARN: "arn:aws:ecs:us-east-1:111122223333:task/demo/self", Address: "10.0.0.5", HealthStatus: "HEALTHY"}
+	other := Task{ARN: "arn:aws:ecs:us-east-1:111122223333:task/demo/other", Address: "10.0.0.6", HealthStatus: "HEALTHY"}
+
+	ch := make(chan []Task, 10)
+
+	d, err := New(Options{
+		ServiceName: "svc",
+		Interval:    10 * time.Millisecond,
+		TaskSource: &sequenceSource{lists: [][]Task{
+			{self, other},
+			{other}, // self missing
+			{self, other},
+		}},
+		Callback: func(tasks []Task) { ch <- tasks },
+		Logger:   logger,
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	var deliveries [][]Task
+	for len(deliveries) < 3 {
+		select {
+		case tasks := <-ch:
+			deliveries = append(deliveries, tasks)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for callback, got %d deliveries", len(deliveries))
+		}
+	}
+	d.Stop()
+
+	first := deliveries[0]
+	if len(first) != 2 || !first[0].IsSelf || first[1].IsSelf {
+		t.Fatalf("expected own task marked as self: %+v", first)
+	}
+	if deliveries[1][0].IsSelf {
+		t.Fatalf("unexpected self mark: %+v", deliveries[1])
+	}
+
+	got, found := d.Self()
+	if !found || got.ARN != self.ARN || !got.IsSelf {
+		t.Fatalf("Self(): expected %s, got %+v found=%t", self.ARN, got, found)
+	}
+
+	logs := buf.String()
+	if strings.Count(logs, "own task missing from discovered tasks") != 1 {
+		t.Errorf("expected a single missing self warning, logs: %s", logs)
+	}
+	if !strings.Contains(logs, "own task found in discovered tasks") {
+		t.Errorf("expected own task found again, logs: %s", logs)
+	}
+}
+
+func TestDiscoverySelfUnknown(t *testing.T) {
+	d := &Discovery{}
+
+	tasks := d.markSelf(makeTasks(2))
+	for _, task := range tasks {
+		if task.IsSelf {
+			t.Fatalf("unexpected self mark without identity: %+v", task)
+		}
+	}
+	if _, found := d.Self(); found {
+		t.Fatal("expected no self without identity")
+	}
+}
+
+func TestIsSelfAddressPort(t *testing.T) {
+	for _, tc := range []struct {
+		self     string
+		task     Task
+		expected bool
+	}{
+		{"10.0.0.5", Task{Address: "10.0.0.5", Port: 5001}, true},
+		{"10.0.0.5:5001", Task{Address: "10.0.0.5", Port: 5001}, true},
+		{"10.0.0.5:5001", Task{Address: "10.0.0.5", Port: 5002}, false},
+		{"10.0.0.5:5001", Task{Address: "10.0.0.5"}, true},
+		{"10.0.0.5:5001", Task{Address: "10.0.0.6", Port: 5001}, false},
+		{"2001:db8::5", Task{Address: "2001:db8::5"}, true},
+		{"[2001:db8::5]:5001", Task{Address: "2001:db8::5", Port: 5001}, true},
+	} {
+		d := &Discovery{options: Options{SelfAddress: tc.self}}
+		if got := d.isSelf(tc.task); got != tc.expected {
+			t.Errorf("self=%s task=%s:%d: expected %t, got %t",
+				tc.self, tc.task.Address, tc.task.Port, tc.expected, got)
+		}
+	}
+}
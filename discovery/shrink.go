@@ -0,0 +1,91 @@
+package discovery
+
+import (
+	"slices"
+	"strings"
+)
+
+// shrinkGuard blocks deliveries that remove too many tasks at once,
+// until the drop is confirmed by consecutive polls.
+// It is only accessed by run goroutine.
+type shrinkGuard struct {
+	maxFraction  float64
+	maxCount     int
+	confirmPolls int
+
+	pending        int    // consecutive polls that have seen the same big shrink
+	pendingRemoved string // endpoints removed by the pending shrink
+}
+
+// defaultShrinkConfirmPolls is used when a shrink limit is set without ShrinkConfirmPolls.
+const defaultShrinkConfirmPolls = 3
+
+func newShrinkGuard(options Options) shrinkGuard {
+	confirm := options.ShrinkConfirmPolls
+	if confirm < 1 {
+		confirm = defaultShrinkConfirmPolls
+	}
+	return shrinkGuard{
+		maxFraction:  options.MaxShrinkFraction,
+		maxCount:     options.MaxShrinkCount,
+		confirmPolls: confirm,
+	}
+}
+
+// removed returns the endpoints (address and port) of prev missing from next, sorted.
+func removed(prev, next []Task) []string {
+	found := make(map[string]struct{}, len(next))
+	for _, t := range next {
+		found[endpoint(t)] = struct{}{}
+	}
+	var list []string
+	for _, t := range prev {
+		if _, ok := found[endpoint(t)]; !ok {
+			list = append(list, endpoint(t))
+		}
+	}
+	slices.Sort(list)
+	return list
+}
+
+// exceeds reports whether removing count of total tasks exceeds the limits.
+func (g *shrinkGuard) exceeds(count, total int) bool {
+	if g.maxCount > 0 && count > g.maxCount {
+		return true
+	}
+	if g.maxFraction > 0 && total > 0 && float64(count)/float64(total) > g.maxFraction {
+		return true
+	}
+	return false
+}
+
+// reset drops a pending shrink confirmation, for polls that did not
+// show a shrink: unchanged, empty, failed or rejected polls.
+func (g *shrinkGuard) reset() {
+	g.pending = 0
+	g.pendingRemoved = ""
+}
+
+// allow reports whether next may replace prev. It also returns how many
+// tasks next removes, and for a blocked shrink, how many consecutive polls
+// have confirmed it so far. Only polls removing the same tasks confirm
+// a shrink.
+func (g *shrinkGuard) allow(prev, next []Task) (bool, int, int) {
+	list := removed(prev, next)
+	count := len(list)
+	if !g.exceeds(count, len(prev)) {
+		g.reset()
+		return true, count, 0
+	}
+	key := strings.Join(list, ",")
+	if key != g.pendingRemoved {
+		g.reset() // a different shrink starts over
+		g.pendingRemoved = key
+	}
+	g.pending++
+	if g.pending >= g.confirmPolls {
+		g.reset() // shrink confirmed
+		return true, count, g.confirmPolls
+	}
+	return false, count, g.pending
+}
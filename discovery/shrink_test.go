@@ -0,0 +1,266 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func makeTasks(n int) []Task {
+	var tasks []Task
+	for i := range n {
+		tasks = append(tasks, Task{ARN: fmt.Sprint(i), Address: fmt.Sprintf("10.0.0.%d", i)})
+	}
+	return tasks
+}
+
+func TestShrinkGuard(t *testing.T) {
+	ten := makeTasks(10)
+
+	testCases := []struct {
+		name     string
+		options  Options
+		next     []Task
+		expected []bool // allowed per consecutive poll
+	}{
+		{"no limit", Options{}, makeTasks(1), []bool{true}},
+		{"fraction within", Options{MaxShrinkFraction: 0.5}, makeTasks(5), []bool{true}},
+		{"fraction exceeded", Options{MaxShrinkFraction: 0.5}, makeTasks(2), []bool{false, false, true}},
+		{"count within", Options{MaxShrinkCount: 3}, makeTasks(7), []bool{true}},
+		{"count exceeded", Options{MaxShrinkCount: 3, ShrinkConfirmPolls: 2}, makeTasks(6), []bool{false, true}},
+		{"growth", Options{MaxShrinkCount: 1}, makeTasks(20), []bool{true}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			guard := newShrinkGuard(tc.options)
+			for i, expected := range tc.expected {
+				allowed, _, _ := guard.allow(ten, tc.next)
+				if allowed != expected {
+					t.Fatalf("poll %d: expected allowed=%t, got %t", i, expected, allowed)
+				}
+			}
+		})
+	}
+}
+
+func TestShrinkGuardResetsConfirmation(t *testing.T) {
+	guard := newShrinkGuard(Options{MaxShrinkFraction: 0.5, ShrinkConfirmPolls: 2})
+	ten := makeTasks(10)
+
+	if allowed, _, _ := guard.allow(ten, makeTasks(1)); allowed {
+		t.Fatal("expected first big shrink blocked")
+	}
+	if allowed, _, _ := guard.allow(ten, makeTasks(9)); !allowed {
+		t.Fatal("expected small shrink allowed")
+	}
+	if allowed, _, _ := guard.allow(ten, makeTasks(1)); allowed {
+		t.Fatal("expected big shrink blocked again after confirmation reset")
+	}
+}
+
+func TestShrinkGuardRequiresSameShrink(t *testing.T) {
+	guard := newShrinkGuard(Options{MaxShrinkFraction: 0.5, ShrinkConfirmPolls: 2})
+	ten := makeTasks(10)
+
+	if allowed, _, _ := guard.allow(ten, ten[:2]); allowed {
+		t.Fatal("expected first big shrink blocked")
+	}
+	if allowed, _, _ := guard.allow(ten, ten[8:]); allowed {
+		t.Fatal("expected a different big shrink blocked, not confirmed")
+	}
+	if allowed, _, _ := guard.allow(ten, ten[8:]); !allowed {
+		t.Fatal("expected same big shrink confirmed")
+	}
+}
+
+// sequenceSource returns task lists in sequence, repeating the last one.
+type sequenceSource struct {
+	mu    sync.Mutex
+	lists [][]Task
+}
+
+func (s *sequenceSource) Tasks(_ context.Context, _ string) ([]Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tasks := s.lists[0]
+	if len(s.lists) > 1 {
+		s.lists = s.lists[1:]
+	}
+	if tasks == nil {
+		return nil, errors.New("poll failed")
+	}
+	return tasks, nil
+}
+
+func TestDiscoveryBlocksShrink(t *testing.T) {
+	t.Setenv(envVarMetadataURI, "") // no ECS metadata available
+	t.Setenv(envVarMetadataURIV3, "")
+
+	registry := prometheus.NewRegistry()
+	ch := make(chan []Task, 10)
+
+	source := &sequenceSource{lists: [][]Task{
+		makeTasks(10),
+		makeTasks(2), // blocked
+		makeTasks(2), // blocked
+		makeTasks(2), // confirmed
+	}}
+
+	d, err := New(Options{
+		ServiceName:       "svc",
+		Interval:          10 * time.Millisecond,
+		TaskSource:        source,
+		Callback:          func(tasks []Task) { ch <- tasks },
+		MaxShrinkFraction: 0.5,
+		MetricsRegisterer: registry,
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer d.Stop()
+
+	for _, expected := range []int{10, 2} {
+		select {
+		case tasks := <-ch:
+			if len(tasks) != expected {
+				t.Fatalf("expected delivery of %d tasks, got %d", expected, len(tasks))
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for delivery of %d tasks", expected)
+		}
+	}
+
+	if blocked := testutil.ToFloat64(prometheusSink(t, d.metrics).shrinkBlock.WithLabelValues("svc")); blocked != 2 {
+		t.Fatalf("expected 2 blocked shrinks, got %v", blocked)
+	}
+}
+
+func TestDiscoveryShrinkRequiresConsecutivePolls(t *testing.T) {
+	t.Setenv(envVarMetadataURI, "") // no ECS metadata available
+	t.Setenv(envVarMetadataURIV3, "")
+
+	registry := prometheus.NewRegistry()
+	ch := make(chan []Task, 10)
+
+	source := &sequenceSource{lists: [][]Task{
+		makeTasks(10),
+		makeTasks(2),  // blocked
+		makeTasks(10), // unchanged: recovered
+		makeTasks(2),  // blocked
+		makeTasks(10), // unchanged: recovered
+		makeTasks(2),  // blocked
+		makeTasks(10),
+	}}
+
+	d, err := New(Options{
+		ServiceName:        "svc",
+		Interval:           10 * time.Millisecond,
+		TaskSource:         source,
+		Callback:           func(tasks []Task) { ch <- tasks },
+		MaxShrinkFraction:  0.5,
+		ShrinkConfirmPolls: 3,
+		MetricsRegisterer:  registry,
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer d.Stop()
+
+	select {
+	case tasks := <-ch:
+		if len(tasks) != 10 {
+			t.Fatalf("expected delivery of 10 tasks, got %d", len(tasks))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for first delivery")
+	}
+
+	blocked := prometheusSink(t, d.metrics).shrinkBlock.WithLabelValues("svc")
+	deadline := time.Now().Add(2 * time.Second)
+	for testutil.ToFloat64(blocked) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for 3 blocked shrinks, got %v", testutil.ToFloat64(blocked))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	select {
+	case tasks := <-ch:
+		t.Fatalf("expected non-consecutive drops never confirmed, got delivery of %d tasks", len(tasks))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDiscoveryShrinkNotConfirmedAcrossFailedPolls(t *testing.T) {
+	t.Setenv(envVarMetadataURI, "") // no ECS metadata available
+	t.Setenv(envVarMetadataURIV3, "")
+
+	registry := prometheus.NewRegistry()
+	ch := make(chan []Task, 10)
+
+	source := &sequenceSource{lists: [][]Task{
+		makeTasks(10),
+		makeTasks(2), // blocked
+		nil,          // failed poll
+		makeTasks(2), // blocked
+		{},           // empty poll
+		makeTasks(2), // blocked
+		nil,
+	}}
+
+	d, err := New(Options{
+		ServiceName:        "svc",
+		Interval:           10 * time.Millisecond,
+		TaskSource:         source,
+		Callback:           func(tasks []Task) { ch <- tasks },
+		MaxShrinkFraction:  0.5,
+		ShrinkConfirmPolls: 2,
+		MetricsRegisterer:  registry,
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer d.Stop()
+
+	select {
+	case tasks := <-ch:
+		if len(tasks) != 10 {
+			t.Fatalf("expected delivery of 10 tasks, got %d", len(tasks))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for first delivery")
+	}
+
+	blocked := prometheusSink(t, d.metrics).shrinkBlock.WithLabelValues("svc")
+	deadline := time.Now().Add(2 * time.Second)
+	for testutil.ToFloat64(blocked) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for 3 blocked shrinks, got %v", testutil.ToFloat64(blocked))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	select {
+	case tasks := <-ch:
+		t.Fatalf("expected drops across failed polls never confirmed, got delivery of %d tasks", len(tasks))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRemovedSharedAddress(t *testing.T) {
+	prev := []Task{
+		{ARN: "a", Address: "10.0.0.1", Port: 5001},
+		{ARN: "b", Address: "10.0.0.1", Port: 5002},
+		{ARN: "c", Address: "10.0.0.1", Port: 5003},
+	}
+	if list := removed(prev, prev[:1]); len(list) != 2 {
+		t.Errorf("expected 2 tasks removed from shared address, got %v", list)
+	}
+}
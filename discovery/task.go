@@ -6,6 +6,7 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
 )
 
 // ecsClient defines the subset of *ecs.Client methods required for health check detection.
@@ -17,34 +18,76 @@ type ecsClient interface {
 
 // IsHealthCheckEnabled checks if the service's active task definition has container health check enabled
 // on any of its essential containers.
-func IsHealthCheckEnabled(ctx context.Context, client ecsClient, cluster, serviceName string) (bool, error) {
+// Optional optFns are applied to every ECS API call.
+func IsHealthCheckEnabled(ctx context.Context, client ecsClient, cluster, serviceName string,
+	optFns ...func(*ecs.Options)) (bool, error) {
+	taskDefArn, err := serviceTaskDefinition(ctx, client, cluster, serviceName, optFns...)
+	if err != nil {
+		return false, err
+	}
+	return TaskDefinitionHasHealthCheck(ctx, client, taskDefArn, optFns...)
+}
+
+// IsContainerHealthCheckEnabled checks if the named container in the service's
+// active task definition has a health check.
+// Optional optFns are applied to every ECS API call.
+func IsContainerHealthCheckEnabled(ctx context.Context, client ecsClient, cluster, serviceName, containerName string,
+	optFns ...func(*ecs.Options)) (bool, error) {
+	taskDefArn, err := serviceTaskDefinition(ctx, client, cluster, serviceName, optFns...)
+	if err != nil {
+		return false, err
+	}
+	return ContainerHasHealthCheck(ctx, client, taskDefArn, containerName, optFns...)
+}
+
+// serviceTaskDefinition returns the ARN of the service's active task definition.
+func serviceTaskDefinition(ctx context.Context, client ecsClient, cluster, serviceName string,
+	optFns ...func(*ecs.Options)) (string, error) {
 	out, err := client.DescribeServices(ctx, &ecs.DescribeServicesInput{
 		Cluster:  aws.String(cluster),
 		Services: []string{serviceName},
-	})
+	}, optFns...)
 	if err != nil {
-		return false, fmt.Errorf("describe services: %w", err)
+		return "", fmt.Errorf("describe services: %w", err)
 	}
 	if len(out.Services) == 0 {
-		return false, fmt.Errorf("service %s not found in cluster %s", serviceName, cluster)
+		return "", fmt.Errorf("service %s not found in cluster %s", serviceName, cluster)
 	}
 
 	taskDefArn := out.Services[0].TaskDefinition
 	if taskDefArn == nil {
-		return false, fmt.Errorf("no task definition associated with service %s", serviceName)
+		return "", fmt.Errorf("no task definition associated with service %s", serviceName)
 	}
 
+	return aws.ToString(taskDefArn), nil
+}
+
+// describeTaskDefinition retrieves a task definition.
+func describeTaskDefinition(ctx context.Context, client ecsClient, taskDefinitionARN string,
+	optFns ...func(*ecs.Options)) (*types.TaskDefinition, error) {
 	outDef, err := client.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
-		TaskDefinition: taskDefArn,
-	})
+		TaskDefinition: aws.String(taskDefinitionARN),
+	}, optFns...)
 	if err != nil {
-		return false, fmt.Errorf("describe task definition: %w", err)
+		return nil, fmt.Errorf("describe task definition: %w", err)
 	}
 	if outDef.TaskDefinition == nil {
-		return false, fmt.Errorf("task definition not found for ARN %s", aws.ToString(taskDefArn))
+		return nil, fmt.Errorf("task definition not found for ARN %s", taskDefinitionARN)
+	}
+	return outDef.TaskDefinition, nil
+}
+
+// TaskDefinitionHasHealthCheck checks if a task definition has container health check enabled
+// on any of its essential containers.
+// Optional optFns are applied to the ECS API call.
+func TaskDefinitionHasHealthCheck(ctx context.Context, client ecsClient, taskDefinitionARN string,
+	optFns ...func(*ecs.Options)) (bool, error) {
+	taskDef, err := describeTaskDefinition(ctx, client, taskDefinitionARN, optFns...)
+	if err != nil {
+		return false, err
 	}
 
-	for _, containerDef := range outDef.TaskDefinition.ContainerDefinitions {
+	for _, containerDef := range taskDef.ContainerDefinitions {
 		// Only check essential containers, since they are the ones determining task health status.
 		// ContainerDefinition.Essential is a pointer to bool. If nil, it defaults to true.
 		isEssential := containerDef.Essential == nil || *containerDef.Essential
@@ -55,3 +98,85 @@ func IsHealthCheckEnabled(ctx context.Context, client ecsClient, cluster, servic
 
 	return false, nil
 }
+
+// ContainerHasHealthCheck checks if the named container of a task definition has a health check,
+// whether or not the container is essential.
+// Optional optFns are applied to the ECS API call.
+func ContainerHasHealthCheck(ctx context.Context, client ecsClient, taskDefinitionARN, containerName string,
+	optFns ...func(*ecs.Options)) (bool, error) {
+	taskDef, err := describeTaskDefinition(ctx, client, taskDefinitionARN, optFns...)
+	if err != nil {
+		return false, err
+	}
+
+	for _, containerDef := range taskDef.ContainerDefinitions {
+		if aws.ToString(containerDef.Name) == containerName {
+			return containerDef.HealthCheck != nil, nil
+		}
+	}
+
+	return false, fmt.Errorf("container %s not found in task definition %s", containerName, taskDefinitionARN)
+}
+
+// filterByRevisionHealth filters tasks whose own task definition revision has
+// health checks to only include HEALTHY tasks.
+func (d *Discovery) filterByRevisionHealth(ctx context.Context, tasks []Task) []Task {
+	var filtered []Task
+	for _, t := range tasks {
+		if d.revisionHasHealthCheck(ctx, t.TaskDefinitionARN) && !d.healthy(t) {
+			continue
+		}
+		filtered = append(filtered, t)
+	}
+	return filtered
+}
+
+// revisionHasHealthCheck looks up, and caches, whether a task definition
+// revision has health checks. It falls back to service-level detection.
+func (d *Discovery) revisionHasHealthCheck(ctx context.Context, taskDefinitionARN string) bool {
+	if taskDefinitionARN == "" {
+		return d.healthCheckEnabled
+	}
+
+	if enabled, found := d.revisionHealth[taskDefinitionARN]; found {
+		return enabled
+	}
+
+	if d.taskDefClient == nil {
+		return d.healthCheckEnabled
+	}
+
+	optFns := ecsOptions(d.options.RateLimiter, d.metrics, d.tracing(), d.options.ServiceName)
+
+	var enabled bool
+	var err error
+	if d.options.HealthContainer == "" {
+		enabled, err = TaskDefinitionHasHealthCheck(ctx, d.taskDefClient, taskDefinitionARN, optFns...)
+	} else {
+		enabled, err = ContainerHasHealthCheck(ctx, d.taskDefClient, taskDefinitionARN,
+			d.options.HealthContainer, optFns...)
+	}
+	if err != nil {
+		// do not cache, retry on next poll
+		d.log().Error("revision health check lookup failed, falling back",
+			"task_definition", taskDefinitionARN, "fallback", d.healthCheckEnabled, "error", err)
+		return d.healthCheckEnabled
+	}
+
+	d.log().Info("revision health check detected",
+		"task_definition", taskDefinitionARN, "has_health_check", enabled)
+
+	d.revisionHealth[taskDefinitionARN] = enabled
+	return enabled
+}
+
+// healthStatus returns the health status of the container selected by
+// HealthContainer, or the task health status if HealthContainer is undefined.
+// A task missing the selected container has empty health status.
+func (d *Discovery) healthStatus(t Task) string {
+	if d.options.HealthContainer == "" {
+		return t.HealthStatus
+	}
+	status, _ := t.Containers.Status(d.options.HealthContainer)
+	return status
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"errors"
 	"io"
+	"log/slog"
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
@@ -378,7 +380,7 @@ func TestDescribeTasksSkipsMissingPrivateIPv4Address(t *testing.T) {
 		},
 	})
 
-	got, err := describeTasks(context.Background(), client, "demo", []string{"arn:task:1", "arn:task:2"})
+	got, err := describeTasks(context.Background(), slog.Default(), client, "demo", []string{"arn:task:1", "arn:task:2"})
 	if err != nil {
 		t.Fatalf("describeTasks() unexpected error: %v", err)
 	}
@@ -395,3 +397,310 @@ func TestDescribeTasksSkipsMissingPrivateIPv4Address(t *testing.T) {
 		t.Fatalf("describeTasks() expected surviving ARN %q, got %q", "arn:aws:ecs:us-east-1:111122223333:task/demo/ok", got[0].ARN)
 	}
 }
+
+func TestDescribeTasksReportsFailures(t *testing.T) {
+	transport := &describeTasksTransport{
+		describeTasksBody: `
+			{
+				"tasks": [
+					{
+						"taskArn": "arn:task:1",
+						"healthStatus": "HEALTHY",
+						"lastStatus": "RUNNING",
+						"attachments": [{"details": [{"name": "privateIPv4Address", "value": "10.0.0.1"}]}]
+					}
+				],
+				"failures": [
+					{"arn": "arn:task:2", "reason": "MISSING"}
+				]
+			}
+		`,
+	}
+
+	client := ecs.NewFromConfig(aws.Config{
+		Region: "us-east-1",
+		HTTPClient: &http.Client{
+			Transport: transport,
+		},
+	})
+
+	got, err := describeTasks(context.Background(), slog.Default(), client, "demo", []string{"arn:task:1", "arn:task:2"})
+
+	var partial *PartialResultError
+	if !errors.As(err, &partial) {
+		t.Fatalf("describeTasks() expected PartialResultError, got %v", err)
+	}
+	if len(partial.Failures) != 1 || partial.Failures[0].ARN != "arn:task:2" || partial.Failures[0].Reason != "MISSING" {
+		t.Fatalf("describeTasks() unexpected failures: %+v", partial.Failures)
+	}
+	if len(got) != 1 || got[0].ARN != "arn:task:1" {
+		t.Fatalf("describeTasks() expected described task along with failures, got %+v", got)
+	}
+}
+
+func TestFilterByRevisionHealth(t *testing.T) {
+	const (
+		withHealth    = "arn:aws:ecs:us-east-1:123456789012:task-definition/my-task:1"
+		withoutHealth = "arn:aws:ecs:us-east-1:123456789012:task-definition/my-task:2"
+		broken        = "arn:aws:ecs:us-east-1:123456789012:task-definition/my-task:3"
+	)
+
+	calls := map[string]int{}
+
+	client := &mockECSClient{
+		DescribeTaskDefinitionFunc: func(_ context.Context, params *ecs.DescribeTaskDefinitionInput, _ ...func(*ecs.Options)) (*ecs.DescribeTaskDefinitionOutput, error) {
+			arn := aws.ToString(params.TaskDefinition)
+			calls[arn]++
+			container := types.ContainerDefinition{Name: aws.String("app")}
+			switch arn {
+			case withHealth:
+				container.HealthCheck = &types.HealthCheck{Command: []string{"CMD", "true"}}
+			case broken:
+				return nil, errors.New("boom")
+			}
+			return &ecs.DescribeTaskDefinitionOutput{
+				TaskDefinition: &types.TaskDefinition{ContainerDefinitions: []types.ContainerDefinition{container}},
+			}, nil
+		},
+	}
+
+	input := []Task{
+		{ARN: "a", HealthStatus: "HEALTHY", TaskDefinitionARN: withHealth},
+		{ARN: "b", HealthStatus: "UNKNOWN", TaskDefinitionARN: withHealth},    // dropped
+		{ARN: "c", HealthStatus: "UNKNOWN", TaskDefinitionARN: withoutHealth}, // no health check
+		{ARN: "d", HealthStatus: "UNKNOWN", TaskDefinitionARN: broken},        // falls back to service-level
+		{ARN: "e", HealthStatus: "UNKNOWN"},                                   // falls back to service-level
+	}
+
+	for _, serviceLevel := range []bool{false, true} {
+		d := &Discovery{
+			healthCheckEnabled: serviceLevel,
+			healthPerRevision:  true,
+			taskDefClient:      client,
+			revisionHealth:     map[string]bool{},
+		}
+
+		var got []string
+		for range 2 {
+			got = nil
+			for _, task := range d.filterByHealth(context.Background(), input) {
+				got = append(got, task.ARN)
+			}
+		}
+
+		expected := "a,c,d,e"
+		if serviceLevel {
+			expected = "a,c"
+		}
+		if strings.Join(got, ",") != expected {
+			t.Fatalf("serviceLevel=%t: expected tasks %s, got %v", serviceLevel, expected, got)
+		}
+	}
+
+	// two Discovery instances, two polls each
+	if calls[withHealth] != 2 || calls[withoutHealth] != 2 {
+		t.Fatalf("expected revisions described once per Discovery and cached, got %v", calls)
+	}
+	if calls[broken] != 4 {
+		t.Fatalf("expected failed revision lookup retried on every poll, got %d", calls[broken])
+	}
+}
+
+func TestContainerHasHealthCheck(t *testing.T) {
+	client := &mockECSClient{
+		DescribeServicesFunc: func(_ context.Context, _ *ecs.DescribeServicesInput, _ ...func(*ecs.Options)) (*ecs.DescribeServicesOutput, error) {
+			return &ecs.DescribeServicesOutput{
+				Services: []types.Service{{TaskDefinition: aws.String("arn:task-def:1")}},
+			}, nil
+		},
+		DescribeTaskDefinitionFunc: func(_ context.Context, _ *ecs.DescribeTaskDefinitionInput, _ ...func(*ecs.Options)) (*ecs.DescribeTaskDefinitionOutput, error) {
+			return &ecs.DescribeTaskDefinitionOutput{
+				TaskDefinition: &types.TaskDefinition{
+					ContainerDefinitions: []types.ContainerDefinition{
+						{Name: aws.String("app")},
+						{
+							Name:        aws.String("sidecar"),
+							Essential:   aws.Bool(false),
+							HealthCheck: &types.HealthCheck{Command: []string{"CMD", "true"}},
+						},
+					},
+				},
+			}, nil
+		},
+	}
+
+	tests := []struct {
+		container   string
+		expected    bool
+		expectedErr bool
+	}{
+		{container: "app", expected: false},
+		{container: "sidecar", expected: true}, // non-essential container still counts
+		{container: "missing", expectedErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.container, func(t *testing.T) {
+			got, err := IsContainerHealthCheckEnabled(context.Background(), client, "my-cluster", "my-service", tc.container)
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.expected {
+				t.Fatalf("expected %t, got %t", tc.expected, got)
+			}
+		})
+	}
+
+	// task-level detection ignores the non-essential sidecar
+	enabled, err := IsHealthCheckEnabled(context.Background(), client, "my-cluster", "my-service")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if enabled {
+		t.Fatal("expected task-level health check disabled")
+	}
+}
+
+func TestFilterByContainerHealth(t *testing.T) {
+	input := []Task{
+		{ARN: "a", HealthStatus: "UNHEALTHY", Containers: NewContainerHealth(
+			Container{Name: "app", HealthStatus: "HEALTHY"},
+			Container{Name: "sidecar", HealthStatus: "UNHEALTHY"},
+		)},
+		{ARN: "b", HealthStatus: "HEALTHY", Containers: NewContainerHealth(
+			Container{Name: "app", HealthStatus: "UNKNOWN"},
+		)},
+		{ARN: "c", HealthStatus: "HEALTHY"}, // missing container
+	}
+
+	d := &Discovery{
+		options:            Options{HealthContainer: "app"},
+		healthCheckEnabled: true,
+	}
+
+	got := d.filterByHealth(context.Background(), input)
+
+	if len(got) != 1 || got[0].ARN != "a" {
+		t.Fatalf("expected only task with healthy container, got %+v", got)
+	}
+}
+
+func TestDescribeTasksContainers(t *testing.T) {
+	transport := &describeTasksTransport{
+		describeTasksBody: `
+			{
+				"tasks": [
+					{
+						"taskArn": "arn:task:1",
+						"taskDefinitionArn": "arn:task-def:1",
+						"availabilityZone": "us-east-1a",
+						"healthStatus": "UNHEALTHY",
+						"lastStatus": "RUNNING",
+						"attachments": [{"details": [{"name": "privateIPv4Address", "value": "10.0.0.1"}]}],
+						"containers": [
+							{"name": "app", "healthStatus": "HEALTHY"},
+							{"name": "sidecar", "healthStatus": "UNHEALTHY"}
+						]
+					}
+				]
+			}
+		`,
+	}
+
+	client := ecs.NewFromConfig(aws.Config{
+		Region: "us-east-1",
+		HTTPClient: &http.Client{
+			Transport: transport,
+		},
+	})
+
+	got, err := describeTasks(context.Background(), slog.Default(), client, "demo", []string{"arn:task:1"})
+	if err != nil {
+		t.Fatalf("describeTasks() unexpected error: %v", err)
+	}
+
+	expected := Task{
+		ARN:               "arn:task:1",
+		Address:           "10.0.0.1",
+		HealthStatus:      "UNHEALTHY",
+		LastStatus:        "RUNNING",
+		TaskDefinitionARN: "arn:task-def:1",
+		Containers: NewContainerHealth(
+			Container{Name: "app", HealthStatus: "HEALTHY"},
+			Container{Name: "sidecar", HealthStatus: "UNHEALTHY"},
+		),
+		AvailabilityZone: "us-east-1a",
+	}
+	if len(got) != 1 || got[0] != expected {
+		t.Fatalf("describeTasks() expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestFilterByHealthUnknownGrace(t *testing.T) {
+	now := time.Now()
+	input := []Task{
+		{ARN: "healthy", HealthStatus: "HEALTHY"},
+		{ARN: "young", HealthStatus: "UNKNOWN", StartedAt: now.Add(-10 * time.Second)},
+		{ARN: "old", HealthStatus: "UNKNOWN", StartedAt: now.Add(-10 * time.Minute)},
+		{ARN: "no-start", HealthStatus: "UNKNOWN"},
+		{ARN: "unhealthy", HealthStatus: "UNHEALTHY", StartedAt: now},
+	}
+
+	tests := []struct {
+		grace    time.Duration
+		expected string
+	}{
+		{0, "healthy"},
+		{time.Minute, "healthy,young"},
+	}
+
+	for _, tc := range tests {
+		d := &Discovery{
+			options:            Options{UnknownHealthGrace: tc.grace},
+			healthCheckEnabled: true,
+		}
+		var got []string
+		for _, task := range d.filterByHealth(context.Background(), input) {
+			got = append(got, task.ARN)
+		}
+		if strings.Join(got, ",") != tc.expected {
+			t.Fatalf("grace=%v: expected %s, got %v", tc.grace, tc.expected, got)
+		}
+	}
+}
+
+func TestFilterByHealthIncludesSelf(t *testing.T) {
+	input := []Task{
+		{ARN: "a", Address: "10.0.0.1", HealthStatus: "HEALTHY"},
+		{ARN: "b", Address: "10.0.0.2", HealthStatus: "UNKNOWN"},
+	}
+
+	tests := []struct {
+		name     string
+		self     string
+		tasks    []Task
+		expected string
+	}{
+		{"no self", "", input, "a"},
+		{"self healthy", "10.0.0.1", input, "a"},
+		{"self filtered out", "10.0.0.2", input, "a,b"},
+		{"self not listed", "10.0.0.3", input, "a,self"},
+		{"nothing discovered", "10.0.0.3", nil, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &Discovery{
+				options:            Options{SelfAddress: tc.self},
+				healthCheckEnabled: true,
+			}
+			var got []string
+			for _, task := range d.filterByHealth(context.Background(), tc.tasks) {
+				got = append(got, task.ARN)
+			}
+			if strings.Join(got, ",") != tc.expected {
+				t.Fatalf("expected %s, got %v", tc.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package discovery
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/aws/smithy-go/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies spans created by this package.
+const tracerName = "github.com/udhos/ecs-task-discovery/discovery"
+
+// newTracer returns a tracer from provider, or from the global provider.
+// The global provider is a no-op unless the application installs one.
+func newTracer(provider trace.TracerProvider) trace.Tracer {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return provider.Tracer(tracerName)
+}
+
+// tracing returns the Discovery tracer.
+// It falls back to the global provider for Discovery values not built by New.
+func (d *Discovery) tracing() trace.Tracer {
+	if d.tracer == nil {
+		return newTracer(nil)
+	}
+	return d.tracer
+}
+
+// propagator injects W3C trace context into agent requests.
+var propagator = propagation.TraceContext{}
+
+// injectTraceContext adds W3C trace context headers from ctx to req.
+func injectTraceContext(ctx context.Context, req *http.Request) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+}
+
+// endSpan records err, if any, and ends span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceMiddleware creates a client span for each ECS API call.
+// Retries are part of the call span.
+func traceMiddleware(tracer trace.Tracer, service string) middleware.InitializeMiddleware {
+	return middleware.InitializeMiddlewareFunc("ECSTaskDiscoveryTrace",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (
+			middleware.InitializeOutput, middleware.Metadata, error) {
+			operation := middleware.GetOperationName(ctx)
+			ctx, span := tracer.Start(ctx, "ECS."+operation,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					attribute.String("rpc.system", "aws-api"),
+					attribute.String("rpc.service", "ECS"),
+					attribute.String("rpc.method", operation),
+					attribute.String("discovery.service", service),
+				))
+			out, metadata, err := next.HandleInitialize(ctx, in)
+			endSpan(span, err)
+			return out, metadata, err
+		})
+}
@@ -0,0 +1,118 @@
+package discovery
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestDiscoveryTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	// failing agent captures propagated trace context
+	var mu sync.Mutex
+	var agentSpan trace.SpanContext
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagation.TraceContext{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		mu.Lock()
+		agentSpan = trace.SpanContextFromContext(ctx)
+		mu.Unlock()
+		http.Error(w, "agent failure", http.StatusInternalServerError)
+	}))
+	defer agent.Close()
+
+	delivered := make(chan struct{}, 1)
+
+	d := &Discovery{
+		options: Options{
+			ServiceName: "svc",
+			Interval:    5 * time.Second,
+			AgentURL:    agent.URL,
+			Client: ecs.NewFromConfig(aws.Config{
+				Region:     "us-east-1",
+				HTTPClient: &http.Client{Transport: &mockECSTransport{}},
+			}),
+			TracerProvider: provider,
+			Callback: func(_ []Task) {
+				select {
+				case delivered <- struct{}{}:
+				default:
+				}
+			},
+		},
+		clusterName: "demo",
+		httpClient:  http.DefaultClient,
+		done:        make(chan struct{}),
+		tracer:      newTracer(provider),
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		d.run()
+		close(exited)
+	}()
+
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for first discovery cycle")
+	}
+	d.Stop()
+	<-exited
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, s := range exporter.GetSpans() {
+		spans[s.Name] = s
+	}
+
+	poll, found := spans["discovery.poll"]
+	if !found {
+		t.Fatalf("missing poll span, got %v", spanNames(exporter.GetSpans()))
+	}
+	if poll.Parent.IsValid() {
+		t.Errorf("expected poll to be a root span")
+	}
+
+	for _, name := range []string{"discovery.queryAgent", "ECS.ListTasks", "ECS.DescribeTasks"} {
+		s, found := spans[name]
+		if !found {
+			t.Errorf("missing span %s, got %v", name, spanNames(exporter.GetSpans()))
+			continue
+		}
+		if s.Parent.SpanID() != poll.SpanContext.SpanID() {
+			t.Errorf("span %s: expected parent poll span", name)
+		}
+	}
+
+	if status := spans["discovery.queryAgent"].Status.Code; status != codes.Error {
+		t.Errorf("expected failed agent query span status Error, got %v", status)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if agentSpan.TraceID() != poll.SpanContext.TraceID() {
+		t.Errorf("agent request trace id: expected=%s got=%s", poll.SpanContext.TraceID(), agentSpan.TraceID())
+	}
+	if agentSpan.SpanID() != spans["discovery.queryAgent"].SpanContext.SpanID() {
+		t.Errorf("agent request parent should be queryAgent span")
+	}
+}
+
+func spanNames(spans tracetest.SpanStubs) []string {
+	var names []string
+	for _, s := range spans {
+		names = append(names, s.Name)
+	}
+	return names
+}
@@ -0,0 +1,294 @@
+package groupcachediscovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/udhos/ecs-task-discovery/discovery"
+)
+
+// AddrStrategy is a method for finding our local IP address.
+type AddrStrategy string
+
+const (
+	// AddrStrategyMetadata takes the address from the ECS container metadata task network.
+	AddrStrategyMetadata AddrStrategy = "metadata"
+
+	// AddrStrategyInterface scans local network interfaces.
+	AddrStrategyInterface AddrStrategy = "interface"
+
+	// AddrStrategyHostname resolves the hostname.
+	AddrStrategyHostname AddrStrategy = "hostname"
+)
+
+const (
+	envAddrStrategies = "ECS_TASK_DISCOVERY_ADDR_STRATEGIES"
+	envAddrCIDR       = "ECS_TASK_DISCOVERY_ADDR_CIDR"
+	envAddrInterface  = "ECS_TASK_DISCOVERY_ADDR_INTERFACE"
+)
+
+// defaultAddrStrategies is the default order of strategies.
+var defaultAddrStrategies = []AddrStrategy{AddrStrategyMetadata, AddrStrategyInterface, AddrStrategyHostname}
+
+// AddrOptions define settings for finding our local IP address.
+type AddrOptions struct {
+	// Strategies lists strategies to try, in order.
+	// If undefined, retrieves comma-separated value from env var ECS_TASK_DISCOVERY_ADDR_STRATEGIES.
+	// Defaults to: metadata, interface, hostname.
+	Strategies []AddrStrategy
+
+	// CIDRs restricts addresses to these networks, for all strategies. For instance, "10.0.0.0/8".
+	// If undefined, retrieves comma-separated value from env var ECS_TASK_DISCOVERY_ADDR_CIDR.
+	CIDRs []string
+
+	// Interfaces restricts the interface strategy to these interface names. For instance, "eth1".
+	// If undefined, retrieves comma-separated value from env var ECS_TASK_DISCOVERY_ADDR_INTERFACE.
+	Interfaces []string
+
+	// MetadataClient optionally provides the metadata client for the metadata strategy.
+	MetadataClient *discovery.MetadataClient
+}
+
+// AddrResult is our local IP address and the strategy that found it.
+type AddrResult struct {
+	Addr     string
+	Strategy AddrStrategy
+}
+
+// interfaceAddr is an address assigned to a local network interface.
+type interfaceAddr struct {
+	name string
+	ip   net.IP
+}
+
+// Test seams for local host lookups.
+var (
+	interfaceAddrsFunc = interfaceAddrs
+	hostnameFunc       = os.Hostname
+	lookupHostFunc     = net.LookupHost
+)
+
+// FindAddr finds our local IP address by trying strategies in order,
+// returning the first address found and the strategy that found it.
+//
+// If only the hostname strategy finds an address, but the hostname resolves
+// to multiple addresses, the first one is returned along with an error.
+func FindAddr(ctx context.Context, options AddrOptions) (AddrResult, error) {
+	strategies := options.Strategies
+	if len(strategies) == 0 {
+		for _, s := range splitEnv(envAddrStrategies) {
+			strategies = append(strategies, AddrStrategy(s))
+		}
+	}
+	if len(strategies) == 0 {
+		strategies = defaultAddrStrategies
+	}
+	if len(options.CIDRs) == 0 {
+		options.CIDRs = splitEnv(envAddrCIDR)
+	}
+	if len(options.Interfaces) == 0 {
+		options.Interfaces = splitEnv(envAddrInterface)
+	}
+
+	networks, errCIDR := parseCIDRs(options.CIDRs)
+	if errCIDR != nil {
+		return AddrResult{}, errCIDR
+	}
+
+	var errs []error
+	var fallback AddrResult
+	for _, s := range strategies {
+		var addr string
+		var err error
+		switch s {
+		case AddrStrategyMetadata:
+			addr, err = findMetadataAddr(ctx, options.MetadataClient, networks)
+		case AddrStrategyInterface:
+			addr, err = findInterfaceAddr(options.Interfaces, networks)
+		case AddrStrategyHostname:
+			addr, err = findHostAddr(networks)
+		default:
+			return AddrResult{}, fmt.Errorf("unknown address strategy: '%s'", s)
+		}
+		if err == nil {
+			return AddrResult{Addr: addr, Strategy: s}, nil
+		}
+		if addr != "" && fallback.Addr == "" {
+			fallback = AddrResult{Addr: addr, Strategy: s}
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", s, err))
+	}
+
+	return fallback, errors.Join(errs...)
+}
+
+// findMetadataAddr takes the first address of our own container
+// from the ECS container metadata task network.
+func findMetadataAddr(ctx context.Context, client *discovery.MetadataClient, networks []*net.IPNet) (string, error) {
+	if client == nil {
+		c, err := discovery.NewMetadataClient(discovery.MetadataClientOptions{})
+		if err != nil {
+			return "", err
+		}
+		client = c
+	}
+	container, err := client.Container(ctx)
+	if err != nil {
+		return "", err
+	}
+	var candidates []string
+	for _, n := range container.Networks {
+		candidates = append(candidates, n.IPv4Addresses...)
+	}
+	for _, n := range container.Networks {
+		candidates = append(candidates, n.IPv6Addresses...)
+	}
+	for _, addr := range candidates {
+		if inNetworks(net.ParseIP(addr), networks) {
+			return addr, nil
+		}
+	}
+	return "", fmt.Errorf("container '%s': no address found in %d networks matching cidrs=%v",
+		container.Name, len(container.Networks), networks)
+}
+
+// findInterfaceAddr scans local network interfaces, filtered by interface name and CIDR.
+// IPv4 addresses are preferred. Without filters, it fails if multiple addresses are found.
+func findInterfaceAddr(names []string, networks []*net.IPNet) (string, error) {
+	addrs, err := interfaceAddrsFunc()
+	if err != nil {
+		return "", err
+	}
+	var v4, v6 []string
+	for _, a := range addrs {
+		if len(names) > 0 && !slices.Contains(names, a.name) {
+			continue
+		}
+		if !a.ip.IsGlobalUnicast() || !inNetworks(a.ip, networks) {
+			continue
+		}
+		addr := a.ip.String()
+		if a.ip.To4() != nil {
+			if !slices.Contains(v4, addr) {
+				v4 = append(v4, addr)
+			}
+		} else if !slices.Contains(v6, addr) {
+			v6 = append(v6, addr)
+		}
+	}
+	candidates := slices.Concat(v4, v6)
+	switch {
+	case len(candidates) == 0:
+		return "", fmt.Errorf("no interface address found matching interfaces=%v cidrs=%v", names, networks)
+	case len(names) == 0 && len(networks) == 0 && len(v4) > 1:
+		return "", fmt.Errorf("found multiple interface addresses: %v (set interface or cidr filter)", v4)
+	}
+	return candidates[0], nil
+}
+
+// findHostAddr resolves our hostname into our local IP address, filtered by CIDR.
+func findHostAddr(networks []*net.IPNet) (string, error) {
+	host, errHost := hostnameFunc()
+	if errHost != nil {
+		return "", errHost
+	}
+	all, errAddr := lookupHostFunc(host)
+	if errAddr != nil {
+		return "", errAddr
+	}
+	var addrs []string
+	for _, addr := range all {
+		if inNetworks(net.ParseIP(addr), networks) {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) < 1 {
+		return "", fmt.Errorf("hostname '%s': no addr found in %v matching cidrs=%v", host, all, networks)
+	}
+	addr := addrs[0]
+	if len(addrs) > 1 {
+		return addr, fmt.Errorf("hostname '%s': found multiple addresses: %v", host, addrs)
+	}
+	return addr, nil
+}
+
+// interfaceAddrs lists addresses of local network interfaces that are up, skipping loopback.
+func interfaceAddrs() ([]interfaceAddr, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var list []interfaceAddr
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, errAddrs := iface.Addrs()
+		if errAddrs != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok {
+				list = append(list, interfaceAddr{name: iface.Name, ip: ipNet.IP})
+			}
+		}
+	}
+	return list, nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("bad address cidr: %w", err)
+		}
+		networks = append(networks, n)
+	}
+	return networks, nil
+}
+
+// inNetworks reports whether ip belongs to one of networks.
+// An empty list of networks matches any valid ip.
+func inNetworks(ip net.IP, networks []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	if len(networks) == 0 {
+		return true
+	}
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitEnv splits a comma-separated env var into trimmed non-empty values.
+func splitEnv(name string) []string {
+	var list []string
+	for _, s := range strings.Split(os.Getenv(name), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// logAddr reports which strategy found our address.
+func logAddr(logger *slog.Logger, result AddrResult, err error) {
+	if err != nil {
+		logger.Warn("groupcachediscovery: find my address", "addr", result.Addr,
+			"strategy", result.Strategy, "error", err)
+		return
+	}
+	logger.Debug("groupcachediscovery: find my address", "addr", result.Addr,
+		"strategy", result.Strategy)
+}
@@ -0,0 +1,155 @@
+package groupcachediscovery
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/udhos/ecs-task-discovery/discovery/discoverytest"
+)
+
+// fakeHost replaces local interface and hostname lookups.
+func fakeHost(t *testing.T, ifaces []interfaceAddr, hostAddrs []string) {
+	t.Helper()
+	oldIfaces, oldHostname, oldLookup := interfaceAddrsFunc, hostnameFunc, lookupHostFunc
+	interfaceAddrsFunc = func() ([]interfaceAddr, error) { return ifaces, nil }
+	hostnameFunc = func() (string, error) { return "myhost", nil }
+	lookupHostFunc = func(string) ([]string, error) { return hostAddrs, nil }
+	t.Cleanup(func() {
+		interfaceAddrsFunc, hostnameFunc, lookupHostFunc = oldIfaces, oldHostname, oldLookup
+	})
+}
+
+// noMetadata disables the metadata strategy and addr env vars.
+func noMetadata(t *testing.T) {
+	t.Helper()
+	t.Setenv(discoverytest.EnvMetadataURI, "")
+	t.Setenv(discoverytest.EnvMetadataURIV3, "")
+	t.Setenv(envAddrStrategies, "")
+	t.Setenv(envAddrCIDR, "")
+	t.Setenv(envAddrInterface, "")
+}
+
+var twoENIs = []interfaceAddr{
+	{name: "eth0", ip: net.ParseIP("169.254.172.2")}, // link-local task metadata network
+	{name: "eth1", ip: net.ParseIP("10.0.1.5")},
+	{name: "eth2", ip: net.ParseIP("10.0.2.5")},
+	{name: "eth2", ip: net.ParseIP("2001:db8::5")},
+}
+
+func TestFindAddrMetadataFirst(t *testing.T) {
+	noMetadata(t)
+	fakeHost(t, twoENIs, []string{"10.0.9.9"})
+
+	server := discoverytest.NewMetadataServer(discoverytest.Task{
+		Containers: []discoverytest.Container{{
+			Name:          "app",
+			IPv4Addresses: []string{"10.0.2.5"},
+			IPv6Addresses: []string{"2001:db8::5"},
+		}},
+	})
+	defer server.Close()
+	server.Setenv(t)
+
+	result, err := FindAddr(context.Background(), AddrOptions{})
+	if err != nil {
+		t.Fatalf("FindAddr() error: %v", err)
+	}
+	if result.Addr != "10.0.2.5" || result.Strategy != AddrStrategyMetadata {
+		t.Errorf("expected metadata address 10.0.2.5, got %+v", result)
+	}
+
+	// metadata addresses filtered by cidr
+	result, err = FindAddr(context.Background(), AddrOptions{CIDRs: []string{"2001:db8::/64"}})
+	if err != nil || result.Addr != "2001:db8::5" || result.Strategy != AddrStrategyMetadata {
+		t.Errorf("expected metadata address 2001:db8::5, got %+v error: %v", result, err)
+	}
+}
+
+func TestFindAddrInterface(t *testing.T) {
+	noMetadata(t)
+	fakeHost(t, twoENIs, []string{"10.0.9.9"})
+
+	// multiple ENIs without filter: ambiguous, falls back to hostname
+	result, err := FindAddr(context.Background(), AddrOptions{})
+	if err != nil || result.Addr != "10.0.9.9" || result.Strategy != AddrStrategyHostname {
+		t.Errorf("expected hostname address 10.0.9.9, got %+v error: %v", result, err)
+	}
+
+	// filter by interface name
+	result, err = FindAddr(context.Background(), AddrOptions{Interfaces: []string{"eth2"}})
+	if err != nil || result.Addr != "10.0.2.5" || result.Strategy != AddrStrategyInterface {
+		t.Errorf("expected interface address 10.0.2.5, got %+v error: %v", result, err)
+	}
+
+	// filter by cidr from env var
+	t.Setenv(envAddrCIDR, "192.168.0.0/16, 10.0.1.0/24")
+	result, err = FindAddr(context.Background(), AddrOptions{})
+	if err != nil || result.Addr != "10.0.1.5" || result.Strategy != AddrStrategyInterface {
+		t.Errorf("expected interface address 10.0.1.5, got %+v error: %v", result, err)
+	}
+}
+
+func TestFindAddrHostname(t *testing.T) {
+	noMetadata(t)
+	fakeHost(t, nil, []string{"10.0.1.5", "10.0.2.5"})
+
+	// multiple addresses: first one with error, as before
+	result, err := FindAddr(context.Background(), AddrOptions{})
+	if err == nil {
+		t.Error("expected error for multiple hostname addresses")
+	}
+	if result.Addr != "10.0.1.5" || result.Strategy != AddrStrategyHostname {
+		t.Errorf("expected first hostname address, got %+v", result)
+	}
+
+	// cidr selects among hostname addresses
+	result, err = FindAddr(context.Background(), AddrOptions{CIDRs: []string{"10.0.2.0/24"}})
+	if err != nil || result.Addr != "10.0.2.5" || result.Strategy != AddrStrategyHostname {
+		t.Errorf("expected hostname address 10.0.2.5, got %+v error: %v", result, err)
+	}
+
+	// nothing found
+	lookupHostFunc = func(string) ([]string, error) { return nil, errors.New("no such host") }
+	if _, err := FindAddr(context.Background(), AddrOptions{}); err == nil {
+		t.Error("expected error when all strategies fail")
+	}
+}
+
+func TestFindAddrOptions(t *testing.T) {
+	noMetadata(t)
+	fakeHost(t, twoENIs, []string{"10.0.9.9"})
+
+	t.Setenv(envAddrStrategies, "hostname")
+	result, err := FindAddr(context.Background(), AddrOptions{Interfaces: []string{"eth1"}})
+	if err != nil || result.Strategy != AddrStrategyHostname {
+		t.Errorf("expected hostname strategy from env var, got %+v error: %v", result, err)
+	}
+
+	if _, err := FindAddr(context.Background(), AddrOptions{Strategies: []AddrStrategy{"bogus"}}); err == nil {
+		t.Error("expected error for unknown strategy")
+	}
+	if _, err := FindAddr(context.Background(), AddrOptions{CIDRs: []string{"bogus"}}); err == nil {
+		t.Error("expected error for bad cidr")
+	}
+}
+
+func TestFindMyAddrUsesLogger(t *testing.T) {
+	noMetadata(t)
+	fakeHost(t, twoENIs[1:2], nil)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	addr, err := findMyAddr(logger)
+	if err != nil || addr != "10.0.1.5" {
+		t.Fatalf("findMyAddr() = %q, %v", addr, err)
+	}
+	if out := buf.String(); !strings.Contains(out, "level=DEBUG") || !strings.Contains(out, "strategy=interface") {
+		t.Fatalf("expected address logged at DEBUG to configured logger, got: %s", out)
+	}
+}
@@ -2,9 +2,10 @@ package groupcachediscovery
 
 import (
 	"context"
-	"fmt"
+	"log/slog"
 	"net"
-	"os"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
@@ -12,6 +13,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/udhos/dogstatsdclient/dogstatsdclient"
 	"github.com/udhos/ecs-task-discovery/discovery"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // PeerGroup is an interface to plug in a target for delivering peering
@@ -63,10 +65,61 @@ type Options struct {
 	// Most applications should leave it undefined (set to false).
 	DisableAgentQuery bool
 
+	// AgentWatch enables long-polling the agent watch endpoint, so that
+	// membership changes are delivered as soon as the agent sees them.
+	// If undefined, retrieves value from env var ECS_TASK_DISCOVERY_AGENT_WATCH.
+	AgentWatch bool
+
+	// MaxShrinkFraction limits the fraction of peers removed in a single poll.
+	// See discovery.Options.
+	MaxShrinkFraction float64
+
+	// MaxShrinkCount limits the number of peers removed in a single poll.
+	// See discovery.Options.
+	MaxShrinkCount int
+
+	// ShrinkConfirmPolls is the number of consecutive polls required to
+	// confirm a shrink that exceeds the limits. See discovery.Options.
+	ShrinkConfirmPolls int
+
+	// RecordFile optionally records every poll for later replay. See discovery.Options.
+	RecordFile string
+
+	// RateLimiter optionally limits ECS API calls. See discovery.Options.
+	RateLimiter *discovery.RateLimiter
+
+	// HealthContainer optionally gates membership on the health of one named
+	// container, such as the groupcache-serving container. See discovery.Options.
+	HealthContainer string
+
+	// UnknownHealthGrace includes tasks with UNKNOWN health status younger
+	// than this age. See discovery.Options.
+	UnknownHealthGrace time.Duration
+
 	// MetricsNamespace provides optional namespace for prometheus metrics.
 	// Defaults to empty.
 	MetricsNamespace string
 
+	// Logger optionally provides structured logger. Defaults to slog.Default().
+	// It is also passed to discovery. Per-peer messages are logged at DEBUG level.
+	Logger *slog.Logger
+
+	// TaskSource optionally replaces both the agent query and the ECS API
+	// as the origin of tasks. See discovery.Options.
+	// For instance, discovery.FileSource simulates a multi-peer ring locally.
+	TaskSource discovery.TaskSource
+
+	// SelfAddress optionally forces our own address, instead of FindMyAddr().
+	// It is useful for running several peers locally, for instance on
+	// addresses 127.0.0.1, 127.0.0.2, and so on. It may carry a port, like
+	// 127.0.0.1:5001, to tell apart peers sharing an address that differ by
+	// Task.Port.
+	SelfAddress string
+
+	// TracerProvider optionally provides OpenTelemetry tracing for discovery.
+	// Defaults to the global provider.
+	TracerProvider trace.TracerProvider
+
 	// DogstatsdClient optionally sends metrics to Datadog Dogstatsd.
 	DogstatsdClient dogstatsdclient.DogstatsdClient
 
@@ -90,18 +143,33 @@ type Options struct {
 	AwsConfig *aws.Config
 
 	// TaskDefinitionHasHealthCheck determines how to check if task definition has health checks.
-	// Values: "Detect" (default), "DetectAndHandleErrorAsFalse", "True", "False".
+	// Values: "Detect" (default), "DetectAndHandleErrorAsFalse", "True", "False", "Revision".
+	// "Revision" judges each peer by the health checks of its own task definition revision,
+	// so a redeploy that adds or removes health checks is picked up without restarting.
+	// Revisions are looked up once and cached. Peers whose revision is unknown or
+	// fails to resolve fall back to the detection performed at startup.
+	// See discovery.HealthCheckModeRevision.
 	TaskDefinitionHasHealthCheck discovery.HealthCheckMode
 }
 
 // findMyAddrFunc is a test seam to allow deterministic local-address injection
 // in unit tests, especially for validating IsSelf mapping in peer callbacks.
-var findMyAddrFunc = FindMyAddr
+var findMyAddrFunc = findMyAddr
 
 func buildURL(addr, groupcachePort string) string {
 	return "http://" + addr + groupcachePort
 }
 
+// peerHostPort returns the groupcache peering host:port of a task:
+// the task port when defined, as by discovery.FileSource or DNS SRV
+// records, otherwise groupcachePort.
+func peerHostPort(t discovery.Task, groupcachePort string) string {
+	if t.Port != 0 {
+		return net.JoinHostPort(t.Address, strconv.Itoa(t.Port))
+	}
+	return t.Address + groupcachePort
+}
+
 // Discovery represents a groupcache discovery.
 type Discovery struct {
 	disc *discovery.Discovery
@@ -115,13 +183,6 @@ func (d *Discovery) Stop() {
 // New starts the discovery.
 func New(options Options) (*Discovery, error) {
 
-	const me = "groupcachediscovery.Run: callback"
-
-	myAddr, errAddr := findMyAddrFunc()
-	if errAddr != nil {
-		return nil, errAddr
-	}
-
 	m, errMetrics := newMetrics(options.MetricsNamespace,
 		options.MetricsRegisterer, options.DogstatsdClient,
 		options.DogstatsdExtraTags, options.EmfEnable,
@@ -131,11 +192,26 @@ func New(options Options) (*Discovery, error) {
 		return nil, errMetrics
 	}
 
+	logger := options.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	myAddr := options.SelfAddress
+	if myAddr == "" {
+		addr, errAddr := findMyAddrFunc(logger)
+		if errAddr != nil {
+			return nil, errAddr
+		}
+		myAddr = addr
+	}
+	logger = logger.With("service", options.ServiceName)
+
 	callback := func(tasks []discovery.Task) {
 
 		size := len(tasks)
 
-		infof("%s: service=%s tasks=%d", me, options.ServiceName, size)
+		logger.Info("groupcachediscovery: peers update", "count", size)
 
 		if size == 0 {
 			return
@@ -149,11 +225,12 @@ func New(options Options) (*Discovery, error) {
 			peers := make([]peer.Info, 0, size)
 
 			for i, t := range tasks {
-				hostPort := t.Address + options.GroupCachePort
-				isSelf := myAddr == t.Address
+				hostPort := peerHostPort(t, options.GroupCachePort)
+				isSelf := t.IsSelf // marked by discovery from task metadata or SelfAddress
 
-				infof("%s: %d/%d: service=%s task=%s addr=%s health_status=%s last_status=%s host_port=%s is_self=%t",
-					me, i+1, size, options.ServiceName, t.ARN, t.Address, t.HealthStatus, t.LastStatus, hostPort, isSelf)
+				logger.Debug("groupcachediscovery: peer", "index", i+1, "count", size,
+					"task", t.ARN, "address", t.Address, "health_status", t.HealthStatus,
+					"last_status", t.LastStatus, "host_port", hostPort, "is_self", isSelf)
 
 				peers = append(peers, peer.Info{
 					Address: hostPort,
@@ -163,7 +240,7 @@ func New(options Options) (*Discovery, error) {
 
 			err := options.Peers.SetPeers(context.TODO(), peers)
 			if err != nil {
-				errorf("%s: groupcache3 set peers error: %v", me, err)
+				logger.Error("groupcachediscovery: groupcache3 set peers", "error", err)
 			}
 		} else {
 			//
@@ -172,24 +249,41 @@ func New(options Options) (*Discovery, error) {
 			peers := make([]string, 0, size)
 
 			for i, t := range tasks {
-				infof("%s: %d/%d: service=%s task=%s addr=%s health_status=%s last_status=%s",
-					me, i+1, size, options.ServiceName, t.ARN, t.Address, t.HealthStatus, t.LastStatus)
+				hostPort := peerHostPort(t, options.GroupCachePort)
+
+				logger.Debug("groupcachediscovery: peer", "index", i+1, "count", size,
+					"task", t.ARN, "address", t.Address, "health_status", t.HealthStatus,
+					"last_status", t.LastStatus, "host_port", hostPort)
 
-				peers = append(peers, buildURL(t.Address, options.GroupCachePort))
+				peers = append(peers, "http://"+hostPort)
 			}
 
 			options.Pool.Set(peers...)
 		}
 
-		m.update(size) // update metrics
+		m.update(logger, size) // update metrics
 	}
 
 	disc, err := discovery.New(discovery.Options{
 		ServiceName:                  options.ServiceName,
 		Client:                       options.Client,
+		TaskSource:                   options.TaskSource,
 		Callback:                     callback,
 		ForceSingleTask:              options.ForceSingleTask,
 		DisableAgentQuery:            options.DisableAgentQuery,
+		AgentWatch:                   options.AgentWatch,
+		MaxShrinkFraction:            options.MaxShrinkFraction,
+		MaxShrinkCount:               options.MaxShrinkCount,
+		ShrinkConfirmPolls:           options.ShrinkConfirmPolls,
+		RecordFile:                   options.RecordFile,
+		RateLimiter:                  options.RateLimiter,
+		HealthContainer:              options.HealthContainer,
+		UnknownHealthGrace:           options.UnknownHealthGrace,
+		SelfAddress:                  myAddr, // never deliver peers excluding ourselves
+		MetricsRegisterer:            options.MetricsRegisterer,
+		MetricsNamespace:             options.MetricsNamespace,
+		Logger:                       options.Logger,
+		TracerProvider:               options.TracerProvider,
 		TaskDefinitionHasHealthCheck: options.TaskDefinitionHasHealthCheck,
 	})
 
@@ -213,23 +307,17 @@ func FindMyURL(groupcachePort string) (string, error) {
 }
 
 // FindMyAddr returns our local IP address.
+// It tries, in order: the ECS container metadata task network, local network
+// interfaces, and hostname resolution. See FindAddr and AddrOptions for
+// selecting addresses by CIDR or interface name with env vars.
+// The strategy that found the address is logged with slog.Default().
 func FindMyAddr() (string, error) {
-	const me = "FindMyAddr"
-	host, errHost := os.Hostname()
-	if errHost != nil {
-		return "", errHost
-	}
-	addrs, errAddr := net.LookupHost(host)
-	if errAddr != nil {
-		return "", errAddr
-	}
-	if len(addrs) < 1 {
-		return "", fmt.Errorf("%s: hostname '%s': no addr found", me, host)
-	}
-	addr := addrs[0]
-	if len(addrs) > 1 {
-		return addr, fmt.Errorf("%s: hostname '%s': found multiple addresses: %v",
-			me, host, addrs)
-	}
-	return addr, nil
+	return findMyAddr(slog.Default())
+}
+
+// findMyAddr is FindMyAddr logging to logger.
+func findMyAddr(logger *slog.Logger) (string, error) {
+	result, err := FindAddr(context.Background(), AddrOptions{})
+	logAddr(logger, result, err)
+	return result.Addr, err
 }
@@ -4,8 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
@@ -14,6 +17,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
 	"github.com/groupcache/groupcache-go/v3/transport/peer"
 	"github.com/udhos/ecs-task-discovery/discovery"
+	"github.com/udhos/ecs-task-discovery/discovery/discoverytest"
 )
 
 type capturePool struct {
@@ -78,7 +82,7 @@ func newAgentServer(t *testing.T, serviceName string, tasks []agentTask) *httpte
 func TestNewGroupcacheV2PoolSetReceivesExpectedURLs(t *testing.T) {
 	const myAddr = "10.0.0.10"
 	oldFindMyAddr := findMyAddrFunc
-	findMyAddrFunc = func() (string, error) { return myAddr, nil }
+	findMyAddrFunc = func(*slog.Logger) (string, error) { return myAddr, nil }
 	t.Cleanup(func() { findMyAddrFunc = oldFindMyAddr })
 
 	metadataServer := newMetadataServer(t, "arn:aws:ecs:us-east-1:111122223333:cluster/demo")
@@ -119,7 +123,7 @@ func TestNewGroupcacheV2PoolSetReceivesExpectedURLs(t *testing.T) {
 func TestNewGroupcacheV3SetPeersReceivesExpectedPeerInfo(t *testing.T) {
 	const myAddr = "10.0.0.10"
 	oldFindMyAddr := findMyAddrFunc
-	findMyAddrFunc = func() (string, error) { return myAddr, nil }
+	findMyAddrFunc = func(*slog.Logger) (string, error) { return myAddr, nil }
 	t.Cleanup(func() { findMyAddrFunc = oldFindMyAddr })
 
 	metadataServer := newMetadataServer(t, "arn:aws:ecs:us-east-1:111122223333:cluster/demo")
@@ -159,3 +163,128 @@ func TestNewGroupcacheV3SetPeersReceivesExpectedPeerInfo(t *testing.T) {
 		t.Fatal("timed out waiting for SetPeers callback")
 	}
 }
+
+func TestFindMyAddrMetadata(t *testing.T) {
+	server := discoverytest.NewMetadataServer(discoverytest.Task{
+		Containers: []discoverytest.Container{{Name: "app", IPv4Addresses: []string{"10.0.0.5"}}},
+	})
+	defer server.Close()
+	server.Setenv(t)
+
+	addr, err := FindMyAddr()
+	if err != nil {
+		t.Fatalf("FindMyAddr() error: %v", err)
+	}
+	if addr != "10.0.0.5" {
+		t.Errorf("expected metadata address 10.0.0.5, got %s", addr)
+	}
+}
+
+func TestNewFileSourceSelfAddress(t *testing.T) {
+	t.Setenv("ECS_CONTAINER_METADATA_URI_V4", "") // running locally
+	t.Setenv("ECS_CONTAINER_METADATA_URI", "")
+
+	path := filepath.Join(t.TempDir(), "tasks.yaml")
+	const tasks = "svc:\n  - address: 127.0.0.1\n  - address: 127.0.0.2\n"
+	if err := os.WriteFile(path, []byte(tasks), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	source, err := discovery.NewFileSource(discovery.FileSourceOptions{Path: path})
+	if err != nil {
+		t.Fatalf("NewFileSource() error: %v", err)
+	}
+
+	peerSet := &capturePeerSet{ch: make(chan []peer.Info, 1)}
+	d, err := New(Options{
+		Peers:          peerSet,
+		TaskSource:     source,
+		SelfAddress:    "127.0.0.2",
+		GroupCachePort: ":5000",
+		ServiceName:    "svc",
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer d.Stop()
+
+	select {
+	case got := <-peerSet.ch:
+		expected := []peer.Info{
+			{Address: "127.0.0.1:5000", IsSelf: false},
+			{Address: "127.0.0.2:5000", IsSelf: true},
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("SetPeers peer list mismatch: expected=%v got=%v", expected, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for SetPeers callback")
+	}
+}
+
+func TestNewFileSourceSelfAddressPort(t *testing.T) {
+	t.Setenv("ECS_CONTAINER_METADATA_URI_V4", "") // running locally
+	t.Setenv("ECS_CONTAINER_METADATA_URI", "")
+
+	path := filepath.Join(t.TempDir(), "tasks.yaml")
+	const tasks = "svc:\n  - {arn: task-1, address: 127.0.0.1, port: 5001}\n  - {arn: task-2, address: 127.0.0.1, port: 5002}\n"
+	if err := os.WriteFile(path, []byte(tasks), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	source, err := discovery.NewFileSource(discovery.FileSourceOptions{Path: path})
+	if err != nil {
+		t.Fatalf("NewFileSource() error: %v", err)
+	}
+
+	t.Run("groupcache3", func(t *testing.T) {
+		peerSet := &capturePeerSet{ch: make(chan []peer.Info, 1)}
+		d, err := New(Options{
+			Peers:          peerSet,
+			TaskSource:     source,
+			SelfAddress:    "127.0.0.1:5002",
+			GroupCachePort: ":5000",
+			ServiceName:    "svc",
+		})
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
+		}
+		defer d.Stop()
+
+		select {
+		case got := <-peerSet.ch:
+			expected := []peer.Info{
+				{Address: "127.0.0.1:5001", IsSelf: false},
+				{Address: "127.0.0.1:5002", IsSelf: true},
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Fatalf("SetPeers peer list mismatch: expected=%v got=%v", expected, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for SetPeers callback")
+		}
+	})
+
+	t.Run("groupcache2", func(t *testing.T) {
+		pool := &capturePool{ch: make(chan []string, 1)}
+		d, err := New(Options{
+			Pool:           pool,
+			TaskSource:     source,
+			SelfAddress:    "127.0.0.1:5001",
+			GroupCachePort: ":5000",
+			ServiceName:    "svc",
+		})
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
+		}
+		defer d.Stop()
+
+		select {
+		case got := <-pool.ch:
+			expected := []string{"http://127.0.0.1:5001", "http://127.0.0.1:5002"}
+			if !reflect.DeepEqual(got, expected) {
+				t.Fatalf("pool peer list mismatch: expected=%v got=%v", expected, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for pool callback")
+		}
+	})
+}
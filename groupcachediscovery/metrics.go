@@ -39,7 +39,7 @@ var (
 	metricPeers  = emf.MetricDefinition{Name: "peers", Unit: "Count"}
 )
 
-func (m *metrics) update(peers int) {
+func (m *metrics) update(logger *slog.Logger, peers int) {
 
 	peersFloat64 := float64(peers)
 
@@ -58,10 +58,10 @@ func (m *metrics) update(peers int) {
 	//
 	if m.dogstatsdClient != nil {
 		if err := m.dogstatsdClient.Count("events", 1, m.extraTags, m.sampleRate); err != nil {
-			slog.Error(fmt.Sprintf("metrics.update: Count error: %v", err))
+			logger.Error("metrics.update: Count", "error", err)
 		}
 		if err := m.dogstatsdClient.Gauge("peers", peersFloat64, m.extraTags, m.sampleRate); err != nil {
-			slog.Error(fmt.Sprintf("metrics.update: Gauge error: %v", err))
+			logger.Error("metrics.update: Gauge", "error", err)
 		}
 	}
 
@@ -82,7 +82,7 @@ func (m *metrics) update(peers int) {
 			// send metrics to cloudwatch logs
 			events := m.metricContext.CloudWatchLogEvents()
 			if err := m.cwlogClient.PutLogEvents(events); err != nil {
-				slog.Error(fmt.Sprintf("metrics.update export error: %v", err))
+				logger.Error("metrics.update: export", "error", err)
 			}
 		}
 	}
@@ -0,0 +1,164 @@
+// Package tlsreload loads TLS certificates and CA bundles from files,
+// reloading them when files change.
+package tlsreload
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// checkInterval limits how often files are checked for changes.
+var checkInterval = 5 * time.Second
+
+// fileVersion identifies a file revision by modification time and size.
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(name string) (fileVersion, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return fileVersion{}, err
+	}
+	return fileVersion{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// watched holds a value loaded from files, reloading it when any file changes.
+type watched[T any] struct {
+	files     []string
+	load      func() (T, error)
+	mu        sync.Mutex
+	value     T
+	versions  []fileVersion
+	lastCheck time.Time
+}
+
+func newWatched[T any](load func() (T, error), files ...string) (*watched[T], error) {
+	w := &watched[T]{files: files, load: load}
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+	w.lastCheck = time.Now()
+	return w, nil
+}
+
+func (w *watched[T]) reload() error {
+	versions := make([]fileVersion, 0, len(w.files))
+	for _, f := range w.files {
+		v, err := statFile(f)
+		if err != nil {
+			return err
+		}
+		versions = append(versions, v)
+	}
+	value, err := w.load()
+	if err != nil {
+		return err
+	}
+	w.value = value
+	w.versions = versions
+	return nil
+}
+
+func (w *watched[T]) changed() bool {
+	for i, f := range w.files {
+		v, err := statFile(f)
+		if err != nil || v != w.versions[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns current value, reloading it if files changed.
+// If reloading fails, the previous value is kept.
+func (w *watched[T]) get() T {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if time.Since(w.lastCheck) < checkInterval {
+		return w.value
+	}
+	w.lastCheck = time.Now()
+	if w.changed() {
+		if err := w.reload(); err != nil {
+			slog.Error(fmt.Sprintf("tlsreload: files=%v: reload error, keeping previous: %v", w.files, err))
+		} else {
+			slog.Info(fmt.Sprintf("tlsreload: files=%v: reloaded", w.files))
+		}
+	}
+	return w.value
+}
+
+// Certificate is a certificate/key pair reloaded on change.
+type Certificate struct {
+	w *watched[*tls.Certificate]
+}
+
+// NewCertificate loads a PEM certificate/key pair.
+func NewCertificate(certFile, keyFile string) (*Certificate, error) {
+	load := func() (*tls.Certificate, error) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load certificate: cert=%s key=%s: %w", certFile, keyFile, err)
+		}
+		return &cert, nil
+	}
+	w, err := newWatched(load, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Certificate{w: w}, nil
+}
+
+// Get returns current certificate.
+func (c *Certificate) Get() *tls.Certificate {
+	return c.w.get()
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (c *Certificate) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.Get(), nil
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate.
+func (c *Certificate) GetClientCertificate(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return c.Get(), nil
+}
+
+// CertPool is a PEM CA bundle reloaded on change.
+type CertPool struct {
+	w *watched[*x509.CertPool]
+}
+
+// LoadCertPool reads a PEM CA bundle.
+func LoadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("load CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("load CA bundle: %s: no certificate found", caFile)
+	}
+	return pool, nil
+}
+
+// NewCertPool loads a PEM CA bundle.
+func NewCertPool(caFile string) (*CertPool, error) {
+	w, err := newWatched(func() (*x509.CertPool, error) { return LoadCertPool(caFile) }, caFile)
+	if err != nil {
+		return nil, err
+	}
+	return &CertPool{w: w}, nil
+}
+
+// Get returns current pool.
+func (p *CertPool) Get() *x509.CertPool {
+	return p.w.get()
+}
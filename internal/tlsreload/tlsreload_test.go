@@ -0,0 +1,87 @@
+package tlsreload
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/udhos/ecs-task-discovery/internal/testcert"
+)
+
+func TestCertificateReloadsOnChange(t *testing.T) {
+	oldInterval := checkInterval
+	checkInterval = 0
+	t.Cleanup(func() { checkInterval = oldInterval })
+
+	dir := t.TempDir()
+	ca := testcert.NewCA(t, dir, "ca")
+	certFile, keyFile := ca.Issue(t, dir, "server")
+
+	cert, err := NewCertificate(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertificate() error: %v", err)
+	}
+
+	first := cert.Get().Certificate[0]
+
+	// issue a new pair into other files, then replace the watched ones
+	newCert, newKey := ca.Issue(t, dir, "server2")
+	replaceFile(t, newCert, certFile)
+	replaceFile(t, newKey, keyFile)
+
+	second := cert.Get().Certificate[0]
+
+	if bytes.Equal(first, second) {
+		t.Fatal("expected certificate to be reloaded after files changed")
+	}
+}
+
+func TestCertificateKeepsPreviousOnBrokenFiles(t *testing.T) {
+	oldInterval := checkInterval
+	checkInterval = 0
+	t.Cleanup(func() { checkInterval = oldInterval })
+
+	dir := t.TempDir()
+	ca := testcert.NewCA(t, dir, "ca")
+	certFile, keyFile := ca.Issue(t, dir, "server")
+
+	cert, err := NewCertificate(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertificate() error: %v", err)
+	}
+
+	if err := os.WriteFile(certFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if cert.Get() == nil {
+		t.Fatal("expected previous certificate to be kept")
+	}
+}
+
+func TestNewCertPoolInvalid(t *testing.T) {
+	file := t.TempDir() + "/ca.pem"
+	if err := os.WriteFile(file, []byte("not a pem"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCertPool(file); err == nil {
+		t.Fatal("expected error for invalid CA bundle, got nil")
+	}
+}
+
+// replaceFile copies src over dst, making sure modification time changes.
+func replaceFile(t *testing.T, src, dst string) {
+	t.Helper()
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(dst, future, future); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,247 @@
+// Package leader elects a leader task from discovered membership.
+//
+// Every task of a service sees the same task list from discovery, so every
+// task elects the same leader without talking to the others. Election is
+// eventually consistent: while tasks see different snapshots, for instance
+// during a deployment, more than one task may briefly believe it leads.
+// Jobs run by the leader should tolerate that.
+package leader
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/udhos/ecs-task-discovery/discovery"
+)
+
+// Options define settings for creating an Elector.
+type Options struct {
+	// SelfAddress is the address of our own task, used to tell whether we lead
+	// when discovery has not marked our own task as Task.IsSelf.
+	// It may carry a port, like 10.0.0.1:5000, to tell apart tasks sharing
+	// an address: when both SelfAddress and the task carry a port, ports
+	// must match too. Required.
+	SelfAddress string
+
+	// StabilityDelay is how long a new candidate must remain elected before
+	// leadership moves to it, so that membership flapping does not flip
+	// leadership. It also applies when the leader disappears.
+	// The first leader is elected immediately. Defaults to 0 (no delay).
+	StabilityDelay time.Duration
+
+	// Eligible optionally selects candidate tasks.
+	// Defaults to tasks that are not UNHEALTHY and whose last status, if known, is RUNNING.
+	Eligible func(discovery.Task) bool
+
+	// OnChange is optionally called when leadership changes.
+	// Calls are serialized and delivered in order.
+	// It may call IsLeader and Leader, but must not call Update.
+	OnChange func(Change)
+}
+
+// Change describes a leadership change.
+type Change struct {
+	// Leader is the new leader. It is the zero Task when there is no leader.
+	Leader discovery.Task
+
+	// HasLeader reports whether there is a leader.
+	HasLeader bool
+
+	// IsLeader reports whether our own task is the new leader.
+	IsLeader bool
+}
+
+// Elector elects the eligible task with the lowest ARN as leader.
+// It is safe for concurrent use.
+type Elector struct {
+	options Options
+
+	mu        sync.Mutex
+	leader    discovery.Task
+	hasLeader bool
+	elected   bool // whether any election happened yet
+	candidate discovery.Task
+	hasCand   bool
+	selfKnown bool // last update marked our own task with IsSelf
+	timer     *time.Timer
+	pending   uint64 // generation of timer, so that a stale timer does nothing
+	stopped   bool
+
+	notifyMu sync.Mutex // serializes OnChange
+}
+
+// New creates an Elector.
+func New(options Options) (*Elector, error) {
+	if options.SelfAddress == "" {
+		return nil, errors.New("option SelfAddress is required")
+	}
+	if options.Eligible == nil {
+		options.Eligible = eligible
+	}
+	return &Elector{options: options}, nil
+}
+
+// eligible is the default candidate filter.
+func eligible(t discovery.Task) bool {
+	if t.HealthStatus == string(types.HealthStatusUnhealthy) {
+		return false
+	}
+	return t.LastStatus == "" || t.LastStatus == string(types.DesiredStatusRunning)
+}
+
+// Update elects a leader from tasks.
+// It can be used directly as discovery callback, or called from it.
+func (e *Elector) Update(tasks []discovery.Task) {
+	candidate, found := e.elect(tasks)
+	selfKnown := slices.ContainsFunc(tasks, func(t discovery.Task) bool { return t.IsSelf })
+
+	e.mu.Lock()
+
+	if e.stopped {
+		e.mu.Unlock()
+		return
+	}
+
+	e.selfKnown = selfKnown
+
+	e.candidate, e.hasCand = candidate, found
+
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+		e.pending++
+	}
+
+	if e.elected && sameLeader(e.leader, e.hasLeader, candidate, found) {
+		e.leader = candidate // refresh attributes
+		e.mu.Unlock()
+		return // nothing pending
+	}
+
+	if !e.elected || e.options.StabilityDelay <= 0 {
+		e.adopt() // unlocks
+		return
+	}
+
+	// wait for candidate to remain elected
+	e.pending++
+	generation := e.pending
+	e.timer = time.AfterFunc(e.options.StabilityDelay, func() {
+		e.mu.Lock()
+		if e.stopped || e.pending != generation {
+			e.mu.Unlock()
+			return
+		}
+		e.timer = nil
+		e.adopt() // unlocks
+	})
+
+	e.mu.Unlock()
+}
+
+// adopt makes the candidate the leader, then notifies OnChange.
+// It is called with e.mu held, and releases it.
+func (e *Elector) adopt() {
+	changed := !e.elected || !sameLeader(e.leader, e.hasLeader, e.candidate, e.hasCand)
+	e.elected = true
+	e.leader, e.hasLeader = e.candidate, e.hasCand
+
+	if !changed || e.options.OnChange == nil {
+		e.mu.Unlock()
+		return
+	}
+
+	change := Change{
+		Leader:    e.leader,
+		HasLeader: e.hasLeader,
+		IsLeader:  e.isLeader(),
+	}
+
+	// hold notifyMu before releasing mu, so that notifications keep order
+	e.notifyMu.Lock()
+	e.mu.Unlock()
+	defer e.notifyMu.Unlock()
+
+	e.options.OnChange(change)
+}
+
+// elect returns the eligible task with the lowest ARN.
+func (e *Elector) elect(tasks []discovery.Task) (discovery.Task, bool) {
+	var leader discovery.Task
+	var found bool
+	for _, t := range tasks {
+		if !e.options.Eligible(t) {
+			continue
+		}
+		if !found || t.ARN < leader.ARN {
+			leader, found = t, true
+		}
+	}
+	return leader, found
+}
+
+func sameLeader(a discovery.Task, hasA bool, b discovery.Task, hasB bool) bool {
+	if hasA != hasB {
+		return false
+	}
+	return !hasA || (a.ARN == b.ARN && a.Address == b.Address && a.Port == b.Port && a.IsSelf == b.IsSelf)
+}
+
+// isLeader reports whether the leader is our own task. It trusts IsSelf
+// when discovery marks our own task, since several tasks may share an
+// address. Otherwise it matches SelfAddress.
+func (e *Elector) isLeader() bool {
+	if !e.hasLeader {
+		return false
+	}
+	if e.selfKnown {
+		return e.leader.IsSelf
+	}
+	host, port := splitSelfAddress(e.options.SelfAddress)
+	return e.leader.Address == host && (port == 0 || e.leader.Port == 0 || e.leader.Port == port)
+}
+
+// splitSelfAddress splits SelfAddress into address and optional port.
+// A bare IPv6 address has no port.
+func splitSelfAddress(addr string) (string, int) {
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, 0
+	}
+	port, errPort := strconv.Atoi(p)
+	if errPort != nil {
+		return addr, 0
+	}
+	return host, port
+}
+
+// IsLeader reports whether our own task is the leader.
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.isLeader()
+}
+
+// Leader returns the current leader.
+// It returns false if there is no leader.
+func (e *Elector) Leader() (discovery.Task, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader, e.hasLeader
+}
+
+// Stop cancels any pending leadership change. Later updates are ignored.
+func (e *Elector) Stop() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stopped = true
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+}
@@ -0,0 +1,217 @@
+package leader
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/udhos/ecs-task-discovery/discovery"
+)
+
+var (
+	taskA = discovery.Task{ARN: "arn:task:a", Address: "10.0.0.1", HealthStatus: "HEALTHY", LastStatus: "RUNNING"}
+	taskB = discovery.Task{ARN: "arn:task:b", Address: "10.0.0.2", HealthStatus: "HEALTHY", LastStatus: "RUNNING"}
+	taskC = discovery.Task{ARN: "arn:task:c", Address: "10.0.0.3", HealthStatus: "HEALTHY", LastStatus: "RUNNING"}
+)
+
+// changes records leadership changes.
+type changes struct {
+	mu   sync.Mutex
+	list []Change
+}
+
+func (c *changes) add(change Change) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.list = append(c.list, change)
+}
+
+func (c *changes) get() []Change {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Change(nil), c.list...)
+}
+
+func TestNewRequiresSelf(t *testing.T) {
+	if _, err := New(Options{}); err == nil {
+		t.Fatal("expected error for missing SelfAddress")
+	}
+}
+
+func TestLowestARN(t *testing.T) {
+	e, err := New(Options{SelfAddress: taskB.Address})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	e.Update([]discovery.Task{taskC, taskB, taskA})
+
+	leader, found := e.Leader()
+	if !found || leader.ARN != taskA.ARN {
+		t.Fatalf("expected leader %s, got %+v", taskA.ARN, leader)
+	}
+	if e.IsLeader() {
+		t.Fatal("expected self not to be leader")
+	}
+
+	// leader leaves, self is next
+	e.Update([]discovery.Task{taskC, taskB})
+	if !e.IsLeader() {
+		t.Fatal("expected self to be leader")
+	}
+}
+
+func TestEligible(t *testing.T) {
+	unhealthy := taskA
+	unhealthy.HealthStatus = "UNHEALTHY"
+
+	stopping := taskB
+	stopping.LastStatus = "DEACTIVATING"
+
+	e, _ := New(Options{SelfAddress: taskC.Address})
+	e.Update([]discovery.Task{unhealthy, stopping, taskC})
+
+	if !e.IsLeader() {
+		leader, _ := e.Leader()
+		t.Fatalf("expected only eligible task to lead, got %+v", leader)
+	}
+
+	// custom filter
+	e, _ = New(Options{
+		SelfAddress: taskC.Address,
+		Eligible:    func(t discovery.Task) bool { return t.ARN != taskA.ARN },
+	})
+	e.Update([]discovery.Task{taskA, taskB, taskC})
+	if leader, _ := e.Leader(); leader.ARN != taskB.ARN {
+		t.Fatalf("expected custom filter to skip %s, got %+v", taskA.ARN, leader)
+	}
+
+	// no eligible task
+	e.Update([]discovery.Task{taskA})
+	if _, found := e.Leader(); found {
+		t.Fatal("expected no leader")
+	}
+}
+
+func TestOnChange(t *testing.T) {
+	var c changes
+
+	e, _ := New(Options{SelfAddress: taskA.Address, OnChange: c.add})
+
+	e.Update([]discovery.Task{taskA, taskB})
+	e.Update([]discovery.Task{taskB, taskA, taskC}) // same leader, no change
+	e.Update([]discovery.Task{taskB, taskC})
+
+	got := c.get()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 changes, got %+v", got)
+	}
+	if !got[0].IsLeader || got[0].Leader.ARN != taskA.ARN {
+		t.Errorf("first change: expected self to lead, got %+v", got[0])
+	}
+	if got[1].IsLeader || !got[1].HasLeader || got[1].Leader.ARN != taskB.ARN {
+		t.Errorf("second change: expected %s to lead, got %+v", taskB.ARN, got[1])
+	}
+}
+
+func TestStabilityDelay(t *testing.T) {
+	const delay = 100 * time.Millisecond
+
+	var c changes
+
+	e, _ := New(Options{SelfAddress: taskB.Address, StabilityDelay: delay, OnChange: c.add})
+	defer e.Stop()
+
+	// first leader is elected immediately
+	e.Update([]discovery.Task{taskA, taskB})
+	if leader, _ := e.Leader(); leader.ARN != taskA.ARN {
+		t.Fatalf("expected immediate first election of %s, got %+v", taskA.ARN, leader)
+	}
+
+	// flapping: leader leaves and comes back within delay
+	for range 3 {
+		e.Update([]discovery.Task{taskB})
+		time.Sleep(delay / 4)
+		e.Update([]discovery.Task{taskA, taskB})
+		time.Sleep(delay / 4)
+	}
+	time.Sleep(2 * delay)
+
+	if e.IsLeader() {
+		t.Fatal("flapping should not move leadership")
+	}
+	if got := c.get(); len(got) != 1 {
+		t.Fatalf("expected only first election, got %+v", got)
+	}
+
+	// leader leaves for good: leadership moves after delay, even without new snapshots
+	e.Update([]discovery.Task{taskB})
+	if e.IsLeader() {
+		t.Fatal("leadership moved before stability delay")
+	}
+	time.Sleep(2 * delay)
+	if !e.IsLeader() {
+		t.Fatal("expected leadership to move after stability delay")
+	}
+	if got := c.get(); len(got) != 2 || !got[1].IsLeader {
+		t.Fatalf("expected second change making self leader, got %+v", got)
+	}
+}
+
+func TestStop(t *testing.T) {
+	var c changes
+
+	e, _ := New(Options{SelfAddress: taskB.Address, StabilityDelay: 50 * time.Millisecond, OnChange: c.add})
+
+	e.Update([]discovery.Task{taskA, taskB})
+	e.Update([]discovery.Task{taskB})
+	e.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	if e.IsLeader() {
+		t.Fatal("pending change should be canceled by Stop")
+	}
+	if got := c.get(); len(got) != 1 {
+		t.Fatalf("expected only first election, got %+v", got)
+	}
+}
+
+func TestSharedAddressSelfPort(t *testing.T) {
+	first := discovery.Task{ARN: "arn:task:a", Address: "10.0.0.1", Port: 5001}
+	second := discovery.Task{ARN: "arn:task:b", Address: "10.0.0.1", Port: 5002}
+
+	e, _ := New(Options{SelfAddress: "10.0.0.1:5002"})
+	e.Update([]discovery.Task{first, second})
+	if e.IsLeader() {
+		t.Fatal("expected task sharing leader address but not port not to lead")
+	}
+
+	e, _ = New(Options{SelfAddress: "10.0.0.1:5001"})
+	e.Update([]discovery.Task{first, second})
+	if !e.IsLeader() {
+		t.Fatal("expected self to lead by address and port")
+	}
+}
+
+func TestSharedAddressIsSelf(t *testing.T) {
+	first := discovery.Task{ARN: "arn:task:a", Address: "10.0.0.1"}
+	second := discovery.Task{ARN: "arn:task:b", Address: "10.0.0.1"}
+
+	// SelfAddress alone cannot tell the tasks apart, IsSelf does
+	e, _ := New(Options{SelfAddress: "10.0.0.1"})
+
+	self := second
+	self.IsSelf = true
+	e.Update([]discovery.Task{first, self})
+	if e.IsLeader() {
+		t.Fatal("expected task sharing leader address not to lead")
+	}
+
+	self = first
+	self.IsSelf = true
+	e.Update([]discovery.Task{self, second})
+	if !e.IsLeader() {
+		t.Fatal("expected task marked IsSelf to lead")
+	}
+}
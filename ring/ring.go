@@ -0,0 +1,224 @@
+// Package ring maps keys to discovered tasks with consistent hashing.
+package ring
+
+import (
+	"cmp"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/udhos/ecs-task-discovery/discovery"
+)
+
+// Options define settings for creating a Ring.
+type Options struct {
+	// VirtualNodes is the number of points placed on the ring per unit of weight.
+	// More points spread keys more evenly, at the cost of memory and update time.
+	// Defaults to 100.
+	VirtualNodes int
+
+	// Weight optionally returns the relative weight of a task.
+	// A task with weight 2 receives about twice the keys of a task with weight 1.
+	// Values below 1 are treated as 1. Defaults to 1 for all tasks.
+	Weight func(discovery.Task) int
+
+	// ZoneAware makes Owners prefer tasks in distinct availability zones,
+	// so that replicas of a key survive the loss of a zone.
+	// Zones come from Task.AvailabilityZone. Owner is not affected.
+	ZoneAware bool
+
+	// Hash optionally hashes keys and virtual nodes.
+	// Defaults to 64-bit FNV-1a with a final mixing step.
+	Hash func([]byte) uint64
+}
+
+// Ring is a consistent-hash ring of tasks.
+// Each task is identified by its address (and port, if any), and its
+// virtual nodes depend only on that identity, so that adding or removing
+// a task only remaps the keys owned by that task.
+// It is safe for concurrent use. Lookups do not block updates.
+type Ring struct {
+	options Options
+	state   atomic.Pointer[state]
+}
+
+// state is an immutable snapshot of the ring.
+type state struct {
+	points  []point
+	members []discovery.Task
+}
+
+// point is a virtual node.
+type point struct {
+	hash   uint64
+	member int // index into members
+}
+
+// New creates an empty Ring.
+func New(options Options) *Ring {
+	if options.VirtualNodes < 1 {
+		options.VirtualNodes = 100
+	}
+	if options.Hash == nil {
+		options.Hash = defaultHash
+	}
+	r := &Ring{options: options}
+	r.state.Store(&state{})
+	return r
+}
+
+// Update replaces the ring members with tasks.
+// Tasks with duplicate identity are ignored, keeping the first one.
+// It can be used directly as discovery callback:
+//
+//	r := ring.New(ring.Options{})
+//	disc, err := discovery.New(discovery.Options{Callback: r.Update, ...})
+func (r *Ring) Update(tasks []discovery.Task) {
+	s := &state{}
+	seen := map[string]bool{}
+
+	for _, t := range tasks {
+		id := identity(t)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		member := len(s.members)
+		s.members = append(s.members, t)
+
+		weight := 1
+		if r.options.Weight != nil {
+			weight = max(r.options.Weight(t), 1)
+		}
+
+		buf := []byte(id + "#")
+		prefix := len(buf)
+		for i := range weight * r.options.VirtualNodes {
+			buf = strconv.AppendInt(buf[:prefix], int64(i), 10)
+			s.points = append(s.points, point{hash: r.options.Hash(buf), member: member})
+		}
+	}
+
+	slices.SortFunc(s.points, func(a, b point) int {
+		if c := cmp.Compare(a.hash, b.hash); c != 0 {
+			return c
+		}
+		// break ties deterministically
+		return strings.Compare(identity(s.members[a.member]), identity(s.members[b.member]))
+	})
+
+	r.state.Store(s)
+}
+
+// Members returns the current ring members, in the order they were given to Update.
+func (r *Ring) Members() []discovery.Task {
+	return slices.Clone(r.state.Load().members)
+}
+
+// Len returns the number of ring members.
+func (r *Ring) Len() int {
+	return len(r.state.Load().members)
+}
+
+// Owner returns the task that owns key.
+// It returns false if the ring is empty.
+func (r *Ring) Owner(key string) (discovery.Task, bool) {
+	s := r.state.Load()
+	if len(s.points) == 0 {
+		return discovery.Task{}, false
+	}
+	i := s.search(r.options.Hash([]byte(key)))
+	return s.members[s.points[i].member], true
+}
+
+// Owners returns up to n distinct tasks responsible for key, in preference
+// order. The first one is the Owner. With ZoneAware, tasks in zones not
+// yet picked are preferred, then remaining tasks fill up to n.
+func (r *Ring) Owners(key string, n int) []discovery.Task {
+	s := r.state.Load()
+	if n < 1 || len(s.points) == 0 {
+		return nil
+	}
+	n = min(n, len(s.members))
+
+	start := s.search(r.options.Hash([]byte(key)))
+
+	picked := make([]bool, len(s.members))
+	var order []int // distinct members in ring order
+
+	if !r.options.ZoneAware {
+		for i := 0; i < len(s.points) && len(order) < n; i++ {
+			m := s.points[(start+i)%len(s.points)].member
+			if !picked[m] {
+				picked[m] = true
+				order = append(order, m)
+			}
+		}
+		return s.tasks(order)
+	}
+
+	// zone aware: walk until n members in distinct zones, or all members seen
+	zones := map[string]bool{}
+	var spread, rest []int
+	for i := 0; i < len(s.points) && len(spread) < n && len(spread)+len(rest) < len(s.members); i++ {
+		m := s.points[(start+i)%len(s.points)].member
+		if picked[m] {
+			continue
+		}
+		picked[m] = true
+		zone := s.members[m].AvailabilityZone
+		if zones[zone] {
+			rest = append(rest, m)
+			continue
+		}
+		zones[zone] = true
+		spread = append(spread, m)
+	}
+
+	order = append(spread, rest...)
+	return s.tasks(order[:min(n, len(order))])
+}
+
+// search returns the index of the first point at or after hash, wrapping around.
+func (s *state) search(hash uint64) int {
+	i, _ := slices.BinarySearchFunc(s.points, hash, func(p point, h uint64) int {
+		return cmp.Compare(p.hash, h)
+	})
+	if i == len(s.points) {
+		return 0
+	}
+	return i
+}
+
+func (s *state) tasks(members []int) []discovery.Task {
+	tasks := make([]discovery.Task, 0, len(members))
+	for _, m := range members {
+		tasks = append(tasks, s.members[m])
+	}
+	return tasks
+}
+
+// identity returns the ring identity of a task: its address, and port, if any.
+func identity(t discovery.Task) string {
+	if t.Port == 0 {
+		return t.Address
+	}
+	return t.Address + ":" + strconv.Itoa(t.Port)
+}
+
+// defaultHash is FNV-1a followed by the splitmix64 finalizer,
+// which spreads the similar inputs of virtual nodes across the ring.
+func defaultHash(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
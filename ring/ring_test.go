@@ -0,0 +1,255 @@
+package ring
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/udhos/ecs-task-discovery/discovery"
+)
+
+func makeTasks(n int) []discovery.Task {
+	tasks := make([]discovery.Task, 0, n)
+	for i := range n {
+		tasks = append(tasks, discovery.Task{
+			ARN:     fmt.Sprintf("arn:task:%d", i),
+			Address: fmt.Sprintf("10.0.0.%d", i+1),
+		})
+	}
+	return tasks
+}
+
+func owners(r *Ring, keys int) map[string]string {
+	result := map[string]string{}
+	for i := range keys {
+		key := fmt.Sprintf("key-%d", i)
+		t, _ := r.Owner(key)
+		result[key] = t.Address
+	}
+	return result
+}
+
+func TestEmptyRing(t *testing.T) {
+	r := New(Options{})
+
+	if _, found := r.Owner("key"); found {
+		t.Fatal("expected no owner on empty ring")
+	}
+	if got := r.Owners("key", 3); len(got) != 0 {
+		t.Fatalf("expected no owners on empty ring, got %v", got)
+	}
+
+	r.Update(makeTasks(3))
+	r.Update(nil)
+	if _, found := r.Owner("key"); found {
+		t.Fatal("expected no owner after removing all tasks")
+	}
+}
+
+func TestBalance(t *testing.T) {
+	const (
+		members = 10
+		keys    = 100000
+	)
+
+	r := New(Options{})
+	r.Update(makeTasks(members))
+
+	count := map[string]int{}
+	for _, addr := range owners(r, keys) {
+		count[addr]++
+	}
+
+	if len(count) != members {
+		t.Fatalf("expected keys spread over %d members, got %d", members, len(count))
+	}
+	expected := float64(keys) / members
+	for addr, c := range count {
+		if math.Abs(float64(c)-expected) > 0.25*expected {
+			t.Errorf("member %s owns %d keys, expected about %.0f", addr, c, expected)
+		}
+	}
+}
+
+func TestMinimalRemapping(t *testing.T) {
+	const keys = 20000
+
+	tasks := makeTasks(10)
+
+	r := New(Options{})
+	r.Update(tasks)
+	before := owners(r, keys)
+
+	// add a member: keys only move to it
+	added := append(tasks, discovery.Task{ARN: "arn:task:new", Address: "10.0.1.1"})
+	r.Update(added)
+	after := owners(r, keys)
+
+	var moved int
+	for key, addr := range after {
+		if addr != before[key] {
+			moved++
+			if addr != "10.0.1.1" {
+				t.Fatalf("key %s moved from %s to existing member %s", key, before[key], addr)
+			}
+		}
+	}
+	if limit := 2 * keys / len(added); moved == 0 || moved > limit {
+		t.Errorf("expected about %d keys to move, got %d", keys/len(added), moved)
+	}
+
+	// remove the member: keys return to their previous owners
+	r.Update(tasks)
+	for key, addr := range owners(r, keys) {
+		if addr != before[key] {
+			t.Fatalf("key %s: expected owner %s after removal, got %s", key, before[key], addr)
+		}
+	}
+
+	// removing another member only remaps its keys; order does not matter
+	r.Update(append([]discovery.Task{tasks[9]}, tasks[1:9]...))
+	for key, addr := range owners(r, keys) {
+		if before[key] != tasks[0].Address && addr != before[key] {
+			t.Fatalf("key %s moved from %s to %s, but its owner was not removed", key, before[key], addr)
+		}
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	tasks := makeTasks(5)
+
+	r1 := New(Options{})
+	r1.Update(tasks)
+
+	r2 := New(Options{})
+	r2.Update([]discovery.Task{tasks[4], tasks[2], tasks[0], tasks[3], tasks[1]})
+
+	o1, o2 := owners(r1, 1000), owners(r2, 1000)
+	for key := range o1 {
+		if o1[key] != o2[key] {
+			t.Fatalf("key %s: owners differ across rings: %s %s", key, o1[key], o2[key])
+		}
+	}
+}
+
+func TestWeights(t *testing.T) {
+	const keys = 100000
+
+	tasks := makeTasks(3)
+
+	r := New(Options{
+		Weight: func(t discovery.Task) int {
+			if t.Address == "10.0.0.1" {
+				return 2
+			}
+			return 1
+		},
+	})
+	r.Update(tasks)
+
+	count := map[string]int{}
+	for _, addr := range owners(r, keys) {
+		count[addr]++
+	}
+
+	share := float64(count["10.0.0.1"]) / keys
+	if math.Abs(share-0.5) > 0.05 {
+		t.Errorf("expected weight 2 member to own about half the keys, got %.2f", share)
+	}
+}
+
+func TestOwners(t *testing.T) {
+	r := New(Options{})
+	r.Update(append(makeTasks(5), makeTasks(5)...)) // duplicates are ignored
+
+	if r.Len() != 5 {
+		t.Fatalf("expected 5 members, got %d", r.Len())
+	}
+
+	for i := range 100 {
+		key := fmt.Sprintf("key-%d", i)
+		owner, _ := r.Owner(key)
+		got := r.Owners(key, 3)
+		if len(got) != 3 {
+			t.Fatalf("expected 3 owners, got %d", len(got))
+		}
+		if got[0].Address != owner.Address {
+			t.Fatalf("key %s: first owner %s differs from owner %s", key, got[0].Address, owner.Address)
+		}
+		seen := map[string]bool{}
+		for _, o := range got {
+			if seen[o.Address] {
+				t.Fatalf("key %s: duplicate owner %s", key, o.Address)
+			}
+			seen[o.Address] = true
+		}
+	}
+
+	if got := r.Owners("key", 10); len(got) != 5 {
+		t.Fatalf("expected owners capped to 5 members, got %d", len(got))
+	}
+}
+
+func TestOwnersZoneAware(t *testing.T) {
+	tasks := makeTasks(9)
+	zones := []string{"us-east-1a", "us-east-1b", "us-east-1c"}
+	for i := range tasks {
+		tasks[i].AvailabilityZone = zones[i%len(zones)]
+	}
+
+	r := New(Options{ZoneAware: true})
+	r.Update(tasks)
+
+	for i := range 100 {
+		key := fmt.Sprintf("key-%d", i)
+
+		owner, _ := r.Owner(key)
+		got := r.Owners(key, 3)
+		if got[0].Address != owner.Address {
+			t.Fatalf("key %s: first owner %s differs from owner %s", key, got[0].Address, owner.Address)
+		}
+
+		seen := map[string]bool{}
+		for _, o := range got {
+			if seen[o.AvailabilityZone] {
+				t.Fatalf("key %s: owners share zone %s: %v", key, o.AvailabilityZone, got)
+			}
+			seen[o.AvailabilityZone] = true
+		}
+
+		// beyond the number of zones, remaining members fill in
+		if all := r.Owners(key, 5); len(all) != 5 {
+			t.Fatalf("key %s: expected 5 owners, got %d", key, len(all))
+		}
+	}
+}
+
+func TestConcurrentUpdate(t *testing.T) {
+	r := New(Options{VirtualNodes: 10})
+	tasks := makeTasks(20)
+	r.Update(tasks)
+
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Go(func() {
+			for j := range 100 {
+				r.Update(tasks[:1+(i+j)%len(tasks)])
+			}
+		})
+		wg.Go(func() {
+			for j := range 1000 {
+				key := fmt.Sprintf("key-%d", j)
+				if _, found := r.Owner(key); !found {
+					t.Errorf("key %s: expected owner", key)
+					return
+				}
+				if got := r.Owners(key, 3); len(got) == 0 {
+					t.Errorf("key %s: expected owners", key)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+}